@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/vartanbeno/go-reddit/reddit"
+)
+
+// StorageRedis is the storage backend keeping the archive in Redis.
+const StorageRedis = "redis"
+
+// StorageSQLite is the storage backend keeping the archive in a SQLite database.
+const StorageSQLite = "sqlite"
+
+// ErrUnknownStorage is returned when the configured storage backend doesn't exist.
+var ErrUnknownStorage = errors.New("unknown storage backend")
+
+// Archive stores submissions along with everything indexed about them.
+// Sorted sets are returned as redis.Z with submission full IDs as members and their creation epoch as the score.
+type Archive interface {
+	addSubmissions(submissions []PushshiftSubmission) error
+	updateVotes(submissions []PushshiftSubmission) *ContextError
+	setRemoved(submissions []PushshiftSubmission, removedMap map[string]bool) *ContextError
+
+	// getSubmissionIDs returns the full ID of every archived submission.
+	getSubmissionIDs() ([]string, *ContextError)
+	// getSearch, getFlair, getAuthor and getDomain only return the submissions created in the window.
+	getSearch(search string, window TimeRange) ([]redis.Z, *ContextError)
+	// getFlair takes the key of a flair, see Client.flairKey, with "" being the submissions without a flair.
+	getFlair(flairKey string, window TimeRange) ([]redis.Z, *ContextError)
+	// getFlairNames returns the names of the archived flairs as shown, which may differ from their keys.
+	getFlairNames() ([]string, *ContextError)
+	// getFlairStats counts the submissions of every flair key, in no particular order.
+	getFlairStats() ([]FlairStats, *ContextError)
+	// getAuthor is case insensitive as Reddit usernames are.
+	getAuthor(author string, window TimeRange) ([]redis.Z, *ContextError)
+	// getDomain returns the submissions linking to the domain or its subdomains.
+	getDomain(domain string, window TimeRange) ([]redis.Z, *ContextError)
+	// getUpvotes returns a map of full IDs to their last known upvotes.
+	getUpvotes(fullIDs []string) (map[string]float64, *ContextError)
+	// getTopUpvoted returns the known unremoved submissions of the filter created in the window with the most upvotes, scored by their upvotes, highest first.
+	getTopUpvoted(filter TopFilter, window TimeRange, limit int) ([]redis.Z, *ContextError)
+	// getSubmissions returns a map of full IDs to the stored submissions, skipping those not archived.
+	getSubmissions(fullIDs []string) (map[string]PushshiftSubmission, *ContextError)
+	// getLinks returns a map of full IDs to links formatted as [title](permalink).
+	getLinks(fullIDs []string) (map[string]string, *ContextError)
+	// getUnremoved filters the full IDs down to the submissions known to not be removed.
+	getUnremoved(fullIDs []string) ([]string, *ContextError)
+
+	// scanExport calls f with every submission matching the filter, without loading the whole archive into memory.
+	scanExport(filter ExportFilter, f func(record ExportRecord) error) *ContextError
+
+	// compactStorage rewrites stored submissions to only keep the configured fields.
+	compactStorage() *ContextError
+
+	// getStats returns the statistics of a scope, see ArchiveStats. A scope without submissions has no fields.
+	getStats(scope string) (map[string]float64, *ContextError)
+	// replaceStats replaces every statistic with the given ones, and what each submission is counted as by full ID.
+	replaceStats(stats ArchiveStats, counts map[string]StatsCount) *ContextError
+
+	// mergeFlairs indexes the archived flairs under their current keys, merging flairs with the same key.
+	mergeFlairs() *ContextError
+
+	addProcessed(fullIDs []string) *ContextError
+	// getProcessed returns which of the full IDs were processed.
+	getProcessed(fullIDs []string) (map[string]bool, *ContextError)
+	// getReplies returns a map of message full IDs to the full ID of the bot's reply, or "" if a reply was claimed but not recorded as sent.
+	// A reply continued in replies to itself is recorded as the full IDs of every part separated by spaces, see replyWith.
+	// Messages never claimed are missing.
+	getReplies(fullIDs []string) (map[string]string, *ContextError)
+	// setReply records the reply to a message, claiming it before sending with an empty reply ID.
+	setReply(fullID string, replyID string) *ContextError
+	// getReplyCommand returns the command line the bot's reply answered, or "" if it isn't known.
+	getReplyCommand(replyID string) (string, *ContextError)
+	setReplyCommand(replyID string, commandLine string) *ContextError
+	// getSubscriptions returns every subscription, expired ones included.
+	getSubscriptions() ([]Subscription, *ContextError)
+	// setSubscription adds or replaces the subscription of its user to its query, see Subscription.key.
+	setSubscription(subscription Subscription) *ContextError
+	// removeSubscription removes the subscription of the user to the query, returning whether it existed.
+	removeSubscription(user string, query string) (bool, *ContextError)
+	getAnchor(anchorKey string) (*Anchor, *ContextError)
+	setAnchor(anchorKey string, fullID string, timestamp reddit.Timestamp) *ContextError
+
+	Close() error
+}
+
+// TextSearcher is implemented by archives with full text search.
+type TextSearcher interface {
+	// searchText returns the submissions created in the window matching the query scored by their creation epoch, best match first.
+	searchText(query string, limit int, window TimeRange) ([]redis.Z, *ContextError)
+}
+
+// TimeRange is a window of creation epochs, from After inclusive to Before exclusive.
+// An end is unbounded unless its flag is set, as epochs before 1970 are negative and 0 is a date like any other.
+type TimeRange struct {
+	After     float64
+	Before    float64
+	HasAfter  bool
+	HasBefore bool
+}
+
+// timeAfter is the window from the epoch onwards.
+func timeAfter(epoch float64) TimeRange {
+	return TimeRange{After: epoch, HasAfter: true}
+}
+
+// timeBefore is the window up to, but excluding, the epoch.
+func timeBefore(epoch float64) TimeRange {
+	return TimeRange{Before: epoch, HasBefore: true}
+}
+
+// timeBetween is the window from after up to, but excluding, before.
+func timeBetween(after, before float64) TimeRange {
+	return TimeRange{After: after, Before: before, HasAfter: true, HasBefore: true}
+}
+
+// intersect narrows the window to the part also in the other window.
+func (t TimeRange) intersect(other TimeRange) TimeRange {
+	if other.HasAfter && (!t.HasAfter || other.After > t.After) {
+		t.After, t.HasAfter = other.After, true
+	}
+
+	if other.HasBefore && (!t.HasBefore || other.Before < t.Before) {
+		t.Before, t.HasBefore = other.Before, true
+	}
+
+	return t
+}
+
+// zRangeBy is the window as a ZRANGEBYSCORE range.
+func (t TimeRange) zRangeBy() *redis.ZRangeBy {
+	rangeBy := &redis.ZRangeBy{Min: "-inf", Max: "+inf"}
+	if t.HasAfter {
+		rangeBy.Min = strconv.FormatFloat(t.After, 'f', -1, 64)
+	}
+
+	if t.HasBefore {
+		rangeBy.Max = "(" + strconv.FormatFloat(t.Before, 'f', -1, 64)
+	}
+
+	return rangeBy
+}
+
+// bounds returns the window with unbounded ends replaced by the largest floats, for comparing in SQL.
+func (t TimeRange) bounds() (float64, float64) {
+	after, before := -math.MaxFloat64, math.MaxFloat64
+	if t.HasAfter {
+		after = t.After
+	}
+
+	if t.HasBefore {
+		before = t.Before
+	}
+
+	return after, before
+}
+
+// TopFilter picks the submissions ranked by getTopUpvoted: those of every one of the searches, like a phrase in a query, and of any of the flair keys.
+// Every submission is ranked when both are empty.
+type TopFilter struct {
+	Searches  []string
+	FlairKeys []string // See Client.flairKey, with "" being the submissions without a flair.
+}
+
+var _ Archive = &Redis{}
+var _ Archive = &SQLite{}
+var _ TextSearcher = &SQLite{}
+
+// NewArchive opens the storage backend chosen in the config.
+// When Redis is the backend it is also returned, otherwise it is nil.
+func NewArchive(client *Client, config *Config) (Archive, *Redis, error) {
+	switch config.Storage.Backend {
+	case "", StorageRedis:
+		rdb, err := NewRedisClient(client, config)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return rdb, rdb, nil
+	case StorageSQLite:
+		db, err := NewSQLite(client, config)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return db, nil, nil
+	default:
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnknownStorage, config.Storage.Backend)
+	}
+}
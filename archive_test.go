@@ -0,0 +1,370 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/vartanbeno/go-reddit/reddit"
+	"go.uber.org/zap"
+)
+
+// testBackends are the storage backends every Archive is tested against.
+var testBackends = []string{StorageRedis, StorageSQLite}
+
+// testConstants are searches for Python and Django.
+var testConstants = ConstantsConfig{Searches: [][]string{{"Python", "py"}, {"Django"}}}
+
+// newTestClient returns a client with a new, empty archive of the backend: Redis in memory, or SQLite in a temporary directory.
+func newTestClient(t *testing.T, backend string, constants ConstantsConfig) *Client {
+	t.Helper()
+
+	config := &Config{Constants: constants}
+	config.Storage.Backend = backend
+	switch backend {
+	case StorageRedis:
+		server, err := miniredis.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(server.Close)
+		config.Redis.Addr = server.Addr()
+	case StorageSQLite:
+		config.Storage.SQLitePath = filepath.Join(t.TempDir(), "archive.db")
+	}
+
+	c := &Client{Logger: zap.NewNop().Sugar(), Config: config, Flairs: NewFlairLookup(config.Flairs)}
+	c.Search = &Search{client: c, config: config}
+	if err := c.Search.setSearches(constants); err != nil {
+		t.Fatal(err)
+	}
+
+	archive, rdb, err := NewArchive(c, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { archive.Close() })
+	c.Archive, c.Redis = archive, rdb
+
+	return c
+}
+
+// forEachBackend runs the test against a new archive of every backend.
+func forEachBackend(t *testing.T, constants ConstantsConfig, test func(t *testing.T, c *Client)) {
+	for _, backend := range testBackends {
+		t.Run(backend, func(t *testing.T) {
+			test(t, newTestClient(t, backend, constants))
+		})
+	}
+}
+
+// testSubmission returns a submission like Pushshift gives it.
+func testSubmission(id string, title string, flair string, ups int, created float64) PushshiftSubmission {
+	raw := map[string]interface{}{
+		"id":              id,
+		"title":           title,
+		"link_flair_text": flair,
+		"ups":             float64(ups),
+		"created_utc":     created,
+		"permalink":       "/r/test/comments/" + id + "/",
+		"author":          "author_" + id,
+		"url":             "https://example.com/" + id,
+		"domain":          "example.com",
+		"selftext":        "",
+	}
+
+	return PushshiftSubmission{newPushshiftFields(raw), raw}
+}
+
+// testSubmissions are three submissions about Python, Django and neither, created at 1000, 2000 and 3000.
+var testSubmissions = []PushshiftSubmission{
+	testSubmission("a", "Learning Python", "Help", 10, 1000),
+	testSubmission("b", "Unrelated post", "", 5, 2000),
+	testSubmission("c", "Python and Django", "Solved", 20, 3000),
+}
+
+// members returns the sorted members of a sorted set.
+func members(zs []redis.Z) []string {
+	fullIDs := make([]string, len(zs))
+	for i, z := range zs {
+		fullIDs[i] = fmt.Sprint(z.Member)
+	}
+	sort.Strings(fullIDs)
+
+	return fullIDs
+}
+
+// checkMembers fails the test unless the archive returned the full IDs.
+func checkMembers(t *testing.T, name string, zs []redis.Z, ce *ContextError, want ...string) {
+	t.Helper()
+	if ce != nil {
+		t.Fatalf("%s: %v", name, ce)
+	}
+
+	if got := members(zs); !reflect.DeepEqual(got, append([]string{}, want...)) {
+		t.Errorf("%s = %q, want %q", name, got, want)
+	}
+}
+
+func TestArchiveSubmissions(t *testing.T) {
+	forEachBackend(t, testConstants, func(t *testing.T, c *Client) {
+		if err := c.Archive.addSubmissions(testSubmissions); err != nil {
+			t.Fatal(err)
+		}
+
+		ids, ce := c.Archive.getSubmissionIDs()
+		if ce != nil {
+			t.Fatal(ce)
+		}
+		sort.Strings(ids)
+		if want := []string{"t3_a", "t3_b", "t3_c"}; !reflect.DeepEqual(ids, want) {
+			t.Errorf("getSubmissionIDs() = %q, want %q", ids, want)
+		}
+
+		submissions, ce := c.Archive.getSubmissions([]string{"t3_a", "t3_missing"})
+		if ce != nil {
+			t.Fatal(ce)
+		}
+		if len(submissions) != 1 || submissions["t3_a"].Title != "Learning Python" {
+			t.Errorf("getSubmissions() = %v, want only t3_a", submissions)
+		}
+
+		zs, ce := c.Archive.getSearch("Python", TimeRange{})
+		checkMembers(t, "getSearch(Python)", zs, ce, "t3_a", "t3_c")
+		zs, ce = c.Archive.getSearch("Python", timeAfter(1500))
+		checkMembers(t, "getSearch(Python, after 1500)", zs, ce, "t3_c")
+		zs, ce = c.Archive.getSearch("Django", timeBefore(3000))
+		checkMembers(t, "getSearch(Django, before 3000)", zs, ce)
+
+		zs, ce = c.Archive.getFlair(c.flairKey("Help"), TimeRange{})
+		checkMembers(t, "getFlair(Help)", zs, ce, "t3_a")
+		zs, ce = c.Archive.getFlair("", TimeRange{})
+		checkMembers(t, "getFlair(unflaired)", zs, ce, "t3_b")
+
+		zs, ce = c.Archive.getAuthor("AUTHOR_A", TimeRange{})
+		checkMembers(t, "getAuthor(AUTHOR_A)", zs, ce, "t3_a")
+		zs, ce = c.Archive.getDomain("example.com", timeBetween(1000, 3000))
+		checkMembers(t, "getDomain(example.com, 1000 to 3000)", zs, ce, "t3_a", "t3_b")
+
+		names, ce := c.Archive.getFlairNames()
+		if ce != nil {
+			t.Fatal(ce)
+		}
+		sort.Strings(names)
+		if want := []string{"Help", "Solved"}; !reflect.DeepEqual(names, want) {
+			t.Errorf("getFlairNames() = %q, want %q", names, want)
+		}
+
+		links, ce := c.Archive.getLinks([]string{"t3_a"})
+		if ce != nil {
+			t.Fatal(ce)
+		}
+		if want := markdownLink("Learning Python", "/r/test/comments/a/"); links["t3_a"] != want {
+			t.Errorf("getLinks() = %q, want %q", links["t3_a"], want)
+		}
+
+		upvotes, ce := c.Archive.getUpvotes([]string{"t3_a", "t3_c"})
+		if ce != nil {
+			t.Fatal(ce)
+		}
+		if want := map[string]float64{"t3_a": 10, "t3_c": 20}; !reflect.DeepEqual(upvotes, want) {
+			t.Errorf("getUpvotes() = %v, want %v", upvotes, want)
+		}
+
+		// Submissions are unremoved until found removed.
+		unremoved, ce := c.Archive.getUnremoved([]string{"t3_a", "t3_b", "t3_c"})
+		if ce != nil {
+			t.Fatal(ce)
+		}
+		if want := []string{"t3_a", "t3_b", "t3_c"}; !reflect.DeepEqual(unremoved, want) {
+			t.Errorf("getUnremoved() before removals = %q, want %q", unremoved, want)
+		}
+
+		if ce := c.Archive.setRemoved(testSubmissions[:2], map[string]bool{"a": true, "b": false}); ce != nil {
+			t.Fatal(ce)
+		}
+
+		unremoved, ce = c.Archive.getUnremoved([]string{"t3_a", "t3_b", "t3_c"})
+		if ce != nil {
+			t.Fatal(ce)
+		}
+		if want := []string{"t3_b", "t3_c"}; !reflect.DeepEqual(unremoved, want) {
+			t.Errorf("getUnremoved() = %q, want %q", unremoved, want)
+		}
+	})
+}
+
+func TestArchiveFlairStats(t *testing.T) {
+	forEachBackend(t, testConstants, func(t *testing.T, c *Client) {
+		submissions := append(testSubmissions, testSubmission("d", "Another question", "Help", 1, 4000))
+		if err := c.Archive.addSubmissions(submissions); err != nil {
+			t.Fatal(err)
+		}
+
+		stats, ce := c.Archive.getFlairStats()
+		if ce != nil {
+			t.Fatal(ce)
+		}
+
+		got := make(map[string]FlairStats, len(stats))
+		for _, flair := range stats {
+			got[flair.Key] = flair
+		}
+
+		want := map[string]FlairStats{
+			c.flairKey("Help"):   {Key: c.flairKey("Help"), Name: "Help", Count: 2, First: 1000, Last: 4000},
+			c.flairKey("Solved"): {Key: c.flairKey("Solved"), Name: "Solved", Count: 1, First: 3000, Last: 3000},
+			"":                   {Key: "", Name: FlairsDefaultUnflaired, Count: 1, First: 2000, Last: 2000},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("getFlairStats() = %+v, want %+v", got, want)
+		}
+	})
+}
+
+func TestArchiveInbox(t *testing.T) {
+	forEachBackend(t, testConstants, func(t *testing.T, c *Client) {
+		if ce := c.Archive.addProcessed([]string{"t1_x"}); ce != nil {
+			t.Fatal(ce)
+		}
+
+		processed, ce := c.Archive.getProcessed([]string{"t1_x", "t1_y"})
+		if ce != nil {
+			t.Fatal(ce)
+		}
+		if !processed["t1_x"] || processed["t1_y"] {
+			t.Errorf("getProcessed() = %v, want only t1_x", processed)
+		}
+
+		// A reply is claimed with an empty reply ID before it's sent.
+		for _, replyID := range []string{"", "t1_r1 t1_r2"} {
+			if ce := c.Archive.setReply("t1_x", replyID); ce != nil {
+				t.Fatal(ce)
+			}
+
+			replies, ce := c.Archive.getReplies([]string{"t1_x", "t1_y"})
+			if ce != nil {
+				t.Fatal(ce)
+			}
+			if want := map[string]string{"t1_x": replyID}; !reflect.DeepEqual(replies, want) {
+				t.Errorf("getReplies() = %q, want %q", replies, want)
+			}
+		}
+
+		if ce := c.Archive.setReplyCommand("t1_r1", "search python"); ce != nil {
+			t.Fatal(ce)
+		}
+
+		for replyID, want := range map[string]string{"t1_r1": "search python", "t1_r3": ""} {
+			commandLine, ce := c.Archive.getReplyCommand(replyID)
+			if ce != nil {
+				t.Fatal(ce)
+			}
+			if commandLine != want {
+				t.Errorf("getReplyCommand(%s) = %q, want %q", replyID, commandLine, want)
+			}
+		}
+	})
+}
+
+func TestArchiveAnchors(t *testing.T) {
+	forEachBackend(t, testConstants, func(t *testing.T, c *Client) {
+		epoch := reddit.Timestamp{Time: time.Unix(1000, 0).UTC()}
+		if ce := c.Archive.setAnchor(RedisSearchCurrent, "t3_abcdef", epoch); ce != nil {
+			t.Fatal(ce)
+		}
+
+		anchor, ce := c.Archive.getAnchor(RedisSearchCurrent)
+		if ce != nil {
+			t.Fatal(ce)
+		}
+		if anchor.FullID != "t3_abcdef" || !anchor.Epoch.Time.Equal(epoch.Time) {
+			t.Errorf("getAnchor() = %v, want t3_abcdef at %v", anchor, epoch)
+		}
+	})
+}
+
+func TestMigrateSQLite(t *testing.T) {
+	c := newTestClient(t, StorageRedis, testConstants)
+	if err := c.Archive.addSubmissions(testSubmissions); err != nil {
+		t.Fatal(err)
+	}
+
+	if ce := c.Archive.setRemoved(testSubmissions, map[string]bool{"a": true}); ce != nil {
+		t.Fatal(ce)
+	}
+
+	if ce := c.Archive.addProcessed([]string{"t1_x"}); ce != nil {
+		t.Fatal(ce)
+	}
+
+	if ce := c.Archive.setReply("t1_x", "t1_r1"); ce != nil {
+		t.Fatal(ce)
+	}
+
+	c.Config.Storage.SQLitePath = filepath.Join(t.TempDir(), "archive.db")
+	db, err := NewSQLite(c, c.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if ce := MigrateSQLiteCommand(&Client{Logger: c.Logger, Config: c.Config, Flairs: c.Flairs, Archive: db, Redis: c.Redis}, nil); ce != nil {
+		t.Fatal(ce)
+	}
+
+	zs, ce := db.getSearch("Python", TimeRange{})
+	checkMembers(t, "getSearch(Python)", zs, ce, "t3_a", "t3_c")
+
+	unremoved, ce := db.getUnremoved([]string{"t3_a", "t3_b", "t3_c"})
+	if ce != nil {
+		t.Fatal(ce)
+	}
+	if want := []string{"t3_b", "t3_c"}; !reflect.DeepEqual(unremoved, want) {
+		t.Errorf("getUnremoved() = %q, want %q", unremoved, want)
+	}
+
+	upvotes, ce := db.getUpvotes([]string{"t3_a", "t3_b", "t3_c"})
+	if ce != nil {
+		t.Fatal(ce)
+	}
+	if want := map[string]float64{"t3_a": 10, "t3_b": 5, "t3_c": 20}; !reflect.DeepEqual(upvotes, want) {
+		t.Errorf("getUpvotes() = %v, want %v", upvotes, want)
+	}
+
+	processed, ce := db.getProcessed([]string{"t1_x"})
+	if ce != nil {
+		t.Fatal(ce)
+	}
+	if !processed["t1_x"] {
+		t.Error("t1_x isn't processed")
+	}
+
+	replies, ce := db.getReplies([]string{"t1_x"})
+	if ce != nil {
+		t.Fatal(ce)
+	}
+	if replies["t1_x"] != "t1_r1" {
+		t.Errorf("getReplies() = %q, want t1_r1", replies)
+	}
+
+	for _, scope := range []string{StatsScopeAll, StatsScopeSearchPrefix + "Python"} {
+		want, ce := c.Archive.getStats(scope)
+		if ce != nil {
+			t.Fatal(ce)
+		}
+
+		got, ce := db.getStats(scope)
+		if ce != nil {
+			t.Fatal(ce)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("getStats(%s) = %v, want %v", scope, got, want)
+		}
+	}
+}
@@ -0,0 +1,587 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// BackupFormat identifies a backup file.
+const BackupFormat = "ArchiveBot backup"
+
+// BackupVersion is the version of the backup format written.
+// Restoring supports every version up to and including it.
+// Version 1 stored hash values as strings, version 2 base64 encodes them.
+const BackupVersion = 2
+
+// BackupChunk is the most entries of a key written in a single backup record.
+const BackupChunk = 500
+
+// BackupFilePrefix is the prefix of scheduled backup file names, followed by the time they were made.
+const BackupFilePrefix = "ArchiveBot-"
+
+// BackupFileExtension is the extension of backup files.
+const BackupFileExtension = ".backup.gz"
+
+// ErrBackupInvalid is returned when a backup can't be restored.
+var ErrBackupInvalid = errors.New("invalid backup")
+
+// ErrRestoreNotEmpty is returned when restoring into a database that already has ArchiveBot keys.
+var ErrRestoreNotEmpty = errors.New("database is not empty")
+
+// ErrRestoreMismatch is returned when the restored keys don't match the manifest.
+var ErrRestoreMismatch = errors.New("restored keys do not match the manifest")
+
+// ErrBackupRedisOnly is returned when backing up an archive not stored in Redis.
+var ErrBackupRedisOnly = errors.New("backups require the Redis storage backend")
+
+// BackupConfig schedules backups of the Redis archive.
+type BackupConfig struct {
+	Interval  time.Duration `toml:"interval"`
+	Directory string        `toml:"directory"`
+	Keep      int           `toml:"keep"`
+}
+
+// backupHeader is the first line of a backup.
+type backupHeader struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+	Created int64  `json:"created"`
+}
+
+// backupRecord is a line of a backup holding part of a key, or the manifest as the last line.
+// Keys are named without the hash tag so backups can be restored into a cluster and back.
+// Hash values are base64 encoded as submissions are stored in binary.
+// Hashes, sets and sorted sets larger than BackupChunk are split across several records.
+type backupRecord struct {
+	Key      string                    `json:"key,omitempty"`
+	Type     string                    `json:"type,omitempty"`
+	String   *string                   `json:"string,omitempty"`
+	Hash     map[string][]byte         `json:"hash,omitempty"`
+	Set      []string                  `json:"set,omitempty"`
+	ZSet     map[string]float64        `json:"zset,omitempty"`
+	Manifest map[string]*backupSummary `json:"manifest,omitempty"`
+}
+
+// backupRecordV1 is a backupRecord of a version 1 backup, whose hash values are strings.
+type backupRecordV1 struct {
+	backupRecord
+	Hash map[string]string `json:"hash,omitempty"`
+}
+
+// record converts the record to the current version.
+func (v backupRecordV1) record() backupRecord {
+	record := v.backupRecord
+	if v.Hash != nil {
+		record.Hash = make(map[string][]byte, len(v.Hash))
+		for field, value := range v.Hash {
+			record.Hash[field] = []byte(value)
+		}
+	}
+
+	return record
+}
+
+// decodeBackupRecord reads the next record of a backup of the version.
+func decodeBackupRecord(decoder *json.Decoder, version int) (backupRecord, error) {
+	if version == 1 {
+		var v1 backupRecordV1
+		err := decoder.Decode(&v1)
+		return v1.record(), err
+	}
+
+	var record backupRecord
+	err := decoder.Decode(&record)
+	return record, err
+}
+
+// backupSummary describes a key so a restore can be verified.
+// The digest is independent of the order the entries are read in.
+type backupSummary struct {
+	Type   string `json:"type"`
+	Count  int64  `json:"count"`
+	Digest string `json:"digest"`
+}
+
+// add includes the entries of a record in the summary.
+func (b *backupSummary) add(record *backupRecord) {
+	digest := make([]byte, sha256.Size)
+	if b.Digest != "" {
+		digest, _ = hex.DecodeString(b.Digest)
+	}
+
+	addEntry := func(parts ...string) {
+		b.Count++
+		sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+		for i := range digest {
+			digest[i] ^= sum[i]
+		}
+	}
+
+	if record.String != nil {
+		addEntry(*record.String)
+	}
+
+	for field, value := range record.Hash {
+		addEntry(field, string(value))
+	}
+
+	for _, member := range record.Set {
+		addEntry(member)
+	}
+
+	for member, score := range record.ZSet {
+		addEntry(member, strconv.FormatFloat(score, 'g', -1, 64))
+	}
+
+	b.Digest = hex.EncodeToString(digest)
+}
+
+// getArchiveKeys returns every existing key used by ArchiveBot.
+func (r *Redis) getArchiveKeys() ([]string, *ContextError) {
+	var keys []string
+	for _, key := range RedisKeys {
+		exists, err := r.Exists(ctx, r.key(key)).Result()
+		if err != nil {
+			return nil, NewWrappedError("could not check key", err, []ContextParam{
+				{"Key", key},
+			})
+		}
+
+		if exists != 0 {
+			keys = append(keys, key)
+		}
+	}
+
+	for _, prefix := range RedisKeyPrefixes {
+		ce := r.scanKeys(prefix, func(key string) error {
+			keys = append(keys, key)
+			return nil
+		})
+		if ce != nil {
+			return nil, ce
+		}
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// scanBackupRecords calls f with the records holding the whole key.
+func (r *Redis) scanBackupRecords(key string, f func(record *backupRecord) error) error {
+	keyType, err := r.Type(ctx, r.key(key)).Result()
+	if err != nil {
+		return err
+	}
+
+	switch keyType {
+	case "string":
+		value, err := r.Get(ctx, r.key(key)).Result()
+		if err != nil {
+			return err
+		}
+
+		return f(&backupRecord{Key: key, Type: keyType, String: &value})
+	case "hash":
+		record := &backupRecord{Key: key, Type: keyType, Hash: make(map[string][]byte)}
+		ce := r.scanHash(key, func(field, value string) error {
+			record.Hash[field] = []byte(value)
+			if len(record.Hash) < BackupChunk {
+				return nil
+			}
+
+			err := f(record)
+			record = &backupRecord{Key: key, Type: keyType, Hash: make(map[string][]byte)}
+			return err
+		})
+		if ce != nil {
+			return ce
+		}
+
+		return f(record)
+	case "set":
+		record := &backupRecord{Key: key, Type: keyType}
+		iter := r.SScan(ctx, r.key(key), 0, "", BackupChunk).Iterator()
+		for iter.Next(ctx) {
+			record.Set = append(record.Set, iter.Val())
+			if len(record.Set) < BackupChunk {
+				continue
+			}
+
+			if err := f(record); err != nil {
+				return err
+			}
+			record = &backupRecord{Key: key, Type: keyType}
+		}
+
+		if err := iter.Err(); err != nil {
+			return err
+		}
+
+		return f(record)
+	case "zset":
+		record := &backupRecord{Key: key, Type: keyType, ZSet: make(map[string]float64)}
+		iter := r.ZScan(ctx, r.key(key), 0, "", BackupChunk).Iterator()
+		for iter.Next(ctx) {
+			member := iter.Val()
+			if !iter.Next(ctx) {
+				break
+			}
+
+			score, err := strconv.ParseFloat(iter.Val(), 64)
+			if err != nil {
+				return err
+			}
+
+			record.ZSet[member] = score
+			if len(record.ZSet) < BackupChunk {
+				continue
+			}
+
+			if err := f(record); err != nil {
+				return err
+			}
+			record = &backupRecord{Key: key, Type: keyType, ZSet: make(map[string]float64)}
+		}
+
+		if err := iter.Err(); err != nil {
+			return err
+		}
+
+		return f(record)
+	case "none":
+		// The key was deleted after it was listed.
+		return nil
+	default:
+		return fmt.Errorf("unsupported key type %s", keyType)
+	}
+}
+
+// summarize reads the current state of every key for the manifest.
+func (r *Redis) summarize(keys []string) (map[string]*backupSummary, *ContextError) {
+	manifest := make(map[string]*backupSummary, len(keys))
+	for _, key := range keys {
+		err := r.scanBackupRecords(key, func(record *backupRecord) error {
+			summary, ok := manifest[key]
+			if !ok {
+				summary = &backupSummary{Type: record.Type}
+				manifest[key] = summary
+			}
+
+			summary.add(record)
+			return nil
+		})
+		if err != nil {
+			return nil, NewWrappedError("could not summarize key", err, []ContextParam{
+				{"Key", key},
+			})
+		}
+	}
+
+	return manifest, nil
+}
+
+// backup writes every ArchiveBot key to w as gzipped lines of JSON, ending with the manifest.
+func (r *Redis) backup(w io.Writer) (map[string]*backupSummary, *ContextError) {
+	keys, ce := r.getArchiveKeys()
+	if ce != nil {
+		return nil, ce
+	}
+
+	gz := gzip.NewWriter(w)
+	encoder := json.NewEncoder(gz)
+	if err := encoder.Encode(backupHeader{BackupFormat, BackupVersion, time.Now().Unix()}); err != nil {
+		return nil, NewWrappedError("could not write backup header", err, nil)
+	}
+
+	manifest := make(map[string]*backupSummary, len(keys))
+	for _, key := range keys {
+		err := r.scanBackupRecords(key, func(record *backupRecord) error {
+			summary, ok := manifest[key]
+			if !ok {
+				summary = &backupSummary{Type: record.Type}
+				manifest[key] = summary
+			}
+
+			summary.add(record)
+			return encoder.Encode(record)
+		})
+		if err != nil {
+			return nil, NewWrappedError("could not back up key", err, []ContextParam{
+				{"Key", key},
+			})
+		}
+	}
+
+	if err := encoder.Encode(backupRecord{Manifest: manifest}); err != nil {
+		return nil, NewWrappedError("could not write backup manifest", err, nil)
+	}
+
+	if err := gz.Close(); err != nil {
+		return nil, NewWrappedError("could not finish backup", err, nil)
+	}
+
+	return manifest, nil
+}
+
+// restore loads a backup into a database without any ArchiveBot keys and verifies it against the manifest.
+// The schema version written when connecting is replaced by the backup's, and a backup of an older version is upgraded once restored.
+func (r *Redis) restore(rd io.Reader) *ContextError {
+	keys, ce := r.getArchiveKeys()
+	if ce != nil {
+		return ce
+	}
+
+	if len(keys) != 0 && !(len(keys) == 1 && keys[0] == RedisSchema) {
+		return NewContextError(ErrRestoreNotEmpty, []ContextParam{
+			{"Existing Keys", fmt.Sprint(keys)},
+		})
+	}
+
+	if err := r.Del(ctx, r.key(RedisSchema)).Err(); err != nil {
+		return NewContextError(err, []ContextParam{
+			{"Redis Key", RedisSchema},
+		})
+	}
+
+	gz, err := gzip.NewReader(bufio.NewReader(rd))
+	if err != nil {
+		return NewWrappedError("could not decompress backup", err, nil)
+	}
+	defer gz.Close()
+
+	decoder := json.NewDecoder(gz)
+	var header backupHeader
+	if err := decoder.Decode(&header); err != nil {
+		return NewWrappedError("could not read backup header", err, nil)
+	}
+
+	if header.Format != BackupFormat || header.Version < 1 || header.Version > BackupVersion {
+		return NewContextError(ErrBackupInvalid, []ContextParam{
+			{"Format", header.Format},
+			{"Version", fmt.Sprint(header.Version)},
+		})
+	}
+
+	var manifest map[string]*backupSummary
+	for manifest == nil {
+		record, err := decodeBackupRecord(decoder, header.Version)
+		if err == io.EOF {
+			return NewWrappedError("backup has no manifest", ErrBackupInvalid, nil)
+		} else if err != nil {
+			return NewWrappedError("could not read backup", err, nil)
+		}
+
+		if record.Manifest != nil {
+			manifest = record.Manifest
+			continue
+		}
+
+		if err := r.restoreRecord(&record); err != nil {
+			return NewWrappedError("could not restore key", err, []ContextParam{
+				{"Key", record.Key},
+			})
+		}
+	}
+
+	manifestKeys := make([]string, 0, len(manifest))
+	for key := range manifest {
+		manifestKeys = append(manifestKeys, key)
+	}
+
+	restored, ce := r.summarize(manifestKeys)
+	if ce != nil {
+		return ce
+	}
+
+	for key, expected := range manifest {
+		actual, ok := restored[key]
+		if !ok || *actual != *expected {
+			return NewContextError(ErrRestoreMismatch, []ContextParam{
+				{"Key", key},
+				{"Expected", fmt.Sprintf("%+v", *expected)},
+				{"Restored", fmt.Sprintf("%+v", actual)},
+			})
+		}
+	}
+
+	r.client.Logger.Infof("Restored and verified %d keys from a backup made %s.", len(manifest), time.Unix(header.Created, 0))
+
+	return r.upgradeSchema()
+}
+
+func (r *Redis) restoreRecord(record *backupRecord) error {
+	switch record.Type {
+	case "string":
+		if record.String == nil {
+			return ErrBackupInvalid
+		}
+
+		return r.Set(ctx, r.key(record.Key), *record.String, 0).Err()
+	case "hash":
+		if len(record.Hash) == 0 {
+			return nil
+		}
+
+		values := make([]interface{}, 0, len(record.Hash)*2)
+		for field, value := range record.Hash {
+			values = append(values, field, value)
+		}
+
+		return r.HSet(ctx, r.key(record.Key), values...).Err()
+	case "set":
+		if len(record.Set) == 0 {
+			return nil
+		}
+
+		return r.SAdd(ctx, r.key(record.Key), record.Set).Err()
+	case "zset":
+		members := make([]*redis.Z, 0, len(record.ZSet))
+		for member, score := range record.ZSet {
+			members = append(members, &redis.Z{Score: score, Member: member})
+		}
+
+		if len(members) == 0 {
+			return nil
+		}
+
+		return r.ZAdd(ctx, r.key(record.Key), members...).Err()
+	default:
+		return fmt.Errorf("%w: unsupported key type %s", ErrBackupInvalid, record.Type)
+	}
+}
+
+// backupToFile writes a backup to the path.
+func (r *Redis) backupToFile(path string) *ContextError {
+	file, err := os.Create(path)
+	if err != nil {
+		return NewContextlessError(err)
+	}
+
+	manifest, ce := r.backup(file)
+	if err := file.Close(); err != nil && ce == nil {
+		ce = NewContextlessError(err)
+	}
+
+	if ce != nil {
+		os.Remove(path)
+		return ce.AddContext("Path", path)
+	}
+
+	r.client.Logger.Infof("Backed up %d keys to %s.", len(manifest), path)
+
+	return nil
+}
+
+// BackupCommand writes a backup of the Redis archive.
+func BackupCommand(c *Client, args []string) *ContextError {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	output := flags.String("output", BackupFilePrefix+time.Now().UTC().Format("20060102T150405Z")+BackupFileExtension, "The file to write the backup to.")
+	if err := flags.Parse(args); err != nil {
+		return NewContextlessError(err)
+	}
+
+	if c.Redis == nil {
+		return NewContextlessError(ErrBackupRedisOnly)
+	}
+
+	return c.Redis.backupToFile(*output)
+}
+
+// RestoreCommand loads a backup into an empty Redis database.
+func RestoreCommand(c *Client, args []string) *ContextError {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return NewContextlessError(err)
+	}
+
+	if flags.NArg() != 1 {
+		return NewContextlessError(errors.New("expected the backup file to restore"))
+	}
+
+	if c.Redis == nil {
+		return NewContextlessError(ErrBackupRedisOnly)
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return NewContextlessError(err)
+	}
+	defer file.Close()
+
+	return c.Redis.restore(file)
+}
+
+// BackupArchive makes scheduled backups, if enabled.
+// It runs on its own ticker rather than taking turns with the other routines, as backups are neither rate limited nor frequent.
+func (c *Client) BackupArchive() {
+	config := c.Config.Backup
+	if config.Interval <= 0 || c.Redis == nil {
+		return
+	}
+
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+
+	for !c.closed {
+		if ce := c.backupScheduled(); ce != nil {
+			c.dfatal(ce)
+		}
+
+		<-ticker.C
+	}
+}
+
+// backupScheduled writes a backup to the backup directory, removing the oldest past Backup.keep.
+func (c *Client) backupScheduled() *ContextError {
+	config := c.Config.Backup
+	directory := config.Directory
+	if directory == "" {
+		directory = "backups"
+	}
+
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return NewContextlessError(err)
+	}
+
+	name := BackupFilePrefix + time.Now().UTC().Format("20060102T150405Z") + BackupFileExtension
+	if ce := c.Redis.backupToFile(filepath.Join(directory, name)); ce != nil {
+		return ce
+	}
+
+	if config.Keep <= 0 {
+		return nil
+	}
+
+	// The names sort by the time they were made.
+	backups, err := filepath.Glob(filepath.Join(directory, BackupFilePrefix+"*"+BackupFileExtension))
+	if err != nil {
+		return NewContextlessError(err)
+	}
+	sort.Strings(backups)
+
+	for len(backups) > config.Keep {
+		c.Logger.Infof("Removing old backup %s.", backups[0])
+		if err := os.Remove(backups[0]); err != nil {
+			return NewContextlessError(err)
+		}
+
+		backups = backups[1:]
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vartanbeno/go-reddit/reddit"
+)
+
+// summarizeArchive returns the summary of every key in the archive.
+func summarizeArchive(t *testing.T, r *Redis) map[string]*backupSummary {
+	t.Helper()
+
+	keys, ce := r.getArchiveKeys()
+	if ce != nil {
+		t.Fatal(ce)
+	}
+
+	summaries, ce := r.summarize(keys)
+	if ce != nil {
+		t.Fatal(ce)
+	}
+
+	return summaries
+}
+
+func TestBackupRestore(t *testing.T) {
+	c := newTestClient(t, StorageRedis, testConstants)
+	if err := c.Archive.addSubmissions(testSubmissions); err != nil {
+		t.Fatal(err)
+	}
+
+	if ce := c.Archive.setRemoved(testSubmissions, map[string]bool{"a": true}); ce != nil {
+		t.Fatal(ce)
+	}
+
+	if ce := c.Archive.addProcessed([]string{"t4_a", "t4_b"}); ce != nil {
+		t.Fatal(ce)
+	}
+
+	if ce := c.Archive.setAnchor(RedisSearchCurrent, "t3_abcdef", reddit.Timestamp{Time: time.Unix(1000, 0)}); ce != nil {
+		t.Fatal(ce)
+	}
+
+	var backup bytes.Buffer
+	manifest, ce := c.Redis.backup(&backup)
+	if ce != nil {
+		t.Fatal(ce)
+	}
+
+	archived := summarizeArchive(t, c.Redis)
+	if !reflect.DeepEqual(manifest, archived) {
+		t.Errorf("backup manifest = %v, want the archive's %v", manifest, archived)
+	}
+
+	restored := newTestClient(t, StorageRedis, testConstants)
+	if ce := restored.Redis.restore(bytes.NewReader(backup.Bytes())); ce != nil {
+		t.Fatal(ce)
+	}
+
+	if got := summarizeArchive(t, restored.Redis); !reflect.DeepEqual(got, archived) {
+		t.Errorf("restored archive = %v, want %v", got, archived)
+	}
+
+	zs, ce := restored.Archive.getSearch("Python", TimeRange{})
+	checkMembers(t, "restored getSearch(Python)", zs, ce, "t3_a", "t3_c")
+
+	// A database with an archive in it isn't restored into.
+	ce = restored.Redis.restore(bytes.NewReader(backup.Bytes()))
+	if ce == nil || !errors.Is(ce, ErrRestoreNotEmpty) {
+		t.Errorf("restore into an archive = %v, want %v", ce, ErrRestoreNotEmpty)
+	}
+}
+
+// gzipLines compresses the lines of a handwritten backup.
+func gzipLines(t *testing.T, lines ...string) io.Reader {
+	t.Helper()
+
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	if _, err := io.WriteString(gz, strings.Join(lines, "\n")+"\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return &b
+}
+
+func TestRestoreVersion1(t *testing.T) {
+	// Version 1 backups have their hash values as strings rather than base64.
+	record := backupRecord{Key: RedisLinks, Type: "hash", Hash: map[string][]byte{"t3_a": []byte("[Learning Python](/r/test/comments/a/)")}}
+	summary := &backupSummary{Type: "hash"}
+	summary.add(&record)
+
+	c := newTestClient(t, StorageRedis, testConstants)
+	ce := c.Redis.restore(gzipLines(t,
+		`{"format":"ArchiveBot backup","version":1,"created":0}`,
+		`{"key":"links","type":"hash","hash":{"t3_a":"[Learning Python](/r/test/comments/a/)"}}`,
+		`{"manifest":{"links":{"type":"hash","count":1,"digest":"`+summary.Digest+`"}}}`,
+	))
+	if ce != nil {
+		t.Fatal(ce)
+	}
+
+	links, ce := c.Archive.getLinks([]string{"t3_a"})
+	if ce != nil {
+		t.Fatal(ce)
+	}
+
+	if want := map[string]string{"t3_a": "[Learning Python](/r/test/comments/a/)"}; !reflect.DeepEqual(links, want) {
+		t.Errorf("restored links = %q, want %q", links, want)
+	}
+}
+
+func TestRestoreInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  error
+	}{
+		{"format", []string{`{"format":"Something else","version":1,"created":0}`}, ErrBackupInvalid},
+		{"newer version", []string{`{"format":"ArchiveBot backup","version":99,"created":0}`}, ErrBackupInvalid},
+		{"no manifest", []string{`{"format":"ArchiveBot backup","version":2,"created":0}`, `{"key":"processed","type":"set","set":["t4_a"]}`}, ErrBackupInvalid},
+		{"mismatch", []string{
+			`{"format":"ArchiveBot backup","version":2,"created":0}`,
+			`{"key":"processed","type":"set","set":["t4_a"]}`,
+			`{"manifest":{"processed":{"type":"set","count":2,"digest":""}}}`,
+		}, ErrRestoreMismatch},
+	}
+
+	for _, test := range tests {
+		c := newTestClient(t, StorageRedis, testConstants)
+		if ce := c.Redis.restore(gzipLines(t, test.lines...)); ce == nil || !errors.Is(ce, test.want) {
+			t.Errorf("restore with %s = %v, want %v", test.name, ce, test.want)
+		}
+	}
+}
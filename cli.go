@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// CommandLine is a one-shot command run from the command line instead of the bot.
+type CommandLine struct {
+	Usage       string
+	Description string
+	Run         func(c *Client, args []string) *ContextError
+}
+
+// commandLines are the commands that may be given as the first argument.
+var commandLines = map[string]CommandLine{
+	"migrate-sqlite": {
+		"migrate-sqlite",
+		"Copy the Redis archive into the SQLite database at Storage.sqlite_path.",
+		MigrateSQLiteCommand,
+	},
+	"export": {
+		"export [-format ndjson|csv|parquet] [-output file] [-after date] [-before date] [-flair name] [-search term]",
+		"Export the archive with upvotes, removal state, flair and search terms.",
+		ExportCommand,
+	},
+	"compact": {
+		"compact",
+		"Rewrite stored submissions to only keep Storage.stored_fields, converting data stored by older versions.",
+		CompactCommand,
+	},
+	"backup": {
+		"backup [-output file]",
+		"Write every ArchiveBot key in Redis to a compressed backup file.",
+		BackupCommand,
+	},
+	"restore": {
+		"restore file",
+		"Load a backup into an empty Redis database and verify it against the backup's manifest.",
+		RestoreCommand,
+	},
+	"merge-flairs": {
+		"merge-flairs [-from flair -to flair]",
+		"Index existing flairs by their normalized names, which archives from older versions need, merging the flairs Flairs.renames and Flairs.aliases make the same.",
+		MergeFlairsCommand,
+	},
+	"rebuild-stats": {
+		"rebuild-stats",
+		"Count the statistics shown by the stats command from the whole archive, which archives from older versions need.",
+		RebuildStatsCommand,
+	},
+}
+
+// printUsage prints the flags and every command line.
+func printUsage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] [command]\n\nWithout a command the bot is run.\n\nFlags:\n", os.Args[0])
+	flag.PrintDefaults()
+
+	names := make([]string, 0, len(commandLines))
+	for name := range commandLines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(out, "\nCommands:")
+	for _, name := range names {
+		command := commandLines[name]
+		fmt.Fprintf(out, "  %s\n    \t%s\n", command.Usage, command.Description)
+	}
+}
+
+// runCommandLine runs a command line with an offline client, exiting on failure.
+func runCommandLine(configPath string, name string, args []string) {
+	command, ok := commandLines[name]
+	if !ok {
+		fmt.Fprintf(flag.CommandLine.Output(), "Unknown command %q.\n\n", name)
+		printUsage()
+		os.Exit(2)
+	}
+
+	client := NewOfflineClient(configPath)
+	defer client.Close()
+
+	if ce := command.Run(client, args); ce != nil {
+		client.fatal(ce)
+	}
+}
+
+// MigrateSQLiteCommand copies the Redis archive into SQLite.
+func MigrateSQLiteCommand(c *Client, args []string) *ContextError {
+	rdb := c.Redis
+	if rdb == nil {
+		var err error
+		rdb, err = NewRedisClient(c, c.Config)
+		if err != nil {
+			return NewWrappedError("could not create Redis client", err, nil)
+		}
+		defer rdb.Close()
+	}
+
+	db, ok := c.Archive.(*SQLite)
+	if !ok {
+		var err error
+		db, err = NewSQLite(c, c.Config)
+		if err != nil {
+			return NewWrappedError("could not open SQLite", err, nil)
+		}
+		defer db.Close()
+	}
+
+	return db.copyRedis(rdb)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"log"
+	"sync"
 
 	"go.uber.org/zap"
 )
@@ -11,12 +12,18 @@ type Client struct {
 	*Flags
 	Logger          *zap.SugaredLogger
 	Config          *Config
-	Redis           *Redis
+	Flairs          *FlairLookup // Config.Flairs normalized.
+	Archive         Archive
+	Redis           *Redis // Only set when Redis is the archive.
 	Reddit          *Reddit
 	Search          *Search
+	Replies         *ReplyTemplates
 	PushshiftSearch *PushshiftSearch
 	Processes       *Processes
-	closed          bool // Can only be set to true, once.
+	closed          bool   // Can only be set to true, once.
+	configPath      string // Where the config was opened from, to reload it.
+
+	subscriptionsLock sync.Mutex // Held while reading and writing subscriptions, which commands and archiving both change.
 }
 
 // Flags are the application flags, sourced from Config.Application, hoisted for convenience.
@@ -26,47 +33,91 @@ type Flags struct {
 
 // NewClient creates the needed Client connections.
 func NewClient(configPath string) *Client {
-	client := &Client{}
+	client := NewOfflineClient(configPath)
 
-	config, err := OpenConfig(configPath)
+	replies, err := NewReplyTemplates(client.Config)
 	if err != nil {
-		log.Fatalf("could not open config: %v", err)
+		defer client.Archive.Close()
+		client.Logger.Panicf("could not compile reply templates: %v", err)
 	}
+	client.Replies = replies
 
-	flags := &Flags{config.Application.IsProduction}
-	logger, err := NewLogger(flags.IsProduction)
+	reddit, err := NewRedditClient(client, client.Config)
 	if err != nil {
-		log.Fatal("could not creator logger: %w", err)
+		defer client.Archive.Close()
+		client.Logger.Panicf("could not create Reddit client: %v", err)
 	}
+	client.Reddit = reddit
+
+	client.PushshiftSearch = NewPushshiftSearch(client, client.Config)
+	client.Processes = NewProcesses(client, client.Config)
+
+	return client
+}
+
+// NewOfflineClient creates a Client with only its archive, for commands run without Reddit.
+func NewOfflineClient(configPath string) *Client {
+	client := &Client{configPath: configPath}
 
-	rdb, err := NewRedisClient(client, config)
+	config, err := OpenConfig(configPath)
+	if err != nil {
+		log.Fatalf("could not open config: %v", err)
+	}
+	client.Config = config
+	client.Flairs = NewFlairLookup(config.Flairs)
+
+	client.Flags = &Flags{config.Application.IsProduction}
+	client.Logger, err = NewLogger(client.IsProduction)
 	if err != nil {
-		logger.Panicf("could not create Redis client: %v", err)
+		log.Fatalf("could not creator logger: %v", err)
 	}
 
-	reddit, err := NewRedditClient(client, config)
+	client.Archive, client.Redis, err = NewArchive(client, config)
 	if err != nil {
-		defer rdb.Close()
-		logger.Panicf("could not create Reddit client: %v", err)
+		client.Logger.Panicf("could not create archive: %v", err)
 	}
 
 	search, ce := NewSearch(client, config)
 	if ce != nil {
-		ce.Panic(logger)
+		ce.Panic(client.Logger)
 	}
+	client.Search = search
 
-	pushshiftSearch := NewPushshiftSearch(client, config)
-
-	processes := NewProcesses(client, config)
-
-	return &Client{flags, logger, config, rdb, reddit, search, pushshiftSearch, processes, false}
+	return client
 }
 
 // Close the client's functions.
 func (c *Client) Close() {
-	c.Redis.Close()
+	c.Archive.Close()
 	c.Logger.Sync()
-	c.Processes.Close()
+	if c.Processes != nil {
+		c.Processes.Close()
+	}
+}
+
+// ReloadConfig opens the config again and applies its Constants and Templates, recompiling the searches and reply templates.
+// Every other section needs a restart to change.
+// Config isn't changed, as the other goroutines read it unsynchronized: the reloaded sections are swapped under the locks of Search and Replies.
+func (c *Client) ReloadConfig() {
+	config, err := OpenConfig(c.configPath)
+	if err != nil {
+		c.Logger.Errorf("could not reload config: %v", err)
+		return
+	}
+
+	templates, err := compileReplyTemplates(config.Templates, config.Constants)
+	if err != nil {
+		c.Logger.Errorf("could not reload config, keeping the current reply templates: %v", err)
+		return
+	}
+
+	if err := c.Search.setSearches(config.Constants); err != nil {
+		c.Logger.Errorf("could not reload config, keeping the current searches: %v", err)
+		return
+	}
+
+	c.Replies.set(templates, config.Constants)
+	c.Logger.Infof("Reloaded config with %d searches.", len(config.Constants.searchTerms()))
 }
 
 // Run is used to control the event loop until the client closes.
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vartanbeno/go-reddit/reddit"
+)
+
+// Permission is who may run an inbox command.
+type Permission int
+
+// Permissions, each allowing fewer users than the last.
+const (
+	PermissionEveryone  Permission = iota
+	PermissionModerator            // Moderators of Subreddit.name.
+)
+
+// InboxCommand is a command given by mentioning the bot, e.g. `u/ArchiveBot search python`.
+// Commands add themselves with registerInboxCommand from an init function in their own file.
+type InboxCommand struct {
+	Name       string
+	Aliases    []string
+	Arguments  string // The arguments as shown in help, e.g. "<query> [page N]".
+	MinArgs    int
+	MaxArgs    int // -1 for any number of arguments.
+	Permission Permission
+	Help       string // A sentence explaining the command.
+	Run        func(c *Client, m *reddit.Message, arguments []string) *ContextError
+	// Parses checks the arguments are the command's rather than prose, for replies which don't mention the bot. Optional.
+	Parses func(c *Client, arguments []string) (bool, *ContextError)
+	// Paged is whether the arguments may end with "page N", so that replies to it can turn the page with "next" or "page N".
+	Paged bool
+}
+
+// inboxCommands are the registered commands by their lowercase names and aliases.
+var inboxCommands = make(map[string]*InboxCommand)
+
+// registerInboxCommand adds a command to those dispatched, panicking if its name or an alias is taken.
+func registerInboxCommand(command InboxCommand) {
+	for _, name := range append([]string{command.Name}, command.Aliases...) {
+		name = strings.ToLower(name)
+		if _, ok := inboxCommands[name]; ok {
+			panic(fmt.Sprintf("inbox command %s is registered twice", name))
+		}
+
+		inboxCommands[name] = &command
+	}
+}
+
+func init() {
+	registerInboxCommand(InboxCommand{
+		Name:      "help",
+		Arguments: "[command]",
+		MaxArgs:   1,
+		Help:      "Explains a command, or lists every command.",
+		Run:       (*Client).HelpCommand,
+		Parses: func(c *Client, arguments []string) (bool, *ContextError) {
+			if len(arguments) == 0 {
+				return true, nil
+			}
+
+			_, ok := inboxCommands[strings.ToLower(arguments[0])]
+			return ok, nil
+		},
+	})
+}
+
+// sortedInboxCommands returns every command once, sorted by name.
+func sortedInboxCommands() []*InboxCommand {
+	var commands []*InboxCommand
+	for name, command := range inboxCommands {
+		if name == command.Name {
+			commands = append(commands, command)
+		}
+	}
+
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].Name < commands[j].Name
+	})
+
+	return commands
+}
+
+// usage is how the command is written, e.g. `search <query> [page N]`.
+func (command *InboxCommand) usage() string {
+	if command.Arguments == "" {
+		return "`" + command.Name + "`"
+	}
+
+	return "`" + command.Name + " " + command.Arguments + "`"
+}
+
+// describe explains the command for `help <command>`.
+func (command *InboxCommand) describe() string {
+	description := command.usage() + ": " + command.Help
+	if len(command.Aliases) != 0 {
+		description += fmt.Sprintf(" Also written as `%s`.", strings.Join(command.Aliases, "`, `"))
+	}
+
+	if command.Permission == PermissionModerator {
+		description += " Only moderators can use it."
+	}
+
+	return description
+}
+
+// closestInboxCommand returns the command name or alias closest to the misspelt name, see nameCost.
+func closestInboxCommand(name string) (string, bool) {
+	term := []rune(strings.ToLower(name))
+
+	best, bestCost, found := "", 0.0, false
+	for commandName := range inboxCommands {
+		cost, ok := nameCost(term, []rune(commandName))
+		if ok && (!found || cost < bestCost || (cost == bestCost && commandName < best)) {
+			best, bestCost, found = commandName, cost, true
+		}
+	}
+
+	return best, found
+}
+
+// parsesInboxCommand returns whether the fields are a command and arguments it accepts, so a reply without a mention is only run when it's unmistakably a command.
+func (c *Client) parsesInboxCommand(fields []string) (bool, *ContextError) {
+	command, ok := inboxCommands[strings.ToLower(fields[0])]
+	if !ok {
+		return false, nil
+	}
+
+	arguments := fields[1:]
+	if len(arguments) < command.MinArgs || (command.MaxArgs >= 0 && len(arguments) > command.MaxArgs) {
+		return false, nil
+	}
+
+	if command.Parses == nil {
+		return true, nil
+	}
+
+	return command.Parses(c, arguments)
+}
+
+// parsesQueryArguments checks the arguments are a query, for the Parses of commands taking one.
+func parsesQueryArguments(c *Client, arguments []string) (bool, *ContextError) {
+	if len(arguments) == 0 {
+		return true, nil
+	}
+
+	_, qe := ParseQuery(strings.Join(arguments, " "))
+	return qe == nil, nil
+}
+
+// runInboxCommand checks the command exists, its arguments and the author's permission before running it.
+func (c *Client) runInboxCommand(m *reddit.Message, name string, arguments []string) *ContextError {
+	command, ok := inboxCommands[strings.ToLower(name)]
+	if !ok {
+		message := fmt.Sprintf("Unknown command `%s`.", name)
+		if closest, found := closestInboxCommand(name); found {
+			message += fmt.Sprintf(" Did you mean `%s`?", closest)
+		}
+
+		return c.replyCouldNotParse(m, message)
+	}
+
+	if len(arguments) < command.MinArgs || (command.MaxArgs >= 0 && len(arguments) > command.MaxArgs) {
+		return c.replyCouldNotParse(m, "It's written as "+command.usage()+".")
+	}
+
+	if command.Permission == PermissionModerator {
+		isModerator, ce := c.isModerator(m.Author)
+		if ce != nil {
+			return ce
+		}
+
+		if !isModerator {
+			return c.replyCouldNotParse(m, fmt.Sprintf("Only moderators can use `%s`.", command.Name))
+		}
+	}
+
+	// The parts of a reply sent before an error can be followed up on too.
+	ce := command.Run(c, m, arguments)
+	if recordCE := c.recordReplyCommand(m, command.Name, arguments); ce == nil {
+		ce = recordCE
+	}
+
+	return ce
+}
+
+// recordReplyCommand remembers the command the reply to the message answered, so that replies to any of its parts can follow up.
+func (c *Client) recordReplyCommand(m *reddit.Message, name string, arguments []string) *ContextError {
+	replies, ce := c.Archive.getReplies([]string{m.FullID})
+	if ce != nil {
+		return ce
+	}
+
+	replyIDs := replies[m.FullID]
+	if replyIDs == RepliedPrivately {
+		return nil
+	}
+
+	commandLine := strings.Join(append([]string{name}, arguments...), " ")
+	for _, replyID := range strings.Fields(replyIDs) {
+		if ce := c.Archive.setReplyCommand(replyID, commandLine); ce != nil {
+			return ce
+		}
+	}
+
+	return nil
+}
+
+// isModerator checks whether the user moderates Subreddit.name.
+func (c *Client) isModerator(username string) (bool, *ContextError) {
+	moderators, _, err := c.Reddit.Subreddit.Moderators(ctx, c.Config.Subreddit.Name)
+	if err != nil {
+		return false, NewWrappedError("getting moderators", err, []ContextParam{
+			{"Subreddit", c.Config.Subreddit.Name},
+		})
+	}
+
+	for _, moderator := range moderators {
+		if moderator.Relationship != nil && strings.EqualFold(moderator.User, username) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// HelpCommand lists every command, or explains the given command.
+func (c *Client) HelpCommand(m *reddit.Message, arguments []string) *ContextError {
+	if len(arguments) == 1 {
+		command, ok := inboxCommands[strings.ToLower(arguments[0])]
+		if !ok {
+			return c.runInboxCommand(m, arguments[0], nil)
+		}
+
+		return c.replyWithFooter(m, command.describe())
+	}
+
+	commands := sortedInboxCommands()
+	lines := make([]string, len(commands))
+	for i, command := range commands {
+		lines[i] = "- " + command.usage() + ": " + command.Help
+	}
+
+	return c.replyTemplate(m, ReplyHelp, ReplyData{Commands: strings.Join(lines, "\n")})
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// CompactBatch is the number of submissions rewritten at once while compacting.
+const CompactBatch = 500
+
+// RedisCompactingSuffix is appended to a key while it's rebuilt as a set.
+const RedisCompactingSuffix = RedisDelimiter + "compacting"
+
+// compactStorage rewrites every stored submission with only the stored fields, encoded with MarshalBinary.
+// Authors and domains are indexed again, as submissions archived by older versions weren't,
+// and links are formatted again, as older versions didn't escape titles.
+func (r *Redis) compactStorage() *ContextError {
+	storedFields := r.config.Storage.storedFields()
+
+	compacted := 0
+	var batch []interface{}
+	var links []interface{}
+	authors := make(map[string][]*redis.Z)
+	domains := make(map[string][]*redis.Z)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		_, err := r.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, r.key(RedisAllSubmissions), batch...)
+			pipe.HSet(ctx, r.key(RedisLinks), links...)
+			r.addIndex(pipe, RedisAuthorsPrefix, authors)
+			r.addIndex(pipe, RedisDomainsPrefix, domains)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		compacted += len(batch) / 2
+		batch = batch[:0]
+		links = links[:0]
+		authors = make(map[string][]*redis.Z)
+		domains = make(map[string][]*redis.Z)
+		return nil
+	}
+
+	ce := r.scanHash(RedisAllSubmissions, func(fullID, value string) error {
+		var submission PushshiftSubmission
+		if err := submission.UnmarshalBinary([]byte(value)); err != nil {
+			return err
+		}
+
+		batch = append(batch, fullID, submission.Compact(storedFields))
+		links = append(links, fullID, markdownLink(submission.Title, submission.Permalink))
+		submission.addIndexes(authors, domains)
+
+		if len(batch) < CompactBatch*2 {
+			return nil
+		}
+
+		return flush()
+	})
+	if ce != nil {
+		return ce
+	}
+
+	if err := flush(); err != nil {
+		return NewWrappedError("could not compact "+RedisAllSubmissions, err, nil)
+	}
+
+	r.client.Logger.Infof("Compacted %d submissions.", compacted)
+
+	return nil
+}
+
+// upgradeSchema converts an archive written by an older version when connecting, so it can be read without compacting it first.
+// Once RedisSchema is RedisSchemaVersion it only reads that key.
+func (r *Redis) upgradeSchema() *ContextError {
+	version, err := r.Get(ctx, r.key(RedisSchema)).Int()
+	if err != nil && err != redis.Nil {
+		return NewContextError(err, []ContextParam{
+			{"Redis Key", RedisSchema},
+		})
+	}
+
+	if version >= RedisSchemaVersion {
+		return nil
+	}
+
+	if ce := r.convertRemovalHashes(); ce != nil {
+		return ce
+	}
+
+	if err := r.Set(ctx, r.key(RedisSchema), RedisSchemaVersion, 0).Err(); err != nil {
+		return NewContextError(err, []ContextParam{
+			{"Redis Key", RedisSchema},
+		})
+	}
+
+	return nil
+}
+
+// convertRemovalHashes converts the removal hashes of archives from before RedisSchemaVersion 1, which held another full copy of each submission,
+// to sets of IDs.
+func (r *Redis) convertRemovalHashes() *ContextError {
+	for _, key := range []string{RedisSubmissions, RedisRemovedSubmissions} {
+		keyType, err := r.Type(ctx, r.key(key)).Result()
+		if err != nil {
+			return NewContextError(err, []ContextParam{
+				{"Redis Key", key},
+			})
+		}
+
+		if keyType != "hash" {
+			continue
+		}
+
+		if ce := r.hashToSet(key); ce != nil {
+			return ce
+		}
+	}
+
+	return nil
+}
+
+// hashToSet replaces a hash with a set of its fields.
+func (r *Redis) hashToSet(key string) *ContextError {
+	temporaryKey := key + RedisCompactingSuffix
+	if err := r.Del(ctx, r.key(temporaryKey)).Err(); err != nil {
+		return NewContextError(err, []ContextParam{
+			{"Redis Key", temporaryKey},
+		})
+	}
+
+	var ids []interface{}
+	flush := func() error {
+		if len(ids) == 0 {
+			return nil
+		}
+
+		err := r.SAdd(ctx, r.key(temporaryKey), ids...).Err()
+		ids = ids[:0]
+		return err
+	}
+
+	ce := r.scanHash(key, func(fullID, _ string) error {
+		ids = append(ids, toFullID(fullID))
+		if len(ids) < CompactBatch {
+			return nil
+		}
+
+		return flush()
+	})
+	if ce != nil {
+		return ce
+	}
+
+	if err := flush(); err != nil {
+		return NewWrappedError("could not convert "+key, err, nil)
+	}
+
+	// Redis deletes empty hashes so the set always has members.
+	if err := r.Rename(ctx, r.key(temporaryKey), r.key(key)).Err(); err != nil {
+		return NewWrappedError("could not replace "+key, err, nil)
+	}
+
+	r.client.Logger.Infof("Converted %s to a set.", key)
+
+	return nil
+}
+
+func (s *SQLite) compactStorage() *ContextError {
+	storedFields := s.config.Storage.storedFields()
+
+	compacted := 0
+	lastID := ""
+	for {
+		rows, err := s.Query(`SELECT id, raw FROM submissions WHERE id > ? ORDER BY id LIMIT ?`, lastID, CompactBatch)
+		if err != nil {
+			return NewContextlessError(err)
+		}
+
+		var submissions []PushshiftSubmission
+		for rows.Next() {
+			var raw []byte
+			if err := rows.Scan(&lastID, &raw); err != nil {
+				rows.Close()
+				return NewContextlessError(err)
+			}
+
+			var submission PushshiftSubmission
+			if err := submission.UnmarshalBinary(raw); err != nil {
+				rows.Close()
+				return NewContextError(err, []ContextParam{
+					{"ID", lastID},
+				})
+			}
+
+			submissions = append(submissions, submission)
+		}
+
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return NewContextlessError(err)
+		}
+
+		if len(submissions) == 0 {
+			break
+		}
+
+		err = s.transaction(func(tx *sql.Tx) error {
+			for _, submission := range submissions {
+				raw, err := submission.Compact(storedFields).MarshalBinary()
+				if err != nil {
+					return err
+				}
+
+				if _, err := tx.Exec(`UPDATE submissions SET raw = ? WHERE id = ?`, raw, "t3_"+submission.ID); err != nil {
+					return err
+				}
+
+				if err := insertIndexes(tx, submission); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return NewWrappedError("could not compact submissions", err, []ContextParam{
+				{"Last ID", lastID},
+			})
+		}
+
+		compacted += len(submissions)
+	}
+
+	if _, err := s.Exec(`VACUUM`); err != nil {
+		return NewWrappedError("could not vacuum", err, nil)
+	}
+
+	s.client.Logger.Infof("Compacted %d submissions.", compacted)
+
+	return nil
+}
+
+// CompactCommand rewrites the archive to only store the configured fields.
+func CompactCommand(c *Client, args []string) *ContextError {
+	if len(args) != 0 {
+		return NewContextlessError(fmt.Errorf("compact takes no arguments, received %v", args))
+	}
+
+	return c.Archive.compactStorage()
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"go.uber.org/zap"
+)
+
+func TestUpgradeSchema(t *testing.T) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	// Older versions stored the removals as hashes of the submissions.
+	server.HSet(RedisSubmissions, "t3_a", "submission a", "t3_b", "submission b")
+	server.HSet(RedisRemovedSubmissions, "t3_c", "submission c")
+
+	config := &Config{}
+	config.Redis.Addr = server.Addr()
+	client := &Client{Logger: zap.NewNop().Sugar(), Config: config, Flairs: NewFlairLookup(config.Flairs)}
+	connect := func() {
+		t.Helper()
+		r, err := NewRedisClient(client, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Close()
+	}
+
+	connect()
+	for key, want := range map[string][]string{RedisSubmissions: {"t3_a", "t3_b"}, RedisRemovedSubmissions: {"t3_c"}} {
+		got, err := server.Members(key)
+		if err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+
+	if version, _ := server.Get(RedisSchema); version != "1" {
+		t.Errorf("%s = %q, want 1", RedisSchema, version)
+	}
+
+	// Once upgraded the archive isn't checked again, so a hash is left as is.
+	server.Del(RedisRemovedSubmissions)
+	server.HSet(RedisRemovedSubmissions, "t3_d", "submission d")
+	connect()
+	if keyType := server.Type(RedisRemovedSubmissions); keyType != "hash" {
+		t.Errorf("%s is a %s after connecting again, want it left a hash", RedisRemovedSubmissions, keyType)
+	}
+}
+
+// writeBackup returns a backup of the records, for backups made by older versions.
+func writeBackup(t *testing.T, records ...backupRecord) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	encoder := json.NewEncoder(gz)
+	if err := encoder.Encode(backupHeader{BackupFormat, BackupVersion, 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := make(map[string]*backupSummary)
+	for i := range records {
+		summary, ok := manifest[records[i].Key]
+		if !ok {
+			summary = &backupSummary{Type: records[i].Type}
+			manifest[records[i].Key] = summary
+		}
+		summary.add(&records[i])
+
+		if err := encoder.Encode(records[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := encoder.Encode(backupRecord{Manifest: manifest}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return b.Bytes()
+}
+
+func TestRestoreUpgradesSchema(t *testing.T) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	config := &Config{}
+	config.Redis.Addr = server.Addr()
+	client := &Client{Logger: zap.NewNop().Sugar(), Config: config, Flairs: NewFlairLookup(config.Flairs)}
+	r, err := NewRedisClient(client, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// The backup was made before removals were stored as sets, so it has no schema version.
+	backup := writeBackup(t, backupRecord{
+		Key:  RedisRemovedSubmissions,
+		Type: "hash",
+		Hash: map[string][]byte{"t3_c": []byte("submission c")},
+	})
+	if ce := r.restore(bytes.NewReader(backup)); ce != nil {
+		t.Fatal(ce)
+	}
+
+	if got, err := server.Members(RedisRemovedSubmissions); err != nil || !reflect.DeepEqual(got, []string{"t3_c"}) {
+		t.Errorf("%s = %q, %v, want it converted to a set of t3_c", RedisRemovedSubmissions, got, err)
+	}
+
+	if version, _ := server.Get(RedisSchema); version != "1" {
+		t.Errorf("%s = %q, want 1", RedisSchema, version)
+	}
+}
@@ -10,12 +10,19 @@ import (
 
 // Config is the toml config that houses all of ArchiveBot's information.
 type Config struct {
-	Application Application     `toml:"Application"`
-	Subreddit   Subreddit       `toml:"Subreddit"`
-	Pushshift   Pushshift       `toml:"Pushshift"`
-	Redis       RedisConfig     `toml:"Redis"`
-	Reddit      RedditConfig    `toml:"Reddit"`
-	Constants   ConstantsConfig `toml:"Constants"`
+	Application   Application         `toml:"Application"`
+	Subreddit     Subreddit           `toml:"Subreddit"`
+	Pushshift     Pushshift           `toml:"Pushshift"`
+	Storage       StorageConfig       `toml:"Storage"`
+	Redis         RedisConfig         `toml:"Redis"`
+	Backup        BackupConfig        `toml:"Backup"`
+	Ranking       RankingConfig       `toml:"Ranking"`
+	Flairs        FlairsConfig        `toml:"Flairs"`
+	Similar       SimilarConfig       `toml:"Similar"`
+	Subscriptions SubscriptionsConfig `toml:"Subscriptions"`
+	Reddit        RedditConfig        `toml:"Reddit"`
+	Constants     ConstantsConfig     `toml:"Constants"` // As read at startup, reloads only replace Search's matcher and Client.Replies.
+	Templates     TemplatesConfig     `toml:"Templates"` // As read at startup, like Constants.
 }
 
 // Application is
@@ -37,11 +44,45 @@ type Pushshift struct {
 	Delay int64  `toml:"delay"`
 }
 
+// StorageConfig selects where the archive is stored.
+type StorageConfig struct {
+	Backend      string   `toml:"backend"`
+	SQLitePath   string   `toml:"sqlite_path"`
+	StoredFields []string `toml:"stored_fields"`
+}
+
+// storedFields are the raw submission fields to store, in addition to PushshiftRequiredFields.
+func (s StorageConfig) storedFields() []string {
+	if s.StoredFields == nil {
+		return DefaultStoredFields
+	}
+
+	return s.StoredFields
+}
+
 // RedisConfig configuration
 type RedisConfig struct {
-	Addr     string `toml:"addr"`
-	Password string `toml:"password"`
-	DB       int    `toml:"db"`
+	Addr             string         `toml:"addr"`
+	Addrs            []string       `toml:"addrs"`
+	Cluster          bool           `toml:"cluster"`
+	MasterName       string         `toml:"master_name"`
+	SentinelUsername string         `toml:"sentinel_username"`
+	SentinelPassword string         `toml:"sentinel_password"`
+	Username         string         `toml:"username"`
+	Password         string         `toml:"password"`
+	DB               int            `toml:"db"`
+	HashTag          string         `toml:"hash_tag"`
+	TLS              RedisTLSConfig `toml:"TLS"`
+}
+
+// RedisTLSConfig configures TLS connections to Redis.
+type RedisTLSConfig struct {
+	Enabled            bool   `toml:"enabled"`
+	CAFile             string `toml:"ca_file"`
+	CertFile           string `toml:"cert_file"`
+	KeyFile            string `toml:"key_file"`
+	ServerName         string `toml:"server_name"`
+	InsecureSkipVerify bool   `toml:"insecure_skip_verify"`
 }
 
 // OpenConfig opens the configuration file.
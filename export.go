@@ -0,0 +1,390 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// ExportBatch is the number of submissions read from the archive at once while exporting.
+const ExportBatch = 500
+
+// ExportDateLayout is the layout of the dates given to the export filters.
+const ExportDateLayout = "2006-01-02"
+
+// Removal states of an exported submission.
+const (
+	RemovalUnknown   = "unknown" // The submission hasn't been analyzed yet.
+	RemovalRemoved   = "removed"
+	RemovalUnremoved = "unremoved"
+)
+
+// ErrUnknownExportFormat is returned when the export format doesn't exist.
+var ErrUnknownExportFormat = errors.New("unknown export format")
+
+// ExportRecord is a single exported submission.
+type ExportRecord struct {
+	ID          string   `json:"id" parquet:"name=id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Title       string   `json:"title" parquet:"name=title, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Permalink   string   `json:"permalink" parquet:"name=permalink, type=BYTE_ARRAY, convertedtype=UTF8"`
+	DateCreated float64  `json:"created_utc" parquet:"name=created_utc, type=DOUBLE"`
+	Flair       string   `json:"flair" parquet:"name=flair, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Upvotes     int64    `json:"upvotes" parquet:"name=upvotes, type=INT64"`
+	Removal     string   `json:"removal" parquet:"name=removal, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SearchTerms []string `json:"search_terms" parquet:"name=search_terms, type=MAP, convertedtype=LIST, valuetype=BYTE_ARRAY, valueconvertedtype=UTF8"`
+}
+
+// ExportFilter limits which submissions are exported. Zero values don't filter.
+type ExportFilter struct {
+	After  float64 // Inclusive epoch.
+	Before float64 // Exclusive epoch.
+	Flair  string
+	Search string
+}
+
+// matches checks the date range and flair of a submission.
+func (f ExportFilter) matches(dateCreated float64, flair string) bool {
+	if f.After != 0 && dateCreated < f.After {
+		return false
+	}
+
+	if f.Before != 0 && dateCreated >= f.Before {
+		return false
+	}
+
+	return f.Flair == "" || f.Flair == flair
+}
+
+// ExportWriter writes exported records in a file format.
+type ExportWriter interface {
+	Write(record ExportRecord) error
+	Close() error
+}
+
+// NewExportWriter creates a writer for "ndjson", "csv" or "parquet".
+func NewExportWriter(format string, w io.Writer) (ExportWriter, error) {
+	switch format {
+	case "ndjson":
+		return &ndjsonWriter{json.NewEncoder(w)}, nil
+	case "csv":
+		return &csvWriter{csv.NewWriter(w), false}, nil
+	case "parquet":
+		pw, err := writer.NewParquetWriterFromWriter(w, new(ExportRecord), 1)
+		if err != nil {
+			return nil, err
+		}
+
+		return &parquetWriter{pw}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownExportFormat, format)
+	}
+}
+
+type ndjsonWriter struct {
+	encoder *json.Encoder
+}
+
+func (w *ndjsonWriter) Write(record ExportRecord) error {
+	return w.encoder.Encode(record)
+}
+
+func (w *ndjsonWriter) Close() error {
+	return nil
+}
+
+type csvWriter struct {
+	*csv.Writer
+	wroteHeader bool
+}
+
+func (w *csvWriter) Write(record ExportRecord) error {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		header := []string{"id", "title", "permalink", "created_utc", "flair", "upvotes", "removal", "search_terms"}
+		if err := w.Writer.Write(header); err != nil {
+			return err
+		}
+	}
+
+	return w.Writer.Write([]string{
+		record.ID,
+		record.Title,
+		record.Permalink,
+		strconv.FormatFloat(record.DateCreated, 'f', -1, 64),
+		record.Flair,
+		strconv.FormatInt(record.Upvotes, 10),
+		record.Removal,
+		strings.Join(record.SearchTerms, ";"),
+	})
+}
+
+func (w *csvWriter) Close() error {
+	w.Flush()
+	return w.Error()
+}
+
+type parquetWriter struct {
+	*writer.ParquetWriter
+}
+
+func (w *parquetWriter) Write(record ExportRecord) error {
+	return w.ParquetWriter.Write(record)
+}
+
+func (w *parquetWriter) Close() error {
+	return w.WriteStop()
+}
+
+// removalState describes whether a submission was removed, with nil meaning it's unknown.
+func removalState(removed *bool) string {
+	if removed == nil {
+		return RemovalUnknown
+	} else if *removed {
+		return RemovalRemoved
+	}
+
+	return RemovalUnremoved
+}
+
+func (r *Redis) scanExport(filter ExportFilter, f func(record ExportRecord) error) *ContextError {
+	// Only the IDs in each search are held in memory, the submissions themselves are streamed.
+	searchTerms := make(map[string][]string)
+	ce := r.scanKeys(RedisSearchPrefix, func(key string) error {
+		members, err := r.ZRange(ctx, r.key(key), 0, -1).Result()
+		if err != nil {
+			return err
+		}
+
+		term := strings.TrimPrefix(key, RedisSearchPrefix)
+		for _, member := range members {
+			searchTerms[member] = append(searchTerms[member], term)
+		}
+
+		return nil
+	})
+	if ce != nil {
+		return ce
+	}
+
+	var batch []PushshiftSubmission
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		pipe := r.Pipeline()
+		upvotes := make([]*redis.FloatCmd, len(batch))
+		unremoved := make([]*redis.BoolCmd, len(batch))
+		removed := make([]*redis.BoolCmd, len(batch))
+		for i, submission := range batch {
+			fullID := "t3_" + submission.ID
+			upvotes[i] = pipe.ZScore(ctx, r.key(RedisUpvotes), fullID)
+			unremoved[i] = pipe.SIsMember(ctx, r.key(RedisSubmissions), fullID)
+			removed[i] = pipe.SIsMember(ctx, r.key(RedisRemovedSubmissions), fullID)
+		}
+
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			return err
+		}
+
+		for i, submission := range batch {
+			fullID := "t3_" + submission.ID
+
+			ups := int64(submission.Ups)
+			if score, err := upvotes[i].Result(); err == nil {
+				ups = int64(score)
+			}
+
+			var isRemoved *bool
+			if removed[i].Val() || unremoved[i].Val() {
+				isRemoved = new(bool)
+				*isRemoved = removed[i].Val()
+			}
+
+			err := f(ExportRecord{
+				ID:          fullID,
+				Title:       submission.Title,
+				Permalink:   submission.Permalink,
+				DateCreated: submission.DateCreated,
+				Flair:       submission.LinkFlairText,
+				Upvotes:     ups,
+				Removal:     removalState(isRemoved),
+				SearchTerms: searchTerms[fullID],
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		batch = batch[:0]
+		return nil
+	}
+
+	ce = r.scanHash(RedisAllSubmissions, func(fullID, value string) error {
+		var submission PushshiftSubmission
+		if err := submission.UnmarshalBinary([]byte(value)); err != nil {
+			return err
+		}
+
+		if !filter.matches(submission.DateCreated, submission.LinkFlairText) {
+			return nil
+		}
+
+		if filter.Search != "" && !containsString(searchTerms[fullID], filter.Search) {
+			return nil
+		}
+
+		batch = append(batch, submission)
+		if len(batch) < ExportBatch {
+			return nil
+		}
+
+		return flush()
+	})
+	if ce != nil {
+		return ce
+	}
+
+	if err := flush(); err != nil {
+		return NewWrappedError("could not export submissions", err, nil)
+	}
+
+	return nil
+}
+
+func (s *SQLite) scanExport(filter ExportFilter, f func(record ExportRecord) error) *ContextError {
+	rows, err := s.Query(`SELECT s.id, s.title, s.permalink, s.created_utc, f.name, s.ups, s.removed,
+			(SELECT group_concat(t.term, char(31)) FROM search_terms t WHERE t.submission_id = s.id)
+		FROM submissions s
+		JOIN flairs f ON f.id = s.flair_id
+		WHERE (?1 = 0 OR s.created_utc >= ?1)
+			AND (?2 = 0 OR s.created_utc < ?2)
+			AND (?3 = '' OR f.name = ?3)
+			AND (?4 = '' OR EXISTS (SELECT 1 FROM search_terms t WHERE t.submission_id = s.id AND t.term = ?4))
+		ORDER BY s.created_utc`,
+		filter.After, filter.Before, filter.Flair, filter.Search)
+	if err != nil {
+		return NewWrappedError("could not export submissions", err, []ContextParam{
+			{"Filter", fmt.Sprintf("%+v", filter)},
+		})
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var record ExportRecord
+		var isRemoved *bool
+		var terms *string
+		if err := rows.Scan(&record.ID, &record.Title, &record.Permalink, &record.DateCreated, &record.Flair, &record.Upvotes, &isRemoved, &terms); err != nil {
+			return NewContextlessError(err)
+		}
+
+		record.Removal = removalState(isRemoved)
+		if terms != nil {
+			record.SearchTerms = strings.Split(*terms, "\x1f")
+		}
+
+		if err := f(record); err != nil {
+			return NewWrappedError("could not export submissions", err, []ContextParam{
+				{"ID", record.ID},
+			})
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return NewContextlessError(err)
+	}
+
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ExportCommand writes the archive to a file, or stdout, as NDJSON, CSV or Parquet.
+func ExportCommand(c *Client, args []string) *ContextError {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", "ndjson", `The format of the export, "ndjson", "csv" or "parquet".`)
+	output := flags.String("output", "", "The file to export to. Defaults to stdout.")
+	after := flags.String("after", "", "Only export submissions created on or after this date, formatted as "+ExportDateLayout+".")
+	before := flags.String("before", "", "Only export submissions created before this date, formatted as "+ExportDateLayout+".")
+	flair := flags.String("flair", "", "Only export submissions with this flair.")
+	search := flags.String("search", "", "Only export submissions matching this search term or one of its aliases.")
+	if err := flags.Parse(args); err != nil {
+		return NewContextlessError(err)
+	}
+
+	filter := ExportFilter{Flair: *flair, Search: *search}
+	if *search != "" {
+		if matches := c.Search.getTitleMatches(*search); len(matches) != 0 {
+			filter.Search = matches[0]
+		}
+	}
+
+	for _, date := range []struct {
+		value string
+		epoch *float64
+	}{{*after, &filter.After}, {*before, &filter.Before}} {
+		if date.value == "" {
+			continue
+		}
+
+		t, err := time.Parse(ExportDateLayout, date.value)
+		if err != nil {
+			return NewWrappedError("could not parse date", err, []ContextParam{
+				{"Date", date.value},
+			})
+		}
+
+		*date.epoch = float64(t.Unix())
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return NewContextlessError(err)
+		}
+		defer file.Close()
+
+		w = file
+	}
+
+	exportWriter, err := NewExportWriter(*format, w)
+	if err != nil {
+		return NewContextlessError(err)
+	}
+
+	exported := 0
+	ce := c.Archive.scanExport(filter, func(record ExportRecord) error {
+		exported++
+		return exportWriter.Write(record)
+	})
+	if ce != nil {
+		return ce
+	}
+
+	if err := exportWriter.Close(); err != nil {
+		return NewWrappedError("could not finish export", err, nil)
+	}
+
+	c.Logger.Infof("Exported %d submissions.", exported)
+
+	return nil
+}
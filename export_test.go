@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+// exportedSubmissions are testSubmissions as exported once a is known to be removed.
+var exportedSubmissions = []ExportRecord{
+	{"t3_a", "Learning Python", "/r/test/comments/a/", 1000, "Help", 10, RemovalRemoved, []string{"Python"}},
+	{"t3_b", "Unrelated post", "/r/test/comments/b/", 2000, "", 5, RemovalUnremoved, nil},
+	{"t3_c", "Python and Django", "/r/test/comments/c/", 3000, "Solved", 20, RemovalUnremoved, []string{"Django", "Python"}},
+}
+
+// parseExport reads back the records of an export.
+func parseExport(t *testing.T, format string, data []byte) []ExportRecord {
+	t.Helper()
+
+	var records []ExportRecord
+	switch format {
+	case "ndjson":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		for {
+			var record ExportRecord
+			if err := decoder.Decode(&record); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			records = append(records, record)
+		}
+	case "csv":
+		rows, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, row := range rows[1:] {
+			created, err := strconv.ParseFloat(row[3], 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ups, err := strconv.ParseInt(row[5], 10, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			record := ExportRecord{row[0], row[1], row[2], created, row[4], ups, row[6], nil}
+			if row[7] != "" {
+				record.SearchTerms = strings.Split(row[7], ";")
+			}
+			records = append(records, record)
+		}
+	case "parquet":
+		file, err := buffer.NewBufferFile(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pr, err := reader.NewParquetReader(file, new(ExportRecord), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pr.ReadStop()
+
+		records = make([]ExportRecord, pr.GetNumRows())
+		if err := pr.Read(&records); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := range records {
+		sort.Strings(records[i].SearchTerms)
+		if len(records[i].SearchTerms) == 0 {
+			records[i].SearchTerms = nil
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID < records[j].ID
+	})
+
+	return records
+}
+
+// export writes the archive matching the filter in the format.
+func export(t *testing.T, c *Client, format string, filter ExportFilter) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+	w, err := NewExportWriter(format, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ce := c.Archive.scanExport(filter, w.Write); ce != nil {
+		t.Fatal(ce)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return b.Bytes()
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	forEachBackend(t, testConstants, func(t *testing.T, c *Client) {
+		if err := c.Archive.addSubmissions(testSubmissions); err != nil {
+			t.Fatal(err)
+		}
+
+		if ce := c.Archive.setRemoved(testSubmissions, map[string]bool{"a": true}); ce != nil {
+			t.Fatal(ce)
+		}
+
+		for _, format := range []string{"ndjson", "csv", "parquet"} {
+			if got := parseExport(t, format, export(t, c, format, ExportFilter{})); !reflect.DeepEqual(got, exportedSubmissions) {
+				t.Errorf("%s export = %+v, want %+v", format, got, exportedSubmissions)
+			}
+		}
+
+		filters := []struct {
+			name   string
+			filter ExportFilter
+			want   []ExportRecord
+		}{
+			{"after", ExportFilter{After: 2000}, exportedSubmissions[1:]},
+			{"before", ExportFilter{Before: 2000}, exportedSubmissions[:1]},
+			{"flair", ExportFilter{Flair: "Solved"}, exportedSubmissions[2:]},
+			{"search", ExportFilter{Search: "Python"}, []ExportRecord{exportedSubmissions[0], exportedSubmissions[2]}},
+			{"none", ExportFilter{Search: "Django", Before: 2000}, nil},
+		}
+
+		for _, test := range filters {
+			if got := parseExport(t, "ndjson", export(t, c, "ndjson", test.filter)); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("export filtered by %s = %+v, want %+v", test.name, got, test.want)
+			}
+		}
+	})
+}
+
+func TestNewExportWriterUnknown(t *testing.T) {
+	if _, err := NewExportWriter("xml", io.Discard); !errors.Is(err, ErrUnknownExportFormat) {
+		t.Errorf("NewExportWriter(xml) = %v, want %v", err, ErrUnknownExportFormat)
+	}
+}
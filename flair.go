@@ -0,0 +1,369 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/vartanbeno/go-reddit/reddit"
+)
+
+// FlairsDefaultUnflaired is the name submissions without a flair are searched by when Flairs.unflaired isn't set.
+const FlairsDefaultUnflaired = "unflaired"
+
+// FlairsPageSize is the number of flairs in each page of the flairs command.
+const FlairsPageSize = 75
+
+// flairEmojiPattern matches the :name: codes of Reddit's flair emoji.
+var flairEmojiPattern = regexp.MustCompile(`:[\w+-]+:`)
+
+// FlairStats counts the archived submissions of a flair key.
+type FlairStats struct {
+	Key   string
+	Name  string // The name the flair is shown as.
+	Count int64
+	First float64 // The creation epoch of the first submission.
+	Last  float64 // The creation epoch of the last submission.
+}
+
+// FlairsConfig makes flairs differing in case or emoji, renamed flairs and aliases index and search as one flair.
+type FlairsConfig struct {
+	Unflaired string              `toml:"unflaired"`
+	Renames   map[string]string   `toml:"renames"` // Old flairs to the flair they were renamed to.
+	Aliases   map[string][]string `toml:"aliases"` // Flairs to other names they're searched by.
+}
+
+func (f FlairsConfig) unflaired() string {
+	if f.Unflaired == "" {
+		return FlairsDefaultUnflaired
+	}
+
+	return f.Unflaired
+}
+
+// flairNormalizer folds flairs. Unlike the Normalizer of the searches it doesn't depend on Constants.strip_diacritics,
+// as flair keys are stored in the archive and Constants can be reloaded.
+var flairNormalizer = Normalizer{}
+
+// FlairLookup is FlairsConfig with its flairs normalized, built once when the config is opened.
+type FlairLookup struct {
+	unflaired string            // The normalized Flairs.unflaired name.
+	renames   map[string]string // Normalized old flairs to the flair they were renamed to.
+	aliases   map[string]string // Normalized aliases to the normalized flair they're an alias of.
+	canonical map[string]bool   // The flairs with aliases and the new names of renamed flairs, see isCanonicalFlair.
+}
+
+// NewFlairLookup normalizes the flairs of the config.
+// When old flairs or aliases normalize the same, the first alphabetically is used.
+func NewFlairLookup(flairs FlairsConfig) *FlairLookup {
+	lookup := &FlairLookup{
+		unflaired: normalizeFlair(flairs.unflaired()),
+		renames:   make(map[string]string, len(flairs.Renames)),
+		aliases:   make(map[string]string),
+		canonical: make(map[string]bool, len(flairs.Renames)+len(flairs.Aliases)),
+	}
+
+	olds := make([]string, 0, len(flairs.Renames))
+	for old, renamed := range flairs.Renames {
+		olds = append(olds, old)
+		lookup.canonical[renamed] = true
+	}
+	sort.Strings(olds)
+	for _, old := range olds {
+		if key := normalizeFlair(old); key != "" {
+			if _, ok := lookup.renames[key]; !ok {
+				lookup.renames[key] = flairs.Renames[old]
+			}
+		}
+	}
+
+	names := make([]string, 0, len(flairs.Aliases))
+	for name := range flairs.Aliases {
+		names = append(names, name)
+		lookup.canonical[name] = true
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, alias := range flairs.Aliases[name] {
+			if key := normalizeFlair(alias); key != "" {
+				if _, ok := lookup.aliases[key]; !ok {
+					lookup.aliases[key] = normalizeFlair(name)
+				}
+			}
+		}
+	}
+
+	return lookup
+}
+
+// normalizeFlair folds the flair and removes its emoji, so that e.g. "🐍 Help Wanted" and "help wanted" are equal.
+func normalizeFlair(flair string) string {
+	flair = flairNormalizer.Normalize(flairEmojiPattern.ReplaceAllString(flair, " "))
+	flair = strings.Map(func(r rune) rune {
+		if unicode.In(r, unicode.So, unicode.Sk, unicode.Variation_Selector) {
+			return -1
+		}
+
+		return r
+	}, flair)
+
+	return strings.Join(strings.Fields(flair), " ")
+}
+
+// flairKey returns the key a flair is indexed under: its normalized form after renames and aliases.
+// Submissions without a flair have the key "".
+func (c *Client) flairKey(flair string) string {
+	key := normalizeFlair(flair)
+	if key == "" {
+		return ""
+	}
+
+	if renamed, ok := c.Flairs.renames[key]; ok {
+		key = normalizeFlair(renamed)
+	}
+
+	if name, ok := c.Flairs.aliases[key]; ok {
+		return name
+	}
+
+	return key
+}
+
+// searchFlairKey returns the key of the flair searched for, which is "" for the Flairs.unflaired name.
+func (c *Client) searchFlairKey(flair string) string {
+	if normalizeFlair(flair) == c.Flairs.unflaired {
+		return ""
+	}
+
+	return c.flairKey(flair)
+}
+
+// flairName returns the name a flair is shown as, which is the new name of a renamed flair.
+func (c *Client) flairName(flair string) string {
+	if renamed, ok := c.Flairs.renames[normalizeFlair(flair)]; ok {
+		return renamed
+	}
+
+	return flair
+}
+
+// isCanonicalFlair returns whether the flair is named the way it's configured, as a flair with aliases or the new name of a renamed flair.
+func (c *Client) isCanonicalFlair(flair string) bool {
+	return c.Flairs.canonical[flair]
+}
+
+// preferFlairName returns whether flair a is shown rather than flair b when they have the same key:
+// the configured name first, then one without emoji, then the first alphabetically.
+func (c *Client) preferFlairName(a, b string) bool {
+	if canonicalA, canonicalB := c.isCanonicalFlair(a), c.isCanonicalFlair(b); canonicalA != canonicalB {
+		return canonicalA
+	}
+
+	if emojiA, emojiB := hasFlairEmoji(a), hasFlairEmoji(b); emojiA != emojiB {
+		return emojiB
+	}
+
+	return a < b
+}
+
+// combineFlairStats adds up the stats with the same key, naming each key after its preferred name.
+// Keys without submissions are left out.
+func (c *Client) combineFlairStats(stats []FlairStats) []FlairStats {
+	byKey := make(map[string]*FlairStats)
+	var keys []string
+	for _, flair := range stats {
+		combined, ok := byKey[flair.Key]
+		if !ok {
+			combined = &FlairStats{Key: flair.Key}
+			byKey[flair.Key] = combined
+			keys = append(keys, flair.Key)
+		}
+
+		if flair.Name != "" && (combined.Name == "" || c.preferFlairName(flair.Name, combined.Name)) {
+			combined.Name = flair.Name
+		}
+
+		if flair.Count == 0 {
+			continue
+		}
+
+		if combined.Count == 0 || flair.First < combined.First {
+			combined.First = flair.First
+		}
+
+		if combined.Count == 0 || flair.Last > combined.Last {
+			combined.Last = flair.Last
+		}
+
+		combined.Count += flair.Count
+	}
+
+	combined := make([]FlairStats, 0, len(keys))
+	for _, key := range keys {
+		flair := byKey[key]
+		if flair.Count == 0 {
+			continue
+		}
+
+		if key == "" {
+			flair.Name = c.Config.Flairs.unflaired()
+		} else if flair.Name == "" {
+			flair.Name = key
+		}
+
+		combined = append(combined, *flair)
+	}
+
+	return combined
+}
+
+// hasFlairEmoji returns whether the flair has emoji, which normalizeFlair removes.
+func hasFlairEmoji(flair string) bool {
+	return normalizeFlair(flair) != strings.Join(strings.Fields(flairNormalizer.Normalize(flair)), " ")
+}
+
+// searchableFlairNames returns the names flairs can be searched by besides the archived flairs: the aliases and the unflaired name.
+func (c *Client) searchableFlairNames() []string {
+	names := []string{c.Config.Flairs.unflaired()}
+	for _, aliases := range c.Config.Flairs.Aliases {
+		names = append(names, aliases...)
+	}
+
+	return names
+}
+
+func init() {
+	registerInboxCommand(InboxCommand{
+		Name:      "flairs",
+		Arguments: "[page N]",
+		MaxArgs:   2,
+		Help:      "Lists every archived flair with its number of posts and when it was first and last used, for searching with flair:.",
+		Run:       (*Client).FlairsCommand,
+		Paged:     true,
+		Parses: func(c *Client, arguments []string) (bool, *ContextError) {
+			if len(arguments) == 0 {
+				return true, nil
+			} else if len(arguments) != 2 {
+				return false, nil
+			}
+
+			page, err := strconv.Atoi(arguments[1])
+			return strings.EqualFold(arguments[0], "page") && err == nil && page >= 1, nil
+		},
+	})
+}
+
+// FlairsCommand replies with a table of the archived flairs, the most used first, a page at a time.
+func (c *Client) FlairsCommand(m *reddit.Message, arguments []string) *ContextError {
+	page := 1
+	if len(arguments) != 0 {
+		if len(arguments) != 2 || !strings.EqualFold(arguments[0], "page") {
+			return c.replyCouldNotParse(m, "It's written as `flairs [page N]`.")
+		}
+
+		var err error
+		page, err = strconv.Atoi(arguments[1])
+		if err != nil || page < 1 {
+			return c.replyCouldNotParse(m, fmt.Sprintf("`%s` isn't a page number.", arguments[1]))
+		}
+	}
+
+	stats, ce := c.Archive.getFlairStats()
+	if ce != nil {
+		return ce
+	}
+
+	if len(stats) == 0 {
+		return c.replyWithFooter(m, "There aren't any archived posts yet.")
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Count == stats[j].Count {
+			return stats[i].Name < stats[j].Name
+		}
+
+		return stats[i].Count > stats[j].Count
+	})
+
+	pages := (len(stats) + FlairsPageSize - 1) / FlairsPageSize
+	if page > pages {
+		return c.replyCouldNotParse(m, fmt.Sprintf("There are only %d pages of flairs.", pages))
+	}
+
+	stats = stats[(page-1)*FlairsPageSize:]
+	if len(stats) > FlairsPageSize {
+		stats = stats[:FlairsPageSize]
+	}
+
+	rows := make([]string, 0, len(stats))
+	for _, flair := range stats {
+		rows = append(rows, fmt.Sprintf("%s | %d | %s | %s", escapeMarkdown(flair.Name), flair.Count, formatEpochDate(flair.First), formatEpochDate(flair.Last)))
+	}
+
+	summary := fmt.Sprintf("Page %d of %d.", page, pages)
+	if page < pages {
+		summary += fmt.Sprintf(" Add `page %d` for more.", page+1)
+	}
+
+	footer, ce := c.footer(m)
+	if ce != nil {
+		return ce
+	}
+
+	return c.replyWith(m, Reply{
+		Header:      summary + "\n\n",
+		ItemsHeader: "Flair | Posts | First | Last\n:--|--:|:--|:--\n",
+		Items:       rows,
+		Separator:   "\n",
+		Footer:      footer,
+	})
+}
+
+// formatEpochDate formats a creation epoch as its UTC date.
+func formatEpochDate(epoch float64) string {
+	return time.Unix(int64(epoch), 0).UTC().Format("2006-01-02")
+}
+
+// MergeFlairsCommand indexes the existing flairs under their current keys, merging the flairs Flairs.renames and Flairs.aliases make equal.
+// A flair can also be merged into another once with -from and -to.
+func MergeFlairsCommand(c *Client, args []string) *ContextError {
+	flags := flag.NewFlagSet("merge-flairs", flag.ContinueOnError)
+	from := flags.String("from", "", "A flair to merge into -to, in addition to Flairs.renames.")
+	to := flags.String("to", "", "The flair to merge -from into.")
+	if err := flags.Parse(args); err != nil {
+		return NewContextlessError(err)
+	}
+
+	if (*from == "") != (*to == "") {
+		return NewContextlessError(errors.New("-from and -to have to be given together"))
+	}
+
+	if *from != "" {
+		renames := make(map[string]string, len(c.Config.Flairs.Renames)+1)
+		for old, renamed := range c.Config.Flairs.Renames {
+			renames[old] = renamed
+		}
+		renames[*from] = *to
+		c.Config.Flairs.Renames = renames
+		c.Flairs = NewFlairLookup(c.Config.Flairs)
+
+		defer c.Logger.Warnf("Add %q = %q to [Flairs.renames], otherwise new submissions flaired %q are indexed separately again.", *from, *to, *from)
+	}
+
+	return c.Archive.mergeFlairs()
+}
+
+// logMergedFlairs logs the flairs merged into each other by the archive.
+func (c *Client) logMergedFlairs(merged map[string]string) {
+	for from, to := range merged {
+		c.Logger.Infof("Merged flair %q into %q.", from, to)
+	}
+
+	c.Logger.Infof("Merged %d flairs.", len(merged))
+}
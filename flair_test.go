@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vartanbeno/go-reddit/reddit"
+)
+
+// testFlairs renames Bug to Issue and searches Help by Question.
+var testFlairs = FlairsConfig{
+	Renames: map[string]string{"Bug": "Issue", "🐛 bug": "Issue"},
+	Aliases: map[string][]string{"Help": {"Question", "Q&A"}},
+}
+
+func TestNormalizeFlair(t *testing.T) {
+	tests := []struct {
+		flair string
+		want  string
+	}{
+		{"Help", "help"},
+		{"🐍 Help Wanted", "help wanted"},
+		{":snake: Help  Wanted :snake:", "help wanted"},
+		{"Ｈｅｌｐ", "help"},
+		{"Café", "café"},
+		{"Помощь", "помощь"},
+		{"☕", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if got := normalizeFlair(test.flair); got != test.want {
+			t.Errorf("normalizeFlair(%q) = %q, want %q", test.flair, got, test.want)
+		}
+	}
+}
+
+func TestFlairKey(t *testing.T) {
+	for _, strip := range []bool{false, true} {
+		config := &Config{Flairs: testFlairs}
+		config.Constants.StripDiacritics = strip
+		c := &Client{Config: config, Flairs: NewFlairLookup(config.Flairs)}
+		c.Search = &Search{client: c, config: config}
+		if err := c.Search.setSearches(config.Constants); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			flair  string
+			key    string
+			search string
+			name   string
+		}{
+			{"Help", "help", "help", "Help"},
+			{"question", "help", "help", "question"},
+			{"Q&A", "help", "help", "Q&A"},
+			{"BUG", "issue", "issue", "Issue"},
+			{"🐛 Bug", "issue", "issue", "Issue"},
+			{"Café", "café", "café", "Café"},
+			{"", "", "", ""},
+			{"Unflaired", "unflaired", "", "Unflaired"},
+		}
+
+		for _, test := range tests {
+			if key := c.flairKey(test.flair); key != test.key {
+				t.Errorf("strip diacritics %v: flairKey(%q) = %q, want %q", strip, test.flair, key, test.key)
+			}
+
+			if key := c.searchFlairKey(test.flair); key != test.search {
+				t.Errorf("strip diacritics %v: searchFlairKey(%q) = %q, want %q", strip, test.flair, key, test.search)
+			}
+
+			if name := c.flairName(test.flair); name != test.name {
+				t.Errorf("strip diacritics %v: flairName(%q) = %q, want %q", strip, test.flair, name, test.name)
+			}
+		}
+	}
+}
+
+func TestPreferFlairName(t *testing.T) {
+	c := &Client{Config: &Config{Flairs: testFlairs}, Flairs: NewFlairLookup(testFlairs)}
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"Help", "help", true},     // Configured.
+		{"help", "Help", false},    // Configured.
+		{"Issue", "issue", true},   // Renamed to.
+		{"help", "🙋 help", true},   // Without emoji.
+		{"🙋 help", "help", false},  // Without emoji.
+		{"Solved", "solved", true}, // Alphabetical.
+		{"solved", "Solved", false},
+	}
+
+	for _, test := range tests {
+		if got := c.preferFlairName(test.a, test.b); got != test.want {
+			t.Errorf("preferFlairName(%q, %q) = %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestCombineFlairStats(t *testing.T) {
+	c := &Client{Config: &Config{Flairs: testFlairs}, Flairs: NewFlairLookup(testFlairs)}
+	stats := []FlairStats{
+		{Key: "help", Name: "help", Count: 2, First: 2000, Last: 3000},
+		{Key: "help", Name: "Help", Count: 1, First: 1000, Last: 1000},
+		{Key: "issue", Name: "Issue"},
+		{Key: "", Count: 4, First: 500, Last: 5000},
+	}
+
+	want := []FlairStats{
+		{Key: "help", Name: "Help", Count: 3, First: 1000, Last: 3000},
+		{Key: "", Name: FlairsDefaultUnflaired, Count: 4, First: 500, Last: 5000},
+	}
+	if got := c.combineFlairStats(stats); !reflect.DeepEqual(got, want) {
+		t.Errorf("combineFlairStats = %+v, want %+v", got, want)
+	}
+}
+
+func TestFlairsCommand(t *testing.T) {
+	forEachFakeReddit(t, func(t *testing.T, c *Client, fake *fakeReddit) {
+		c.Config.Constants = goldenConstants
+		replies, err := NewReplyTemplates(c.Config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Replies = replies
+
+		m := &reddit.Message{FullID: "t1_m", Author: "user", Subject: RedditSubjectMention, IsComment: true}
+		reply := func(arguments ...string) string {
+			t.Helper()
+			if ce := c.FlairsCommand(m, arguments); ce != nil {
+				t.Fatal(ce)
+			}
+
+			// Every reply is to the same message, so the claim is cleared for the next.
+			if ce := c.Archive.setReply(m.FullID, ""); ce != nil {
+				t.Fatal(ce)
+			}
+
+			return fake.comments[0]["body"].(string)
+		}
+
+		if body := reply(); !strings.HasPrefix(body, "There aren't any archived posts yet.") {
+			t.Errorf("flairs of an empty archive = %q", body)
+		}
+
+		// There are five flairs more than fit on a page, and the one with two posts sorts first.
+		submissions := []PushshiftSubmission{testSubmission("popular", "Popular", "Flair 00", 1, 1000)}
+		for i := 0; i < FlairsPageSize+5; i++ {
+			submissions = append(submissions, testSubmission(fmt.Sprintf("f%d", i), "Post", fmt.Sprintf("Flair %02d", i), 1, 1000))
+		}
+		if err := c.Archive.addSubmissions(submissions); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			arguments []string
+			contains  []string
+			rows      int
+		}{
+			{nil, []string{"Page 1 of 2. Add `page 2` for more.", "Flair 00 | 2 | 1970-01-01 | 1970-01-01\nFlair 01 | 1 |"}, FlairsPageSize},
+			{[]string{"page", "2"}, []string{"Page 2 of 2.\n", "Flair 79 | 1 |"}, 5},
+			{[]string{"PAGE", "1"}, []string{"Page 1 of 2."}, FlairsPageSize},
+			{[]string{"page", "3"}, []string{"There are only 2 pages of flairs."}, 0},
+			{[]string{"page", "x"}, []string{"`x` isn't a page number."}, 0},
+			{[]string{"page", "0"}, []string{"`0` isn't a page number."}, 0},
+			{[]string{"all"}, []string{"It's written as `flairs [page N]`."}, 0},
+		}
+
+		for _, test := range tests {
+			body := reply(test.arguments...)
+			for _, want := range test.contains {
+				if !strings.Contains(body, want) {
+					t.Errorf("flairs %q = %q, want it to contain %q", test.arguments, body, want)
+				}
+			}
+
+			if rows := strings.Count(body, " | 1970-01-01 | 1970-01-01"); rows != test.rows {
+				t.Errorf("flairs %q has %d rows, want %d", test.arguments, rows, test.rows)
+			}
+		}
+	})
+}
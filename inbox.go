@@ -3,9 +3,10 @@ package main
 import (
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 
-	"github.com/go-redis/redis/v8"
 	"github.com/vartanbeno/go-reddit/reddit"
 )
 
@@ -47,223 +48,592 @@ func (c *Client) ReplyToInbox() {
 }
 
 func (c *Client) replyToInbox() *ContextError {
-	// TODO: make it actually iterate backwards.
-
 	c.Logger.Infof("Reading inbox")
 
-	comments, _, _, err := c.Reddit.Message.InboxUnread(ctx, &reddit.ListOptions{
-		Limit: 100,
-	})
-	if err != nil {
-		return NewContextlessError(err)
+	unread, ce := c.readUnreadInbox()
+	if ce != nil {
+		return ce
+	}
+
+	if len(unread) == 0 {
+		c.Logger.Info("No new messages.")
+		return nil
+	}
+
+	pass, ce := c.newInboxPass(unread)
+	if ce != nil {
+		return ce
 	}
 
-	if len(comments.Messages) == 0 {
-		c.Logger.Info("No new comments.")
+	// Messages are marked read in batches as they're answered, so a failure doesn't hold back the rest.
+	var read []*reddit.Message
+	flush := func() *ContextError {
+		if len(read) == 0 {
+			return nil
+		}
+
+		fullIDs := make([]string, len(read))
+		for i, item := range read {
+			fullIDs[i] = item.FullID
+		}
+
+		read = read[:0]
+		return c.MarkAsRead(fullIDs)
 	}
 
-	var read []string
-	for _, item := range comments.Messages {
-		if strings.Contains(item.Text, "u/"+c.Config.Reddit.Username) {
-			err := c.replyToComment(item)
-			if err != nil {
+	for _, item := range unread {
+		answered, ce := pass.isAnswered(item)
+		if ce != nil {
+			return ce
+		}
+
+		if !answered {
+			if err := c.replyToInboxItem(item); err != nil {
 				c.dfatal(err)
 				continue
 			}
-			read = append(read, item.FullID)
-		} else {
-			c.Logger.Infof("Not a mention, skipping processing message (ID: %s).\nBody: %s", item.FullID, item.Text)
 		}
+
+		read = append(read, item)
+		if len(read) == RedditMarkReadBatch {
+			if ce := flush(); ce != nil {
+				return ce
+			}
+		}
+	}
+
+	return flush()
+}
+
+// InboxMaxPages is the most pages of unread messages read at once. Reddit's listings end after 1,000 items anyway.
+const InboxMaxPages = 10
+
+// RedditListingLimit is the most items Reddit returns in a page of a listing.
+const RedditListingLimit = 100
+
+// readUnreadInbox reads every page of unread comments and private messages, returning them oldest first so conversations are answered in order.
+// The unread listing has no anchor to resume from: answered messages are marked read and leave it, so each pass pages from the newest and
+// messages past InboxMaxPages are read by a later pass once those before them are answered.
+func (c *Client) readUnreadInbox() ([]*reddit.Message, *ContextError) {
+	var unread []*reddit.Message
+	after := ""
+	for page := 0; page < InboxMaxPages; page++ {
+		comments, messages, _, err := c.Reddit.Message.InboxUnread(ctx, &reddit.ListOptions{
+			Limit: RedditListingLimit,
+			After: after,
+		})
+		if err != nil {
+			return nil, NewWrappedError("reading unread inbox", err, []ContextParam{
+				{"After", after},
+			})
+		}
+
+		unread = append(unread, comments.Messages...)
+		unread = append(unread, messages.Messages...)
+		if comments.After == "" {
+			break
+		}
+
+		after = comments.After
+	}
+
+	sort.SliceStable(unread, func(i, j int) bool {
+		return messageCreated(unread[i]).Before(messageCreated(unread[j]))
+	})
+
+	return unread, nil
+}
+
+// messageCreated returns when the message was sent, or the zero time if Reddit didn't say.
+func messageCreated(m *reddit.Message) reddit.Timestamp {
+	if m.Created == nil {
+		return reddit.Timestamp{}
+	}
+
+	return *m.Created
+}
+
+// inboxPass is what's known about the messages of a pass through the inbox, to not answer any twice.
+type inboxPass struct {
+	client      *Client
+	processed   map[string]bool
+	replies     map[string]string
+	sentReplies map[string]string // Lazily read when a reply was claimed but not recorded as sent.
+}
+
+func (c *Client) newInboxPass(messages []*reddit.Message) (*inboxPass, *ContextError) {
+	fullIDs := make([]string, len(messages))
+	for i, item := range messages {
+		fullIDs[i] = item.FullID
+	}
+
+	processed, ce := c.Archive.getProcessed(fullIDs)
+	if ce != nil {
+		return nil, ce
+	}
+
+	replies, ce := c.Archive.getReplies(fullIDs)
+	if ce != nil {
+		return nil, ce
+	}
+
+	return &inboxPass{client: c, processed: processed, replies: replies}, nil
+}
+
+// isAnswered checks whether the message was processed or replied to already.
+func (p *inboxPass) isAnswered(item *reddit.Message) (bool, *ContextError) {
+	c := p.client
+	if p.processed[item.FullID] {
+		c.Logger.Infof("Already processed message (ID: %s), marking it as read.", item.FullID)
+		return true, nil
+	}
+
+	replyID, claimed := p.replies[item.FullID]
+	if !claimed {
+		return false, nil
+	}
+
+	// A claim without a reply means the bot stopped while replying, so the reply may have been sent.
+	if replyID == "" && !item.IsComment {
+		sent, ce := c.hasSentMessage(item)
+		if ce != nil || !sent {
+			return false, ce
+		}
+
+		replyID = RepliedPrivately
+		if ce := c.Archive.setReply(item.FullID, replyID); ce != nil {
+			return false, ce
+		}
+	} else if replyID == "" {
+		if p.sentReplies == nil {
+			sentReplies, ce := c.getSentReplies()
+			if ce != nil {
+				return false, ce
+			}
+
+			p.sentReplies = sentReplies
+		}
+
+		if replyID = p.sentReplies[item.FullID]; replyID == "" {
+			return false, nil
+		}
+
+		if ce := c.Archive.setReply(item.FullID, replyID); ce != nil {
+			return false, ce
+		}
+	}
+
+	c.Logger.Infof("Already replied to message (ID: %s) with %s, marking it as read.", item.FullID, replyID)
+	return true, nil
+}
+
+// InboxKind is what an inbox item is, deciding how it's answered.
+type InboxKind int
+
+// Inbox kinds.
+const (
+	InboxMention        InboxKind = iota // A comment mentioning the bot, which gives a command.
+	InboxCommentReply                    // A reply to one of the bot's comments, which may follow up on it, e.g. "next page".
+	InboxPrivateMessage                  // A private message, which gives a command without a mention and is answered privately.
+	InboxOther                           // Anything else, like a reply to a post, which is only marked read.
+)
+
+// Reddit's subjects of the comments in an inbox.
+const (
+	RedditSubjectMention      = "username mention"
+	RedditSubjectCommentReply = "comment reply"
+)
+
+// classifyInboxItem decides what an inbox item is. Replies mentioning the bot are mentions.
+func (c *Client) classifyInboxItem(m *reddit.Message) InboxKind {
+	if !m.IsComment {
+		return InboxPrivateMessage
+	}
+
+	if _, mentioned := c.afterMention(m.Text); mentioned || m.Subject == RedditSubjectMention {
+		return InboxMention
+	}
+
+	if m.Subject == RedditSubjectCommentReply {
+		return InboxCommentReply
 	}
 
-	return c.MarkAsRead(read)
+	return InboxOther
+}
+
+// afterMention returns the text after the bot's username is mentioned, and whether it is.
+func (c *Client) afterMention(text string) (string, bool) {
+	mention := "u/" + strings.ToLower(c.Config.Reddit.Username)
+	i := strings.Index(strings.ToLower(text), mention)
+	if i == -1 {
+		return "", false
+	}
+
+	return text[i+len(mention):], true
+}
+
+// replyToInboxItem answers an inbox item according to its kind.
+func (c *Client) replyToInboxItem(m *reddit.Message) *ContextError {
+	switch c.classifyInboxItem(m) {
+	case InboxMention:
+		return c.replyToMention(m)
+	case InboxCommentReply:
+		return c.replyToFollowUp(m)
+	case InboxPrivateMessage:
+		return c.replyToPrivateMessage(m)
+	default:
+		c.Logger.Infof("Not a mention, reply or private message, skipping processing message (ID: %s).\nBody: %s", m.FullID, m.Text)
+		return nil
+	}
 }
 
 // ErrCouldNotParse is the error given when a reply can't be parsed.
 var ErrCouldNotParse = errors.New("Could not parse")
 
-func (c *Client) replyToComment(l *reddit.Message) *ContextError {
-	parts := strings.SplitN(l.Text, "u/"+c.Config.Reddit.Username, 2)
-	if len(parts) == 1 {
-		notParse := c.Config.Constants.CouldNotParse + c.Config.Constants.Footer
-		c.reply(l, notParse)
-		c.Logger.DPanic("Message has no mention in it!")
+func (c *Client) replyToMention(m *reddit.Message) *ContextError {
+	mentionLine, ok := c.afterMention(m.Text)
+	if !ok {
+		// Reddit said it's a mention, but the mention may have been edited out.
+		c.replyCouldNotParse(m, "I couldn't find the mention in your comment.")
 		return NewContextError(ErrCouldNotParse, []ContextParam{
-			{"Reply Author", l.Author},
-			{"Reply ID", l.FullID},
-			{"Reply Text", l.Text},
+			{"Reply Author", m.Author},
+			{"Reply ID", m.FullID},
+			{"Reply Text", m.Text},
 		})
 	}
 
-	mentionLine := parts[1]
-	fields := strings.Fields(mentionLine)
+	return c.replyToCommand(m, mentionLine)
+}
 
-	name := strings.ToLower(fields[0])
+// replyToPrivateMessage runs the command of a private message, which may start with a mention like a comment.
+func (c *Client) replyToPrivateMessage(m *reddit.Message) *ContextError {
+	text := m.Text
+	if mentionLine, ok := c.afterMention(text); ok {
+		text = mentionLine
+	}
 
-	arguments := fields[1:]
+	return c.replyToCommand(m, text)
+}
 
-	constants := c.Config.Constants
-	switch name {
-	case "help":
-		return c.HelpCommand(l, arguments)
-	case "find":
-		fallthrough
-	case "search":
-		return c.SearchCommand(l, arguments)
-	default:
-		return c.reply(l, fmt.Sprintf(constants.CouldNotParse+constants.HelpBody, fmt.Sprintf("Unknown command `%s`.", name)))
+// replyToCommand runs the command in the line of text, or the default command when there isn't one.
+func (c *Client) replyToCommand(m *reddit.Message, line string) *ContextError {
+	fields := strings.Fields(line)
+
+	if len(fields) == 0 {
+		if c.Config.Similar.Default && m.IsComment {
+			return c.SimilarCommand(m, nil)
+		}
+
+		return c.replyCouldNotParse(m, "I need a command, e.g. `search`.")
 	}
+
+	return c.runInboxCommand(m, fields[0], fields[1:])
 }
 
-// HelpCommand is the .
-func (c *Client) HelpCommand(m *reddit.Message, arguments []string) *ContextError {
-	constants := c.Config.Constants
-	return c.reply(m, constants.HelpStart+constants.HelpBody)
+// replyToFollowUp answers a reply to one of the bot's comments.
+// A line which is wholly a command and its arguments is run like in a mention, and "next", "more" or "page N" turn the page of the command that comment answered, if it's Paged.
+// Anything else, like thanks or "help me understand this", is left alone.
+func (c *Client) replyToFollowUp(m *reddit.Message) *ContextError {
+	text := strings.TrimSpace(m.Text)
+	fields := strings.Fields(text)
+	if len(fields) == 0 || strings.Contains(text, "\n") {
+		c.Logger.Infof("Not a follow up, skipping processing reply (ID: %s).\nBody: %s", m.FullID, m.Text)
+		return nil
+	}
+
+	if isCommand, ce := c.parsesInboxCommand(fields); ce != nil {
+		return ce
+	} else if isCommand {
+		return c.runInboxCommand(m, fields[0], fields[1:])
+	}
+
+	page, isNext, ok := parseFollowUpPage(fields)
+	if !ok {
+		c.Logger.Infof("Not a follow up, skipping processing reply (ID: %s).\nBody: %s", m.FullID, m.Text)
+		return nil
+	}
+
+	commandLine, ce := c.Archive.getReplyCommand(m.ParentID)
+	if ce != nil {
+		return ce
+	}
+
+	if strings.TrimSpace(commandLine) == "" {
+		return c.replyCouldNotParse(m, "I don't remember what I replied with, so reply with the whole command instead.")
+	}
+
+	name, arguments, ok := turnPage(commandLine, page, isNext)
+	if !ok {
+		return c.replyCouldNotParse(m, fmt.Sprintf("`%s` only has one page.", name))
+	}
+
+	return c.runInboxCommand(m, name, arguments)
 }
 
-// SearchCommand is the command to search through search terms.
-func (c *Client) SearchCommand(m *reddit.Message, arguments []string) *ContextError {
-	constants := c.Config.Constants
-	couldNotParse := constants.CouldNotParse + constants.HelpBody
-	if len(arguments) == 0 {
-		return c.reply(m, fmt.Sprint(couldNotParse, "I need more info to find you anything."))
+// turnPage returns the command line with its page replaced by the page, or the one after its page if isNext, or false if the command isn't Paged.
+func turnPage(commandLine string, page int, isNext bool) (string, []string, bool) {
+	fields := strings.Fields(commandLine)
+	name, arguments := fields[0], fields[1:]
+	if command, ok := inboxCommands[strings.ToLower(name)]; !ok || !command.Paged {
+		return name, nil, false
 	}
 
-	matches := c.Search.getTitleMatches(arguments[0])
-	var search string
+	currentPage := 1
+	if n := len(arguments); n >= 2 && strings.EqualFold(arguments[n-2], "page") {
+		if number, err := strconv.Atoi(arguments[n-1]); err == nil {
+			currentPage = number
+			arguments = arguments[:n-2]
+		}
+	}
 
-	var flairArgument string
-	if len(matches) == 0 {
-		flairArgument = strings.Join(arguments, " ")
-	} else {
-		search = matches[0]
-		flairArgument = strings.Join(arguments[1:], " ")
+	if isNext {
+		page = currentPage + 1
 	}
 
-	r := c.Redis
-	var searchResults []redis.Z
-	if search != "" {
-		var ce *ContextError
+	return name, append(arguments[:len(arguments):len(arguments)], "page", strconv.Itoa(page)), true
+}
 
-		searchResults, ce = r.getZSet(RedisSearchPrefix + search)
-		if ce != nil {
-			return ce
+// parseFollowUpPage reads "next", "next page", "more" or "page N", returning the page or whether it's the next one.
+func parseFollowUpPage(fields []string) (int, bool, bool) {
+	text := strings.ToLower(strings.Join(fields, " "))
+	text = strings.TrimRight(text, ".!?")
+
+	switch text {
+	case "next", "next page", "more", "more please":
+		return 0, true, true
+	}
+
+	if len(fields) == 2 && strings.EqualFold(fields[0], "page") {
+		page, err := strconv.Atoi(strings.TrimRight(fields[1], ".!?"))
+		if err == nil && page >= 1 {
+			return page, false, true
 		}
 	}
 
-	var flairs []redis.Z
-	if flairArgument != "" {
-		var ce *ContextError
+	return 0, false, false
+}
 
-		flairs, ce = r.getZSet(RedisFlairsPrefix + flairArgument)
-		if ce != nil {
-			return ce
+func init() {
+	registerInboxCommand(InboxCommand{
+		Name:      "search",
+		Aliases:   []string{"find"},
+		Arguments: "<query> [page N]",
+		MinArgs:   1,
+		MaxArgs:   -1,
+		Help:      "Finds archived posts, e.g. `python flair:Help after:2020 sort:top`. Terms are combined with AND, OR and NOT, and filtered with flair:, author:, domain:, before:, after:, in:, last: and score:.",
+		Run:       (*Client).SearchCommand,
+		Parses:    parsesQueryArguments,
+		Paged:     true,
+	})
+}
+
+// SearchCommand is the command to search through search terms, see Query for the syntax.
+func (c *Client) SearchCommand(m *reddit.Message, arguments []string) *ContextError {
+	page := 1
+	if n := len(arguments); n > 2 && strings.EqualFold(arguments[n-2], "page") {
+		var err error
+		page, err = strconv.Atoi(arguments[n-1])
+		if err != nil || page < 1 {
+			return c.replyCouldNotParse(m, fmt.Sprintf("`%s` isn't a page number.", arguments[n-1]))
 		}
+
+		arguments = arguments[:n-2]
 	}
 
-	// The round trip time to do this in a loop would be too expensive to justify. Hopefully memory doesn't overflow.
-	linkMap, err := c.Redis.HGetAll(ctx, RedisLinks).Result()
-	if err != nil {
-		return NewContextError(err, []ContextParam{
-			{"Redis Key", RedisLinks},
-		})
+	argumentString := strings.Join(arguments, " ")
+	query, qe := ParseQuery(argumentString)
+	if qe != nil {
+		return c.replyCouldNotParse(m, qe.Markdown())
 	}
 
-	// The round trip time to do this in a loop would be too expensive to justify. Hopefully memory doesn't overflow.
-	submissions, err := c.Redis.HGetAll(ctx, RedisSubmissions).Result()
-	if err != nil {
-		return NewContextError(err, []ContextParam{
-			{"Redis Key", RedisLinks},
-		})
+	allIDs, err := c.EvaluateQuery(query)
+	var ce *ContextError
+	if errors.As(err, &qe) {
+		return c.replyCouldNotParse(m, qe.Markdown())
+	} else if errors.As(err, &ce) {
+		return ce
 	}
 
-	var allResults []redis.Z
-	if len(searchResults) != 0 && len(flairs) != 0 {
-		allResults = c.ZIntersect(searchResults, flairs)
-	} else if len(searchResults) != 0 {
-		allResults = searchResults
-	} else if len(flairs) != 0 {
-		allResults = flairs
+	a := c.Archive
+	unremovedIDs, ce := a.getUnremoved(allIDs)
+	if ce != nil {
+		return ce
 	}
 
-	results := make([]redis.Z, 0, 25)
-	i := 0
-	for _, result := range allResults {
-		if i >= 25 {
-			break
-		}
+	pageSize := c.Config.Ranking.pageSize()
+	pages := (len(unremovedIDs) + pageSize - 1) / pageSize
+	data := ReplyData{
+		Query:      argumentString,
+		Suggestion: query.Suggestion,
+		Order:      c.describeSort(query),
+		Total:      len(unremovedIDs),
+		Page:       page,
+		Pages:      pages,
+	}
 
-		if _, ok := submissions[result.Member.(string)]; ok {
-			results[i] = result
-			i++
+	if len(unremovedIDs) == 0 {
+		noResults, ce := c.renderParagraphs(m, data, ReplyDidYouMean, ReplyNoResults)
+		if ce != nil {
+			return ce
 		}
+
+		return c.reply(m, noResults)
 	}
 
-	links := make([]string, 0, len(results))
-	for _, result := range results {
-		member, ok := result.Member.(string)
-		if !ok {
-			c.dfatal(fmt.Errorf("Expected search and title results to only contain strings, instead received member of type %T with value %s and score %f", member, member, result.Score))
-			continue
-		}
+	if page > pages {
+		return c.replyCouldNotParse(m, fmt.Sprintf("There are only %d pages of results.", pages))
+	}
 
-		links = append(links, "- "+linkMap[member])
+	resultIDs := unremovedIDs[(page-1)*pageSize:]
+	if len(resultIDs) > pageSize {
+		resultIDs = resultIDs[:pageSize]
 	}
 
-	argumentString := strings.Join(arguments, " ")
-	if len(links) == 0 {
-		noResults := fmt.Sprintf(constants.NoResults, argumentString)
-		return c.reply(m, noResults)
+	linkMap, ce := a.getLinks(resultIDs)
+	if ce != nil {
+		return ce
+	}
+
+	links := make([]string, 0, len(resultIDs))
+	for _, fullID := range resultIDs {
+		links = append(links, "- "+linkMap[fullID])
+	}
+
+	data.Results = len(links)
+	header, ce := c.renderParagraphs(m, data, ReplyDidYouMean, ReplyFoundResults, ReplyResultsPage)
+	if ce != nil {
+		return ce
+	}
+
+	footer, ce := c.footer(m)
+	if ce != nil {
+		return ce
 	}
 
-	allLinks := strings.Join(links, "\n\n")
-	foundResults := fmt.Sprintf(constants.FoundResults+"\n\n", argumentString)
-	return c.reply(m, foundResults+allLinks+constants.Footer)
+	return c.replyWith(m, Reply{
+		Header:    header + "\n\n",
+		Items:     links,
+		Separator: "\n\n",
+		Footer:    footer,
+	})
 }
 
-// ZIntersect gets the intersection of two sorted sets.
-func (c *Client) ZIntersect(a []redis.Z, b []redis.Z) []redis.Z {
-	var larger []redis.Z
-	var smaller []redis.Z
-	if len(a) > len(b) {
-		larger, smaller = a, b
-	} else {
-		larger, smaller = b, a
+// RepliedPrivately is recorded as the reply to a private message, as Reddit doesn't return the ID of sent messages.
+const RepliedPrivately = "private message"
+
+// replySubject is the subject of a private message answering another.
+func replySubject(m *reddit.Message) string {
+	if strings.HasPrefix(m.Subject, "re: ") {
+		return m.Subject
 	}
 
-	baseSet := make(map[redis.Z]struct{}, len(smaller))
-	for _, item := range smaller {
-		baseSet[item] = struct{}{}
+	return "re: " + m.Subject
+}
+
+// reply replies with the message, cut short if it's too long for a comment.
+func (c *Client) reply(m *reddit.Message, message string) *ContextError {
+	return c.replyWith(m, Reply{Header: message})
+}
+
+// replyWith claims the message before replying and records the reply once every part is sent, so that it's never answered twice.
+// A reply too long for one comment continues in replies to itself, see Reply.split, and is recorded as the full IDs of every part.
+func (c *Client) replyWith(m *reddit.Message, reply Reply) *ContextError {
+	if ce := c.Archive.setReply(m.FullID, ""); ce != nil {
+		return ce
 	}
 
-	i := 0
-	keys := make([]redis.Z, len(smaller))
-	for _, item := range larger {
-		if _, ok := baseSet[item]; ok {
-			if i >= len(smaller) {
-				break
+	parts := reply.split(RedditMaxCommentLength, ReplyMaxParts)
+	replyIDs := make([]string, 0, len(parts))
+	parentID := m.FullID
+	for i, part := range parts {
+		replyID, ce := c.sendReply(m, parentID, part)
+		if ce != nil && len(replyIDs) == 0 {
+			return ce
+		} else if ce != nil {
+			// The parts sent are recorded so the message isn't answered again, and the rest are given up on.
+			if recordCE := c.Archive.setReply(m.FullID, strings.Join(replyIDs, " ")); recordCE != nil {
+				return recordCE
 			}
 
-			keys[i] = item
-			i++
+			return ce.AddContext("Unsent Parts", strconv.Itoa(len(parts)-i))
 		}
+
+		// Private messages have no ID to reply to, so the parts are recorded as one.
+		if replyID != RepliedPrivately || len(replyIDs) == 0 {
+			replyIDs = append(replyIDs, replyID)
+		}
+		parentID = replyID
 	}
 
-	return keys
+	return c.Archive.setReply(m.FullID, strings.Join(replyIDs, " "))
 }
 
-func (c *Client) reply(m *reddit.Message, message string) *ContextError {
-	_, _, err := c.Reddit.Comment.Submit(ctx, m.FullID, message)
+// sendReply replies to the parent with a comment, or to a private message with a private message, returning the full ID of the reply.
+func (c *Client) sendReply(m *reddit.Message, parentID string, message string) (string, *ContextError) {
+	if !m.IsComment {
+		_, err := c.Reddit.Message.Send(ctx, &reddit.SendMessageRequest{
+			To:      m.Author,
+			Subject: replySubject(m),
+			Text:    message,
+		})
+		if err != nil {
+			return "", NewWrappedError("replying to private message", err, []ContextParam{
+				{"Author", m.Author},
+				{"Message ID", m.FullID},
+				{"Body", message},
+			})
+		}
+
+		return RepliedPrivately, nil
+	}
 
+	comment, _, err := c.Reddit.Comment.Submit(ctx, parentID, message)
 	if err != nil {
-		return NewWrappedError("replying to comment", err, []ContextParam{
+		return "", NewWrappedError("replying to comment", err, []ContextParam{
 			{"Author Reply", m.Author},
-			{"Comment ID", m.FullID},
+			{"Comment ID", parentID},
 			{"Body", message},
 		})
 	}
 
-	return nil
+	return comment.FullID, nil
+}
+
+// RedditSentRepliesLimit is the number of the bot's newest comments checked for replies that may have been sent.
+const RedditSentRepliesLimit = 100
+
+// hasSentMessage checks the bot's newest sent messages for an answer to the private message.
+func (c *Client) hasSentMessage(m *reddit.Message) (bool, *ContextError) {
+	sent, _, err := c.Reddit.Message.Sent(ctx, &reddit.ListOptions{Limit: RedditSentRepliesLimit})
+	if err != nil {
+		return false, NewWrappedError("getting sent messages", err, nil)
+	}
+
+	for _, message := range sent.Messages {
+		if strings.EqualFold(message.To, m.Author) && message.Subject == replySubject(m) && !messageCreated(message).Before(messageCreated(m)) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// getSentReplies returns a map of the full IDs the bot's newest comments reply to, to the comment's full ID.
+func (c *Client) getSentReplies() (map[string]string, *ContextError) {
+	comments, _, err := c.Reddit.User.Comments(ctx, &reddit.ListUserOverviewOptions{
+		ListOptions: reddit.ListOptions{Limit: RedditSentRepliesLimit},
+		Sort:        "new",
+	})
+	if err != nil {
+		return nil, NewWrappedError("getting sent replies", err, nil)
+	}
+
+	sentReplies := make(map[string]string, len(comments.Comments))
+	for _, comment := range comments.Comments {
+		sentReplies[comment.ParentID] = comment.FullID
+	}
+
+	return sentReplies, nil
 }
 
 // RedditMarkReadPayload is the payload Reddit requires to mark submissions as read.
@@ -292,15 +662,8 @@ func (c *Client) MarkAsRead(ids []string) *ContextError {
 			})
 		}
 
-		processed := make([]interface{}, len(idBatch))
-		for i, id := range idBatch {
-			processed[i] = id
-		}
-
-		if err := c.Redis.SAdd(ctx, RedisProcessed, processed...).Err(); err != nil {
-			return NewWrappedError("could not add processed submissions to Redis", err, []ContextParam{
-				{"IDs", fmt.Sprint(idBatch)},
-			})
+		if ce := c.Archive.addProcessed(idBatch); ce != nil {
+			return ce
 		}
 
 		ids = ids[len(idBatch):]
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vartanbeno/go-reddit/reddit"
+)
+
+func TestTurnPage(t *testing.T) {
+	tests := []struct {
+		name          string
+		commandLine   string
+		page          int
+		isNext        bool
+		wantName      string
+		wantArguments []string
+		wantOK        bool
+	}{
+		{"next after search", "search python", 0, true, "search", []string{"python", "page", "2"}, true},
+		{"next after a page of search", "search python page 2", 0, true, "search", []string{"python", "page", "3"}, true},
+		{"page of search", "search python page 2", 5, false, "search", []string{"python", "page", "5"}, true},
+		{"page of flairs", "flairs", 4, false, "flairs", []string{"page", "4"}, true},
+		{"next after top", "top python month", 0, true, "top", nil, false},
+		{"page of stats", "stats", 2, false, "stats", nil, false},
+		{"unknown command", "foo bar", 0, true, "foo", nil, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			name, arguments, ok := turnPage(test.commandLine, test.page, test.isNext)
+			if name != test.wantName || ok != test.wantOK || !reflect.DeepEqual(arguments, test.wantArguments) {
+				t.Errorf("turnPage(%q, %d, %v) = %q, %q, %v, want %q, %q, %v", test.commandLine, test.page, test.isNext, name, arguments, ok, test.wantName, test.wantArguments, test.wantOK)
+			}
+		})
+	}
+}
+
+// fakeInbox serves Reddit's unread listing, newest first, from messages which can be marked read.
+type fakeInbox struct {
+	lock   sync.Mutex
+	unread []string // Full IDs, newest first.
+}
+
+func (f *fakeInbox) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	start := 0
+	if after := r.URL.Query().Get("after"); after != "" {
+		for i, fullID := range f.unread {
+			if fullID == after {
+				start = i + 1
+			}
+		}
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	end := start + limit
+	if end > len(f.unread) {
+		end = len(f.unread)
+	}
+
+	type thing struct {
+		Kind string                 `json:"kind"`
+		Data map[string]interface{} `json:"data"`
+	}
+	children := make([]thing, 0, end-start)
+	for _, fullID := range f.unread[start:end] {
+		created, _ := strconv.Atoi(fullID[len("t1_"):])
+		children = append(children, thing{"t1", map[string]interface{}{"name": fullID, "created_utc": created}})
+	}
+
+	after := ""
+	if end < len(f.unread) {
+		after = f.unread[end-1]
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"kind": "Listing",
+		"data": map[string]interface{}{"children": children, "after": after},
+	})
+}
+
+// read marks the messages read, removing them from the unread listing.
+func (f *fakeInbox) read(messages []*reddit.Message) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	read := make(map[string]bool, len(messages))
+	for _, m := range messages {
+		read[m.FullID] = true
+	}
+
+	unread := f.unread[:0]
+	for _, fullID := range f.unread {
+		if !read[fullID] {
+			unread = append(unread, fullID)
+		}
+	}
+	f.unread = unread
+}
+
+func TestReadUnreadInboxPastMaxPages(t *testing.T) {
+	total := InboxMaxPages*RedditListingLimit + 50
+	inbox := &fakeInbox{}
+	for i := total - 1; i >= 0; i-- {
+		inbox.unread = append(inbox.unread, fmt.Sprintf("t1_%d", i))
+	}
+
+	server := httptest.NewServer(inbox)
+	defer server.Close()
+
+	redditClient, err := reddit.NewClient(nil, nil, reddit.WithBaseURL(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Client{Reddit: &Reddit{Client: redditClient}}
+
+	// The first pass reads the newest messages it has pages for, and the next the rest once those are read.
+	for pass, want := range [][2]int{{50, total}, {0, 50}} {
+		unread, ce := c.readUnreadInbox()
+		if ce != nil {
+			t.Fatal(ce)
+		}
+
+		if len(unread) != want[1]-want[0] {
+			t.Fatalf("pass %d read %d messages, want %d", pass, len(unread), want[1]-want[0])
+		}
+
+		for i, m := range unread {
+			if wantID := fmt.Sprintf("t1_%d", want[0]+i); m.FullID != wantID {
+				t.Fatalf("pass %d read %s at %d, want %s oldest first", pass, m.FullID, i, wantID)
+			}
+		}
+
+		inbox.read(unread)
+	}
+
+	if len(inbox.unread) != 0 {
+		t.Errorf("%d messages were never read", len(inbox.unread))
+	}
+}
+
+// fakeReddit serves the bot's newest comments and sent messages, and accepts new comments and messages.
+type fakeReddit struct {
+	lock      sync.Mutex
+	comments  []map[string]interface{} // The bot's comments, newest first.
+	sent      []map[string]interface{} // The bot's private messages, newest first.
+	failAfter int                      // How many more comments are accepted before they fail, or -1 for every one.
+}
+
+func (f *fakeReddit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	listing := func(kind string, things []map[string]interface{}) {
+		children := make([]map[string]interface{}, len(things))
+		for i, thing := range things {
+			children[i] = map[string]interface{}{"kind": kind, "data": thing}
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"kind": "Listing",
+			"data": map[string]interface{}{"children": children},
+		})
+	}
+
+	switch {
+	case r.URL.Path == "/api/comment":
+		if f.failAfter == 0 {
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		f.failAfter--
+
+		comment := map[string]interface{}{
+			"name":      fmt.Sprintf("t1_r%d", len(f.comments)+1),
+			"parent_id": r.FormValue("parent"),
+			"body":      r.FormValue("text"),
+		}
+		f.comments = append([]map[string]interface{}{comment}, f.comments...)
+		json.NewEncoder(w).Encode(comment)
+	case r.URL.Path == "/api/compose":
+		f.sent = append([]map[string]interface{}{{
+			"name":        fmt.Sprintf("t4_s%d", len(f.sent)+1),
+			"dest":        r.FormValue("to"),
+			"subject":     r.FormValue("subject"),
+			"body":        r.FormValue("text"),
+			"created_utc": 2000,
+		}}, f.sent...)
+		w.Write([]byte(`{"json":{"errors":[]}}`))
+	case r.URL.Path == "/message/sent":
+		listing("t4", f.sent)
+	case strings.HasSuffix(r.URL.Path, "/comments"):
+		listing("t1", f.comments)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// forEachFakeReddit runs the test with a client of every backend connected to a new fake Reddit.
+func forEachFakeReddit(t *testing.T, test func(t *testing.T, c *Client, fake *fakeReddit)) {
+	forEachBackend(t, testConstants, func(t *testing.T, c *Client) {
+		fake := &fakeReddit{failAfter: -1}
+		server := httptest.NewServer(fake)
+		defer server.Close()
+
+		redditClient, err := reddit.NewClient(nil, nil, reddit.WithBaseURL(server.URL))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.Reddit = &Reddit{Client: redditClient}
+		test(t, c, fake)
+	})
+}
+
+func TestIsAnswered(t *testing.T) {
+	created := &reddit.Timestamp{Time: time.Unix(1000, 0)}
+	mention := func(fullID string) *reddit.Message {
+		return &reddit.Message{FullID: fullID, Author: "user", Subject: RedditSubjectMention, Created: created, IsComment: true}
+	}
+	private := func(fullID string) *reddit.Message {
+		return &reddit.Message{FullID: fullID, Author: "user", Subject: "help " + fullID, Created: created}
+	}
+
+	tests := []struct {
+		name      string
+		message   *reddit.Message
+		processed bool
+		replyID   *string // The recorded reply, nil if it was never claimed.
+		answered  bool
+		recorded  string // The reply recorded after checking.
+	}{
+		{"new", mention("t1_new"), false, nil, false, ""},
+		{"processed", mention("t1_processed"), true, nil, true, ""},
+		{"replied", mention("t1_replied"), false, newString("t1_r9"), true, "t1_r9"},
+		{"claimed and sent", mention("t1_sent"), false, newString(""), true, "t1_r1"},
+		{"claimed but not sent", mention("t1_unsent"), false, newString(""), false, ""},
+		{"private message claimed and sent", private("t4_sent"), false, newString(""), true, RepliedPrivately},
+		{"private message claimed but not sent", private("t4_unsent"), false, newString(""), false, ""},
+	}
+
+	forEachFakeReddit(t, func(t *testing.T, c *Client, fake *fakeReddit) {
+		fake.comments = []map[string]interface{}{{"name": "t1_r1", "parent_id": "t1_sent"}}
+		fake.sent = []map[string]interface{}{{"name": "t4_s1", "dest": "user", "subject": "re: help t4_sent", "created_utc": 2000}}
+
+		messages := make([]*reddit.Message, len(tests))
+		for i, test := range tests {
+			messages[i] = test.message
+			if test.processed {
+				if ce := c.Archive.addProcessed([]string{test.message.FullID}); ce != nil {
+					t.Fatal(ce)
+				}
+			}
+
+			if test.replyID != nil {
+				if ce := c.Archive.setReply(test.message.FullID, *test.replyID); ce != nil {
+					t.Fatal(ce)
+				}
+			}
+		}
+
+		pass, ce := c.newInboxPass(messages)
+		if ce != nil {
+			t.Fatal(ce)
+		}
+
+		for _, test := range tests {
+			answered, ce := pass.isAnswered(test.message)
+			if ce != nil {
+				t.Errorf("%s: %v", test.name, ce)
+				continue
+			}
+
+			if answered != test.answered {
+				t.Errorf("%s: isAnswered = %v, want %v", test.name, answered, test.answered)
+			}
+
+			replies, ce := c.Archive.getReplies([]string{test.message.FullID})
+			if ce != nil {
+				t.Fatal(ce)
+			}
+
+			if replies[test.message.FullID] != test.recorded {
+				t.Errorf("%s: recorded reply %q, want %q", test.name, replies[test.message.FullID], test.recorded)
+			}
+		}
+	})
+}
+
+func TestReplyWithClaims(t *testing.T) {
+	forEachFakeReddit(t, func(t *testing.T, c *Client, fake *fakeReddit) {
+		fake.failAfter = 0
+		m := &reddit.Message{FullID: "t1_m", Author: "user", Subject: RedditSubjectMention, IsComment: true}
+
+		// A reply that fails to send is left claimed, so a later pass checks whether it was sent after all.
+		if ce := c.reply(m, "Hello"); ce == nil {
+			t.Fatal("reply didn't fail")
+		}
+
+		replies, ce := c.Archive.getReplies([]string{m.FullID})
+		if ce != nil {
+			t.Fatal(ce)
+		}
+
+		if replyID, claimed := replies[m.FullID]; !claimed || replyID != "" {
+			t.Errorf("reply failing left %q, %v, want it claimed", replyID, claimed)
+		}
+
+		fake.failAfter = -1
+		if ce := c.reply(m, "Hello"); ce != nil {
+			t.Fatal(ce)
+		}
+
+		pass, ce := c.newInboxPass([]*reddit.Message{m})
+		if ce != nil {
+			t.Fatal(ce)
+		}
+
+		if answered, ce := pass.isAnswered(m); ce != nil || !answered {
+			t.Errorf("isAnswered after replying = %v, %v, want true", answered, ce)
+		}
+
+		if pass.replies[m.FullID] != "t1_r1" || len(fake.comments) != 1 {
+			t.Errorf("recorded %q after sending %d comments, want t1_r1 once", pass.replies[m.FullID], len(fake.comments))
+		}
+	})
+}
+
+func newString(s string) *string {
+	return &s
+}
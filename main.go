@@ -1,11 +1,26 @@
 package main
 
 import (
+	"flag"
 	"time"
 )
 
 func main() {
-	client := NewClient("config.toml")
+	configPath := flag.String("config", "config.toml", "The path of the config.")
+	flag.Usage = printUsage
+	flag.Parse()
+
+	if flag.NArg() != 0 {
+		runCommandLine(*configPath, flag.Arg(0), flag.Args()[1:])
+		return
+	}
+
+	runBot(*configPath)
+}
+
+// runBot runs the bot until it's closed.
+func runBot(configPath string) {
+	client := NewClient(configPath)
 	defer client.Close()
 
 	go func() {
@@ -18,6 +33,8 @@ func main() {
 
 	go analyzeSubmissions(client)
 	go client.ReplyToInbox()
+	go client.BackupArchive()
+	go client.ReloadOnHangup()
 
 	client.Run()
 }
@@ -35,11 +52,21 @@ func readSubmissions(client *Client, nextSubmissions func() ([]PushshiftSubmissi
 			break
 		}
 
-		if err := client.Redis.addSubmissions(submissions); err != nil {
+		newSubmissions, ce := client.newSubmissions(submissions)
+		if ce != nil {
+			client.dfatal(ce)
+			break
+		}
+
+		if err := client.Archive.addSubmissions(submissions); err != nil {
 			client.dfatal(err)
 			break
 		}
 
+		if ce := client.matchSubscriptions(newSubmissions); ce != nil {
+			client.dfatal(ce)
+		}
+
 		addedSubmissions := len(submissions)
 		submissionsCount += addedSubmissions
 
@@ -50,6 +77,10 @@ func readSubmissions(client *Client, nextSubmissions func() ([]PushshiftSubmissi
 	}
 
 	client.Logger.Infof("Added %d Pushshift submissions.", submissionsCount)
+
+	if ce := client.notifySubscribers(); ce != nil {
+		client.dfatal(ce)
+	}
 }
 
 func analyzeSubmissions(client *Client) {
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TitleMatcher finds every search whose name, aliases or patterns appear in a title.
+// The aliases are compiled once into an Aho-Corasick automaton over normalized runes, so a title is scanned a single time regardless of the number of aliases.
+// Aliases match whole words: an alias can't continue a word before or after it, where any Unicode letter, mark or digit is part of a word.
+// Unlike \b in regexp, an alias ending in punctuation like C++ still matches before a space.
+type TitleMatcher struct {
+	normalizer Normalizer
+	canonical  []string      // The canonical name of each search, in config order.
+	nodes      []matcherNode // The trie, with nodes[0] as the root.
+	aliases    []string      // Each alias, normalized.
+	lengths    []int         // The length in runes of each alias.
+	groups     []int         // The index of the search each alias belongs to.
+	rules      []matcherRules
+}
+
+// matcherRules are the parts of a search that aren't plain aliases.
+type matcherRules struct {
+	patterns []*regexp.Regexp
+	exclude  []*regexp.Regexp
+	scope    map[string]bool
+}
+
+type matcherNode struct {
+	next    map[rune]int
+	fail    int
+	output  []int // Aliases ending at this node.
+	dictSuf int   // The nearest node along the fail links with output, or -1.
+}
+
+// NewTitleMatcher compiles the search terms, returning an error for an invalid definition.
+func NewTitleMatcher(terms []SearchTerm, normalizer Normalizer) (*TitleMatcher, error) {
+	m := &TitleMatcher{
+		normalizer: normalizer,
+		nodes:      []matcherNode{{next: make(map[rune]int), dictSuf: -1}},
+	}
+
+	names := make(map[string]bool, len(terms))
+	for _, term := range terms {
+		if strings.TrimSpace(term.Name) == "" {
+			return nil, fmt.Errorf("%w: a search has no name", ErrInvalidSearch)
+		}
+
+		if names[term.Name] {
+			return nil, fmt.Errorf("%w: %s is defined twice", ErrInvalidSearch, term.Name)
+		}
+		names[term.Name] = true
+
+		rules, err := compileRules(term, normalizer)
+		if err != nil {
+			return nil, err
+		}
+
+		group := len(m.canonical)
+		m.canonical = append(m.canonical, term.Name)
+		m.rules = append(m.rules, rules)
+
+		// Aliases are often spelt differently only by case, which would otherwise be checked twice.
+		aliases := append([]string{term.Name}, term.Aliases...)
+		added := make(map[string]bool, len(aliases))
+		for _, alias := range aliases {
+			runes := normalizer.Fold(alias)
+			if len(runes) == 0 || added[string(runes)] {
+				continue
+			}
+
+			added[string(runes)] = true
+			m.add(runes, group)
+		}
+	}
+
+	m.build()
+	return m, nil
+}
+
+// compileRules compiles the patterns of a search, which match normalized text and so are case insensitive.
+// The literal text of a pattern is normalized like titles are, so e.g. a pattern with an accent matches when diacritics are stripped.
+func compileRules(term SearchTerm, normalizer Normalizer) (matcherRules, error) {
+	rules := matcherRules{scope: make(map[string]bool)}
+
+	compile := func(patterns []string) ([]*regexp.Regexp, error) {
+		compiled := make([]*regexp.Regexp, len(patterns))
+		for i, pattern := range patterns {
+			parsed, err := syntax.Parse("(?i)"+pattern, syntax.Perl)
+			if err != nil {
+				return nil, fmt.Errorf("%w: pattern of %s: %v", ErrInvalidSearch, term.Name, err)
+			}
+			normalizeLiterals(parsed, normalizer)
+
+			exp, err := regexp.Compile(parsed.String())
+			if err != nil {
+				return nil, fmt.Errorf("%w: pattern of %s: %v", ErrInvalidSearch, term.Name, err)
+			}
+			compiled[i] = exp
+		}
+
+		return compiled, nil
+	}
+
+	var err error
+	if rules.patterns, err = compile(term.Patterns); err != nil {
+		return rules, err
+	}
+
+	if rules.exclude, err = compile(term.Exclude); err != nil {
+		return rules, err
+	}
+
+	scope := term.Scope
+	if len(scope) == 0 {
+		scope = []string{SearchScopeTitle}
+	}
+
+	for _, field := range scope {
+		switch field {
+		case SearchScopeTitle, SearchScopeSelftext, SearchScopeURL, SearchScopeDomain:
+			rules.scope[field] = true
+		default:
+			return rules, fmt.Errorf("%w: unknown scope %q of %s, expected %s, %s, %s or %s", ErrInvalidSearch, field, term.Name, SearchScopeTitle, SearchScopeSelftext, SearchScopeURL, SearchScopeDomain)
+		}
+	}
+
+	return rules, nil
+}
+
+// normalizeLiterals normalizes the literal text of the parsed pattern.
+func normalizeLiterals(re *syntax.Regexp, normalizer Normalizer) {
+	if re.Op == syntax.OpLiteral {
+		re.Rune = normalizer.Fold(string(re.Rune))
+		if len(re.Rune) == 0 {
+			re.Op = syntax.OpEmptyMatch
+		}
+	}
+
+	for _, sub := range re.Sub {
+		normalizeLiterals(sub, normalizer)
+	}
+}
+
+// add inserts a folded alias into the trie.
+// An alias shared by several searches is a separate pattern for each of them.
+func (m *TitleMatcher) add(runes []rune, group int) {
+	node := 0
+	for _, r := range runes {
+		child, ok := m.nodes[node].next[r]
+		if !ok {
+			child = len(m.nodes)
+			m.nodes = append(m.nodes, matcherNode{next: make(map[rune]int), dictSuf: -1})
+			m.nodes[node].next[r] = child
+		}
+		node = child
+	}
+
+	m.nodes[node].output = append(m.nodes[node].output, len(m.lengths))
+	m.aliases = append(m.aliases, string(runes))
+	m.lengths = append(m.lengths, len(runes))
+	m.groups = append(m.groups, group)
+}
+
+// build links every node to the longest proper suffix in the trie.
+func (m *TitleMatcher) build() {
+	queue := make([]int, 0, len(m.nodes))
+	for _, child := range m.nodes[0].next {
+		m.nodes[child].fail = 0
+		queue = append(queue, child)
+	}
+
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+
+		for r, child := range m.nodes[node].next {
+			fail := m.nodes[node].fail
+			for {
+				if next, ok := m.nodes[fail].next[r]; ok && next != child {
+					m.nodes[child].fail = next
+					break
+				}
+
+				if fail == 0 {
+					m.nodes[child].fail = 0
+					break
+				}
+
+				fail = m.nodes[fail].fail
+			}
+
+			failNode := m.nodes[child].fail
+			if len(m.nodes[failNode].output) != 0 {
+				m.nodes[child].dictSuf = failNode
+			} else {
+				m.nodes[child].dictSuf = m.nodes[failNode].dictSuf
+			}
+
+			queue = append(queue, child)
+		}
+	}
+}
+
+// Match returns the canonical name of every search found in the text, in config order, regardless of their scope.
+func (m *TitleMatcher) Match(text string) []string {
+	return m.names(m.find(text))
+}
+
+// MatchSubmission returns the canonical name of every search found in the parts of the submission in its scope, in config order.
+// The submission is matched as it's archived, so its selftext is matched in full even when it isn't stored.
+func (m *TitleMatcher) MatchSubmission(submission PushshiftSubmission) []string {
+	selftext, _ := submission.Raw["selftext"].(string)
+	url, _ := submission.Raw["url"].(string)
+	domain, _ := submission.Raw["domain"].(string)
+	texts := map[string]string{
+		SearchScopeTitle:    submission.Title,
+		SearchScopeSelftext: selftext,
+		SearchScopeURL:      url,
+		SearchScopeDomain:   domain,
+	}
+
+	found := make([]bool, len(m.canonical))
+	for scope, text := range texts {
+		if text == "" {
+			continue
+		}
+
+		for group, isFound := range m.find(text) {
+			if isFound && m.rules[group].scope[scope] {
+				found[group] = true
+			}
+		}
+	}
+
+	return m.names(found)
+}
+
+// find returns whether each search is in the text.
+// A search is found when one of its aliases or patterns matches outside of every match of its exclusions.
+func (m *TitleMatcher) find(text string) []bool {
+	text = m.normalizer.Normalize(text)
+	runes := []rune(text)
+	found := make([]bool, len(m.canonical))
+
+	// Matches are kept as byte spans of the text, which is what regexps return.
+	offsets := make([]int, len(runes)+1)
+	for i, r := range runes {
+		offsets[i+1] = offsets[i] + utf8.RuneLen(r)
+	}
+
+	spans := make(map[int][][2]int)
+
+	node := 0
+	for i, r := range runes {
+		for {
+			if next, ok := m.nodes[node].next[r]; ok {
+				node = next
+				break
+			}
+
+			if node == 0 {
+				break
+			}
+
+			node = m.nodes[node].fail
+		}
+
+		for output := node; output != -1; output = m.nodes[output].dictSuf {
+			for _, pattern := range m.nodes[output].output {
+				start := i - m.lengths[pattern] + 1
+				if isWholeWord(runes, start, i+1) {
+					group := m.groups[pattern]
+					spans[group] = append(spans[group], [2]int{offsets[start], offsets[i+1]})
+				}
+			}
+		}
+	}
+
+	for group, rules := range m.rules {
+		if len(rules.exclude) == 0 {
+			found[group] = len(spans[group]) != 0
+			for _, pattern := range rules.patterns {
+				if found[group] {
+					break
+				}
+
+				found[group] = pattern.MatchString(text)
+			}
+
+			continue
+		}
+
+		matches := spans[group]
+		for _, pattern := range rules.patterns {
+			for _, span := range pattern.FindAllStringIndex(text, -1) {
+				matches = append(matches, [2]int{span[0], span[1]})
+			}
+		}
+
+		found[group] = hasUnexcluded(matches, rules.exclude, text)
+	}
+
+	return found
+}
+
+// hasUnexcluded reports whether any of the spans doesn't overlap a match of the exclusions, e.g. "rust" in "Rust jobs in the rust belt".
+func hasUnexcluded(spans [][2]int, exclude []*regexp.Regexp, text string) bool {
+	if len(spans) == 0 {
+		return false
+	}
+
+	var excluded [][]int
+	for _, exp := range exclude {
+		excluded = append(excluded, exp.FindAllStringIndex(text, -1)...)
+	}
+
+	for _, span := range spans {
+		overlaps := false
+		for _, e := range excluded {
+			if span[0] < e[1] && e[0] < span[1] {
+				overlaps = true
+				break
+			}
+		}
+
+		if !overlaps {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *TitleMatcher) names(found []bool) []string {
+	var matches []string
+	for group, isFound := range found {
+		if isFound {
+			matches = append(matches, m.canonical[group])
+		}
+	}
+
+	return matches
+}
+
+// isWholeWord reports whether runes[start:end] isn't part of a longer word.
+// Only an end that's part of a word can be continued by the runes next to it.
+func isWholeWord(runes []rune, start, end int) bool {
+	if isWordRune(runes[start]) && start > 0 && isWordRune(runes[start-1]) {
+		return false
+	}
+
+	if isWordRune(runes[end-1]) && end < len(runes) && isWordRune(runes[end]) {
+		return false
+	}
+
+	return true
+}
+
+// isWordRune is like \w in regexp, but isn't limited to ASCII.
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestIsWholeWord(t *testing.T) {
+	tests := []struct {
+		text string
+		word string
+		want bool
+	}{
+		{"go", "go", true},
+		{"go home", "go", true},
+		{"let's go", "go", true},
+		{"going home", "go", false},
+		{"ergo", "go", false},
+		{"go_lang", "go", false},
+		{"go2", "go", false},
+		{"(go)", "go", true},
+		{"go-lang", "go", true},
+		{"go.", "go", true},
+		{"c++ question", "c++", true},
+		{"c++11", "c++", true},
+		{"learning c#", "c#", true},
+		{"abc#", "c#", false},
+		{".net core", ".net", true},
+		{"asp.net", ".net", true},
+		{"café", "caf", false},
+		{"naïve", "na", false},
+		{"日本語", "日本", false},
+		{"日本 語", "日本", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.text+"/"+test.word, func(t *testing.T) {
+			runes, word := []rune(test.text), []rune(test.word)
+			start := strings.Index(test.text, test.word)
+			if start < 0 {
+				t.Fatalf("%q isn't in %q", test.word, test.text)
+			}
+
+			start = len([]rune(test.text[:start]))
+			if got := isWholeWord(runes, start, start+len(word)); got != test.want {
+				t.Errorf("isWholeWord(%q, %q) = %v, want %v", test.text, test.word, got, test.want)
+			}
+		})
+	}
+}
+
+func TestTitleMatcher(t *testing.T) {
+	terms := ConstantsConfig{
+		Searches: [][]string{
+			{"Go", "golang"},
+			{"C++", "cpp"},
+			{"C#", "csharp"},
+			{"Python", "py"},
+			{"Go", "go-lang"},
+		},
+		Terms: []SearchTerm{
+			{Name: "Rust", Exclude: []string{`rust belt`, `\brust(ed|y)\b`}},
+			{Name: "JavaScript", Aliases: []string{"js"}, Patterns: []string{`\becmascript ?\d+\b`}},
+			{Name: "Café", Scope: []string{SearchScopeTitle, SearchScopeSelftext}},
+			{Name: "Dessert", Patterns: []string{`\bcrème brûlée\b`}},
+			{Name: "Bytecode", Aliases: []string{"pyc"}},
+		},
+	}.searchTerms()
+
+	matcher, err := NewTitleMatcher(terms, Normalizer{StripDiacritics: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		title string
+		want  []string
+	}{
+		{"How do I learn Go?", []string{"Go"}},
+		{"Going to learn golang", []string{"Go"}},
+		{"Is go-lang still a thing?", []string{"Go"}},
+		{"Ergo, I quit", nil},
+		{"C++ vs C# for game dev", []string{"C++", "C#"}},
+		{"Moving from cpp to csharp", []string{"C++", "C#"}},
+		{"ABC#123 isn't a language", nil},
+		{"Python 3.9 released", []string{"Python"}},
+		{"PYTHON or py?", []string{"Python"}},
+		{"Pythonic code", nil},
+		{"Ｐｙｔｈｏｎ in full width", []string{"Python"}},
+		{"Руthоn written in Cyrillic", []string{"Python"}},
+		{"Py\u200bthon with a zero width space", []string{"Python"}},
+		{"Rust jobs in the rust belt", []string{"Rust"}},
+		{"Life in the Rust Belt", nil},
+		{"My rusty bike", nil},
+		{"Rust 1.50 is out", []string{"Rust"}},
+		{"What's new in ECMAScript 2021", []string{"JavaScript"}},
+		{"JS frameworks in 2021", []string{"JavaScript"}},
+		{"Best cafe in town", []string{"Café"}},
+		{"Best CAFÉ in town", []string{"Café"}},
+		{"Golang and Rust and Python", []string{"Go", "Python", "Rust"}},
+		{"Creme brulee recipe", []string{"Dessert"}},
+		{"CRÈME BRÛLÉE recipe", []string{"Dessert"}},
+		{"Deleting .pyc files", []string{"Bytecode"}},
+		{"Deleting .pуc files", []string{"Bytecode"}},
+		{"Говорю по-рус", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			if got := matcher.Match(test.title); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Match(%q) = %q, want %q", test.title, got, test.want)
+			}
+		})
+	}
+}
+
+func TestTitleMatcherScope(t *testing.T) {
+	matcher, err := NewTitleMatcher([]SearchTerm{
+		{Name: "Python"},
+		{Name: "Django", Scope: []string{SearchScopeTitle, SearchScopeSelftext}},
+		{Name: "GitHub", Scope: []string{SearchScopeDomain}},
+	}, Normalizer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	submission := PushshiftSubmission{
+		PushshiftFields: PushshiftFields{Title: "Help with my project"},
+		Raw: map[string]interface{}{
+			"selftext": "I'm using Python and Django.",
+			"domain":   "github.com",
+		},
+	}
+
+	want := []string{"Django", "GitHub"}
+	if got := matcher.MatchSubmission(submission); !reflect.DeepEqual(got, want) {
+		t.Errorf("MatchSubmission() = %q, want %q", got, want)
+	}
+}
+
+func TestNewTitleMatcherInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		terms []SearchTerm
+	}{
+		{"no name", []SearchTerm{{Name: " "}}},
+		{"defined twice", []SearchTerm{{Name: "Go"}, {Name: "Go"}}},
+		{"invalid pattern", []SearchTerm{{Name: "Go", Patterns: []string{"("}}}},
+		{"invalid exclude", []SearchTerm{{Name: "Go", Exclude: []string{"["}}}},
+		{"unknown scope", []SearchTerm{{Name: "Go", Scope: []string{"body"}}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewTitleMatcher(test.terms, Normalizer{}); !errors.Is(err, ErrInvalidSearch) {
+				t.Errorf("NewTitleMatcher() = %v, want %v", err, ErrInvalidSearch)
+			}
+		})
+	}
+}
+
+// regexpTitleMatches is how titles were matched before TitleMatcher, compiling a regexp for every alias on every title.
+func regexpTitleMatches(searches [][]string, title string) []string {
+	var matches []string
+	for _, searches := range searches {
+		canonical := searches[0]
+		for _, search := range searches {
+			exp := regexp.MustCompile("(?i)\\b" + regexp.QuoteMeta(search) + "\\b")
+			if exp.MatchString(title) {
+				matches = append(matches, canonical)
+				break
+			}
+		}
+	}
+
+	return matches
+}
+
+// benchmarkSearches generates searches configured as arrays of aliases, and titles mixing random aliases with filler so that some, but not all, searches match.
+func benchmarkSearches(count int) ([][]string, []string) {
+	random := rand.New(rand.NewSource(1))
+	searches := make([][]string, count)
+	var aliases []string
+	for i := range searches {
+		searches[i] = []string{fmt.Sprintf("search%d", i), fmt.Sprintf("alias%d", i), fmt.Sprintf("other%d", i)}
+		aliases = append(aliases, searches[i]...)
+	}
+
+	filler := strings.Fields("how do I the a with my new help question about using best way to why is")
+	titles := make([]string, 500)
+	for i := range titles {
+		words := make([]string, 12)
+		for j := range words {
+			if random.Intn(6) == 0 {
+				words[j] = aliases[random.Intn(len(aliases))]
+			} else {
+				words[j] = filler[random.Intn(len(filler))]
+			}
+		}
+		titles[i] = strings.Join(words, " ")
+	}
+
+	return searches, titles
+}
+
+// BenchmarkMatcher compares TitleMatcher to compiling regexps for every alias, matching a page of titles.
+func BenchmarkMatcher(b *testing.B) {
+	searches, titles := benchmarkSearches(50)
+	terms := ConstantsConfig{Searches: searches}.searchTerms()
+
+	b.Run("regexp", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, title := range titles {
+				regexpTitleMatches(searches, title)
+			}
+		}
+	})
+
+	b.Run("compile", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := NewTitleMatcher(terms, Normalizer{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	matcher, err := NewTitleMatcher(terms, Normalizer{})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("matcher", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, title := range titles {
+				matcher.Match(title)
+			}
+		}
+	})
+}
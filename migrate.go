@@ -0,0 +1,249 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// MigrateBatch is the number of rows copied in a single SQLite transaction.
+const MigrateBatch = 500
+
+// toFullID adds the submission prefix to IDs stored without one.
+func toFullID(id string) string {
+	if strings.HasPrefix(id, "t3_") {
+		return id
+	}
+
+	return "t3_" + id
+}
+
+// copyRedis copies an existing Redis archive into the SQLite database.
+// Search membership is copied as it is in Redis rather than recomputed from the current searches.
+func (s *SQLite) copyRedis(r *Redis) *ContextError {
+	logger := s.client.Logger
+
+	var batch []PushshiftSubmission
+	total := 0
+	flush := func() error {
+		err := s.transaction(func(tx *sql.Tx) error {
+			for _, submission := range batch {
+				if err := s.insertSubmission(tx, submission); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+
+		total += len(batch)
+		batch = batch[:0]
+		return err
+	}
+
+	ce := r.scanHash(RedisAllSubmissions, func(fullID, value string) error {
+		var submission PushshiftSubmission
+		if err := submission.UnmarshalBinary([]byte(value)); err != nil {
+			return err
+		}
+
+		batch = append(batch, submission)
+		if len(batch) < MigrateBatch {
+			return nil
+		}
+
+		return flush()
+	})
+	if ce != nil {
+		return ce
+	}
+
+	if err := flush(); err != nil {
+		return NewWrappedError("could not copy submissions", err, nil)
+	}
+
+	logger.Infof("Copied %d submissions.", total)
+
+	terms := 0
+	ce = r.scanKeys(RedisSearchPrefix, func(key string) error {
+		terms++
+		members, err := r.ZRange(ctx, r.key(key), 0, -1).Result()
+		if err != nil {
+			return err
+		}
+
+		term := strings.TrimPrefix(key, RedisSearchPrefix)
+		return s.copyMembers(toFullIDs(members), `INSERT OR IGNORE INTO search_terms (term, submission_id) SELECT ?, id FROM submissions WHERE id = ?`, term)
+	})
+	if ce != nil {
+		return ce
+	}
+
+	upvotes, err := r.ZRangeWithScores(ctx, r.key(RedisUpvotes), 0, -1).Result()
+	if err != nil {
+		return NewWrappedError("could not read "+RedisUpvotes, err, nil)
+	}
+
+	skipped := 0
+	err = s.transaction(func(tx *sql.Tx) error {
+		for _, upvote := range upvotes {
+			fullID := toFullID(fmt.Sprint(upvote.Member))
+
+			// Upvotes can outlive their submission in Redis, but scores reference submissions.
+			var exists int
+			if err := tx.QueryRow(`SELECT COUNT(*) FROM submissions WHERE id = ?`, fullID).Scan(&exists); err != nil {
+				return err
+			} else if exists == 0 {
+				skipped++
+				continue
+			}
+
+			if err := s.insertScore(tx, fullID, int(upvote.Score)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return NewWrappedError("could not copy upvotes", err, nil)
+	}
+
+	removedStates := map[string]bool{RedisSubmissions: false, RedisRemovedSubmissions: true}
+	for key, isRemoved := range removedStates {
+		ids, err := r.getMembers(key)
+		if err != nil {
+			return NewWrappedError("could not read "+key, err, nil)
+		}
+
+		if err := s.copyMembers(toFullIDs(ids), `UPDATE submissions SET removed = ? WHERE id = ?`, isRemoved); err != nil {
+			return NewWrappedError("could not copy "+key, err, nil)
+		}
+	}
+
+	processed, err := r.SMembers(ctx, r.key(RedisProcessed)).Result()
+	if err != nil {
+		return NewWrappedError("could not read "+RedisProcessed, err, nil)
+	}
+
+	if err := s.copyMembers(processed, `INSERT OR IGNORE INTO processed (processed_utc, full_id) VALUES (?, ?)`, time.Now().Unix()); err != nil {
+		return NewWrappedError("could not copy "+RedisProcessed, err, nil)
+	}
+
+	replies, err := r.HGetAll(ctx, r.key(RedisReplies)).Result()
+	if err != nil {
+		return NewWrappedError("could not read "+RedisReplies, err, nil)
+	}
+
+	for fullID, replyID := range replies {
+		if ce := s.setReply(fullID, replyID); ce != nil {
+			return ce
+		}
+	}
+
+	replyCommands, err := r.HGetAll(ctx, r.key(RedisReplyCommands)).Result()
+	if err != nil {
+		return NewWrappedError("could not read "+RedisReplyCommands, err, nil)
+	}
+
+	for replyID, commandLine := range replyCommands {
+		if ce := s.setReplyCommand(replyID, commandLine); ce != nil {
+			return ce
+		}
+	}
+
+	subscriptions, ce := r.getSubscriptions()
+	if ce != nil {
+		return ce
+	}
+
+	for _, subscription := range subscriptions {
+		if ce := s.setSubscription(subscription); ce != nil {
+			return ce
+		}
+	}
+
+	stats := make(ArchiveStats)
+	ce = r.scanKeys(RedisStatsPrefix, func(key string) error {
+		values, err := r.HGetAll(ctx, r.key(key)).Result()
+		if err != nil {
+			return err
+		}
+
+		fields, ce := parseStats(values)
+		if ce != nil {
+			return ce
+		}
+
+		stats[strings.TrimPrefix(key, RedisStatsPrefix)] = fields
+		return nil
+	})
+	if ce != nil {
+		return ce
+	}
+
+	counts := make(map[string]StatsCount)
+	ce = r.scanHash(RedisStatsCounts, func(fullID, value string) error {
+		var count StatsCount
+		if err := count.UnmarshalBinary([]byte(value)); err != nil {
+			return err
+		}
+
+		counts[fullID] = count
+		return nil
+	})
+	if ce != nil {
+		return ce
+	}
+
+	if ce := s.replaceStats(stats, counts); ce != nil {
+		return ce
+	}
+
+	for _, anchorKey := range []string{RedisSearchCurrent, RedisSearchStart, RedisSearchEnd} {
+		anchor, ce := r.getAnchor(anchorKey)
+		if ce != nil && ce.Unwrap().Error() == redis.Nil.Error() {
+			continue
+		} else if ce != nil {
+			return ce
+		}
+
+		if ce := s.setAnchor(anchorKey, anchor.FullID, anchor.Epoch); ce != nil {
+			return ce
+		}
+	}
+
+	logger.Infof("Copied %d search terms, %d upvotes, %d processed messages and %d replies.", terms, len(upvotes)-skipped, len(processed), len(replies))
+	if skipped != 0 {
+		logger.Warnf("Skipped the upvotes of %d submissions which aren't archived.", skipped)
+	}
+
+	return nil
+}
+
+// toFullIDs adds the submission prefix to submission IDs stored without one, see toFullID.
+func toFullIDs(ids []string) []string {
+	fullIDs := make([]string, len(ids))
+	for i, id := range ids {
+		fullIDs[i] = toFullID(id)
+	}
+
+	return fullIDs
+}
+
+// copyMembers runs the statement for every ID in one transaction.
+// The statement is given the shared argument followed by the ID as it is, so submission IDs have to be passed through toFullIDs first.
+func (s *SQLite) copyMembers(ids []string, statement string, arg interface{}) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		for _, id := range ids {
+			if _, err := tx.Exec(statement, arg, id); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalizer folds text so that the different ways of writing a word compare equal.
+// Titles, aliases and queries all go through the same steps:
+//  1. NFKC, so full-width letters and ligatures become their plain forms.
+//  2. Case folding, which unlike lowercasing also folds e.g. ß to ss.
+//  3. Optionally stripping diacritics, so café matches cafe.
+//  4. Removing invisible formatting characters like zero width spaces and soft hyphens.
+//  5. Mapping letters that look like Latin letters, e.g. Cyrillic а and Greek ο, to them in words which mix them with Latin letters.
+//     Words wholly in another script are kept, so Cyrillic рус doesn't become pyc.
+type Normalizer struct {
+	StripDiacritics bool
+}
+
+// confusables maps lowercase letters of other scripts to the Latin letters they're indistinguishable from.
+var confusables = map[rune]rune{
+	// Cyrillic.
+	'а': 'a', 'в': 'b', 'е': 'e', 'і': 'i', 'ј': 'j', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o',
+	'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'ѕ': 's', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w', 'һ': 'h',
+	'ӏ': 'l',
+	// Greek.
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'τ': 't',
+	'υ': 'u', 'χ': 'x', 'ϲ': 'c', 'ϳ': 'j',
+	// Latin letters used as lookalikes, which are mapped in every word.
+	'ı': 'i', 'ɡ': 'g', 'ɑ': 'a', 'ʏ': 'y',
+}
+
+// Normalize returns the normalized text.
+func (n Normalizer) Normalize(text string) string {
+	text = norm.NFKC.String(text)
+	text = cases.Fold().String(text)
+
+	if n.StripDiacritics {
+		text = stripDiacritics(text)
+	}
+
+	text = strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+
+		return r
+	}, text)
+
+	return foldConfusables(text)
+}
+
+// foldConfusables maps the confusables of every word which mixes Latin letters with letters of other scripts.
+func foldConfusables(text string) string {
+	runes := []rune(text)
+	for start := 0; start < len(runes); {
+		if !isWordRune(runes[start]) {
+			start++
+			continue
+		}
+
+		end, latin, other := start, false, false
+		for ; end < len(runes) && isWordRune(runes[end]); end++ {
+			if unicode.Is(unicode.Latin, runes[end]) {
+				latin = true
+			} else if unicode.IsLetter(runes[end]) {
+				other = true
+			}
+		}
+
+		for i := start; i < end; i++ {
+			if mapped, ok := confusables[runes[i]]; ok && (latin && other || unicode.Is(unicode.Latin, runes[i])) {
+				runes[i] = mapped
+			}
+		}
+		start = end
+	}
+
+	return string(runes)
+}
+
+// stripDiacritics decomposes the text and removes the combining marks, recomposing what's left.
+func stripDiacritics(text string) string {
+	decomposed := norm.NFD.String(text)
+	stripped := strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Mn, r) {
+			return -1
+		}
+
+		return r
+	}, decomposed)
+
+	return norm.NFC.String(stripped)
+}
+
+// Fold returns the runes of the normalized text.
+func (n Normalizer) Fold(text string) []rune {
+	return []rune(n.Normalize(text))
+}
@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name            string
+		text            string
+		stripDiacritics bool
+		want            string
+	}{
+		{"plain", "python", false, "python"},
+		{"full width", "Ｐｙｔｈｏｎ", false, "python"},
+		{"ligature", "ﬁle", false, "file"},
+		{"case folding", "Straße", false, "strasse"},
+		{"cyrillic confusables", "руthоn", false, "python"},
+		{"greek confusables", "ρyτhοn", false, "python"},
+		{"mixed case confusables", "РYTHON", false, "python"},
+		{"cyrillic word kept", "рус", false, "рус"},
+		{"greek word kept", "ΚΑΤΑ", false, "κατα"},
+		{"only mixed script words mapped", "рус pуc", false, "рус pyc"},
+		{"latin lookalike", "ɡo", false, "go"},
+		{"zero width space", "py\u200bthon", false, "python"},
+		{"soft hyphen", "py\u00adthon", false, "python"},
+		{"byte order mark", "\ufeffpython", false, "python"},
+		{"diacritics kept", "café", false, "café"},
+		{"diacritics stripped", "café", true, "cafe"},
+		{"decomposed diacritics stripped", "café", true, "cafe"},
+		{"diacritics stripped after folding", "CRÈME BRÛLÉE", true, "creme brulee"},
+		{"letters without decomposition", "łódź", true, "łodz"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := Normalizer{StripDiacritics: test.stripDiacritics}.Normalize(test.text)
+			if got != test.want {
+				t.Errorf("Normalize(%q) = %q, want %q", test.text, got, test.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeIdempotent(t *testing.T) {
+	for _, text := range []string{"Ｐｙｔｈｏｎ", "Straße", "руthоn", "CRÈME BRÛLÉE", "ﬁle\u200b", "рус pуc"} {
+		for _, normalizer := range []Normalizer{{}, {StripDiacritics: true}} {
+			once := normalizer.Normalize(text)
+			if twice := normalizer.Normalize(once); twice != once {
+				t.Errorf("Normalize(%q) = %q, but normalizing it again gives %q", text, once, twice)
+			}
+		}
+	}
+}
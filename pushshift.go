@@ -6,7 +6,11 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"strings"
 	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"golang.org/x/net/publicsuffix"
 )
 
 // PushshiftSearch is a structure to traverse history through Pushshift.
@@ -42,6 +46,106 @@ type PushshiftFields struct {
 	LinkFlairText string  `json:"link_flair_text"`
 }
 
+// PushshiftRequiredFields are the raw fields always stored, as PushshiftFields is read from them.
+var PushshiftRequiredFields = []string{"permalink", "id", "title", "ups", "created_utc", "link_flair_text"}
+
+// DefaultStoredFields are the raw fields stored in addition to PushshiftRequiredFields when Storage.stored_fields isn't set.
+var DefaultStoredFields = []string{"author", "selftext", "url", "domain"}
+
+// newPushshiftFields reads the fields from a raw submission.
+func newPushshiftFields(raw map[string]interface{}) PushshiftFields {
+	getString := func(key string) string {
+		value, _ := raw[key].(string)
+		return value
+	}
+
+	getNumber := func(key string) float64 {
+		switch value := raw[key].(type) {
+		case float64:
+			return value
+		case int64:
+			return float64(value)
+		case uint64:
+			return float64(value)
+		default:
+			return 0
+		}
+	}
+
+	return PushshiftFields{
+		Permalink:     getString("permalink"),
+		ID:            getString("id"),
+		Title:         getString("title"),
+		Ups:           int(getNumber("ups")),
+		DateCreated:   getNumber("created_utc"),
+		LinkFlairText: getString("link_flair_text"),
+	}
+}
+
+// author returns the lowercased author of the submission, or an empty string if it's unknown or deleted.
+func (s PushshiftSubmission) author() string {
+	author, _ := s.Raw["author"].(string)
+	if author == "[deleted]" {
+		return ""
+	}
+
+	return strings.ToLower(author)
+}
+
+// domains returns the lowercased domain the submission links to followed by its parent domains, down to the registered domain.
+// For example a link to gist.github.com returns gist.github.com and github.com. Self posts have no domains.
+func (s PushshiftSubmission) domains() []string {
+	domain, _ := s.Raw["domain"].(string)
+	if domain == "" {
+		link, _ := s.Raw["url"].(string)
+		if parsed, err := url.Parse(link); err == nil {
+			domain = parsed.Hostname()
+		}
+	}
+
+	return domainAndParents(domain)
+}
+
+// domainAndParents returns the normalized domain and its parents down to the registered domain.
+func domainAndParents(domain string) []string {
+	domain = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), "www.")
+	if domain == "" || strings.HasPrefix(domain, "self.") {
+		return nil
+	}
+
+	registered, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	if err != nil {
+		return []string{domain}
+	}
+
+	domains := []string{domain}
+	for domain != registered {
+		i := strings.IndexByte(domain, '.')
+		if i == -1 {
+			break
+		}
+
+		domain = domain[i+1:]
+		domains = append(domains, domain)
+	}
+
+	return domains
+}
+
+// Compact returns a copy of the submission keeping only the required raw fields and the given fields.
+func (s PushshiftSubmission) Compact(fields []string) PushshiftSubmission {
+	raw := make(map[string]interface{}, len(PushshiftRequiredFields)+len(fields))
+	for _, fieldList := range [][]string{PushshiftRequiredFields, fields} {
+		for _, field := range fieldList {
+			if value, ok := s.Raw[field]; ok {
+				raw[field] = value
+			}
+		}
+	}
+
+	return PushshiftSubmission{s.PushshiftFields, raw}
+}
+
 // UnmarshalJSON from bytes.
 func (s *PushshiftSubmission) UnmarshalJSON(b []byte) error {
 	var fields PushshiftFields
@@ -67,15 +171,38 @@ func (s PushshiftSubmission) MarshalJSON() ([]byte, error) {
 	return json.Marshal(s.Raw)
 }
 
-// MarshalBinary turns the JSON string into raw bytes.
+// MarshalBinary encodes the raw fields as MessagePack.
+// Use Compact first to only store the needed fields.
 func (s PushshiftSubmission) MarshalBinary() ([]byte, error) {
 	var b bytes.Buffer
-	if err := json.NewEncoder(&b).Encode(s.Raw); err != nil {
+	encoder := msgpack.NewEncoder(&b)
+	encoder.SetSortMapKeys(true)
+	if err := encoder.Encode(s.Raw); err != nil {
 		return nil, err
 	}
 	return b.Bytes(), nil
 }
 
+// UnmarshalBinary decodes MessagePack, or JSON as submissions were stored before being compacted.
+func (s *PushshiftSubmission) UnmarshalBinary(b []byte) error {
+	if len(b) != 0 && b[0] == '{' {
+		return s.UnmarshalJSON(b)
+	}
+
+	decoder := msgpack.NewDecoder(bytes.NewReader(b))
+	decoder.UseLooseInterfaceDecoding(true)
+
+	var raw map[string]interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		return NewContextError(err, []ContextParam{
+			{"storedData", fmt.Sprintf("%q", b)},
+		})
+	}
+
+	*s = PushshiftSubmission{newPushshiftFields(raw), raw}
+	return nil
+}
+
 // ErrSubmissionsRead is returned with ReadSubmissions has finished reading all submissions.
 var ErrSubmissionsRead = errors.New("all submissions read")
 
@@ -0,0 +1,979 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// QueryTextLimit is the most submissions a full text search term can match.
+const QueryTextLimit = 1000
+
+// Query sorts.
+const (
+	QuerySortBest = "best" // Ranked by Config.Ranking, the default.
+	QuerySortNew  = "new"  // Newest first.
+	QuerySortTop  = "top"  // Most upvoted first.
+)
+
+// Query is a parsed search query, for example `python AND (flair:"Help Wanted" OR author:spez OR domain:github.com) NOT django after:2020-01-01 sort:top`.
+// Words and quoted phrases are search terms, AND binds tighter than OR and is implied between terms, and NOT or - excludes a term.
+// Dates are a day, month or year like 2020-06-15, 2020-06 or 2020, and in:2019 or last:30d limit the results to a period.
+type Query struct {
+	Text string
+	Root queryNode
+	Sort string
+
+	// Suggestion is the query with misspelt terms corrected, set by EvaluateQuery when a term was resolved inexactly.
+	Suggestion string
+}
+
+// QueryError is an error in a query caused by the user, pointing at the offending token.
+type QueryError struct {
+	Query   string
+	Start   int // The byte offset of the offending token.
+	End     int
+	Message string
+}
+
+func (qe *QueryError) Error() string {
+	return fmt.Sprintf("%s (at %d in %q)", qe.Message, qe.Start, qe.Query)
+}
+
+// Markdown explains the error with the query in a code block, the offending token underlined.
+func (qe *QueryError) Markdown() string {
+	start := len([]rune(qe.Query[:qe.Start]))
+	width := len([]rune(qe.Query[qe.Start:qe.End]))
+	if width == 0 {
+		width = 1
+	}
+
+	return fmt.Sprintf("%s\n\n    %s\n    %s^%s\n\n", qe.Message, qe.Query, strings.Repeat(" ", start), strings.Repeat("~", width-1))
+}
+
+type queryTokenKind int
+
+const (
+	queryWord queryTokenKind = iota
+	queryPhrase
+	queryField
+	queryAnd
+	queryOr
+	queryNot
+	queryOpen
+	queryClose
+	queryEnd
+)
+
+type queryToken struct {
+	kind  queryTokenKind
+	field string // The field name of a queryField, lowercased.
+	value string
+	start int
+	end   int
+}
+
+// tokenizeQuery splits a query into tokens, keeping their positions for errors.
+func tokenizeQuery(text string) ([]queryToken, *QueryError) {
+	var tokens []queryToken
+	i := 0
+	for i < len(text) {
+		b := text[i]
+		switch {
+		case b == '(' || b == ')':
+			kind := queryOpen
+			if b == ')' {
+				kind = queryClose
+			}
+
+			tokens = append(tokens, queryToken{kind: kind, value: string(b), start: i, end: i + 1})
+			i++
+		case isQuerySeparator(b):
+			i++
+		case b == '-' && i+1 < len(text) && (text[i+1] == '(' || !isQuerySeparator(text[i+1])):
+			// A - followed by a term or group excludes it, while a lone - is a term.
+			tokens = append(tokens, queryToken{kind: queryNot, value: "-", start: i, end: i + 1})
+			i++
+		case b == '"':
+			value, end, qe := readQueryPhrase(text, i)
+			if qe != nil {
+				return nil, qe
+			}
+
+			tokens = append(tokens, queryToken{kind: queryPhrase, value: value, start: i, end: end})
+			i = end
+		default:
+			start := i
+			for i < len(text) && !isQuerySeparator(text[i]) && text[i] != ':' && text[i] != '"' {
+				i++
+			}
+
+			word := text[start:i]
+			if i < len(text) && text[i] == ':' && word != "" {
+				i++
+
+				var value string
+				if i < len(text) && text[i] == '"' {
+					var qe *QueryError
+					value, i, qe = readQueryPhrase(text, i)
+					if qe != nil {
+						return nil, qe
+					}
+				} else {
+					valueStart := i
+					for i < len(text) && !isQuerySeparator(text[i]) {
+						i++
+					}
+					value = text[valueStart:i]
+				}
+
+				tokens = append(tokens, queryToken{kind: queryField, field: strings.ToLower(word), value: value, start: start, end: i})
+				continue
+			}
+
+			if word == "" {
+				// A stray colon or a quote in the middle of a word.
+				i++
+				return nil, &QueryError{text, start, i, fmt.Sprintf("I didn't expect `%s` here.", text[start:i])}
+			}
+
+			kind := queryWord
+			switch strings.ToUpper(word) {
+			case "AND":
+				kind = queryAnd
+			case "OR":
+				kind = queryOr
+			case "NOT":
+				kind = queryNot
+			}
+
+			tokens = append(tokens, queryToken{kind: kind, value: word, start: start, end: i})
+		}
+	}
+
+	tokens = append(tokens, queryToken{kind: queryEnd, start: len(text), end: len(text)})
+	return tokens, nil
+}
+
+// readQueryPhrase reads the quoted phrase starting at text[start], returning its value and the offset after the closing quote.
+func readQueryPhrase(text string, start int) (string, int, *QueryError) {
+	end := strings.IndexByte(text[start+1:], '"')
+	if end == -1 {
+		return "", 0, &QueryError{text, start, len(text), "This quote is never closed."}
+	}
+
+	end += start + 1
+	return text[start+1 : end], end + 1, nil
+}
+
+func isQuerySeparator(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '(' || b == ')'
+}
+
+// queryNode is a node of the query's syntax tree.
+type queryNode interface {
+	token() queryToken
+}
+
+// queryTermNode is a search term, matched against the searches or else the text of submissions.
+type queryTermNode struct{ queryToken }
+
+// queryFieldNode is flair:, author: or domain:.
+type queryFieldNode struct{ queryToken }
+
+// queryDateNode is before:, after:, in: or last:, narrowing the window the archive is read in.
+type queryDateNode struct {
+	queryToken
+	window TimeRange
+}
+
+// queryScoreNode is score:, filtering on upvotes.
+type queryScoreNode struct {
+	queryToken
+	operator string
+	score    float64
+}
+
+type queryNotNode struct {
+	queryToken
+	child queryNode
+}
+
+type queryAndNode struct {
+	queryToken
+	children []queryNode
+}
+
+type queryOrNode struct {
+	queryToken
+	children []queryNode
+}
+
+func (t queryToken) token() queryToken { return t }
+
+// QueryPeriodLayouts are the layouts of dates in queries, each with the length of the period it names.
+var QueryPeriodLayouts = []struct {
+	Layout string
+	Years  int
+	Months int
+	Days   int
+}{
+	{ExportDateLayout, 0, 0, 1},
+	{"2006-01", 0, 1, 0},
+	{"2006", 1, 0, 0},
+}
+
+// parseQueryPeriod parses a day, month or year, returning when it starts and when the next one starts.
+func parseQueryPeriod(value string) (time.Time, time.Time, bool) {
+	for _, period := range QueryPeriodLayouts {
+		start, err := time.Parse(period.Layout, value)
+		if err == nil {
+			return start, start.AddDate(period.Years, period.Months, period.Days), true
+		}
+	}
+
+	return time.Time{}, time.Time{}, false
+}
+
+// parseQueryAgo parses an amount of days, weeks, months or years like 30d, returning that long before now.
+func parseQueryAgo(value string, now time.Time) (time.Time, bool) {
+	if len(value) < 2 {
+		return time.Time{}, false
+	}
+
+	amount, err := strconv.Atoi(value[:len(value)-1])
+	if err != nil || amount <= 0 {
+		return time.Time{}, false
+	}
+
+	switch strings.ToLower(value[len(value)-1:]) {
+	case "d":
+		return now.AddDate(0, 0, -amount), true
+	case "w":
+		return now.AddDate(0, 0, -7*amount), true
+	case "m":
+		return now.AddDate(0, -amount, 0), true
+	case "y":
+		return now.AddDate(-amount, 0, 0), true
+	default:
+		return time.Time{}, false
+	}
+}
+
+// queryParser is a recursive descent parser of:
+//
+//	or      = and { "OR" and }
+//	and     = not { [ "AND" ] not }
+//	not     = [ "NOT" | "-" ] primary
+//	primary = "(" or ")" | word | phrase | field
+type queryParser struct {
+	text   string
+	tokens []queryToken
+	next   int
+	depth  int
+	sort   *queryToken
+}
+
+// ParseQuery parses the text of a search query.
+func ParseQuery(text string) (*Query, *QueryError) {
+	tokens, qe := tokenizeQuery(text)
+	if qe != nil {
+		return nil, qe
+	}
+
+	p := &queryParser{text: text, tokens: tokens}
+	root, qe := p.parseOr()
+	if qe != nil {
+		return nil, qe
+	}
+
+	if token := p.peek(); token.kind != queryEnd {
+		return nil, p.unexpected(token)
+	}
+
+	query := &Query{Text: text, Root: root, Sort: QuerySortBest}
+	if p.sort != nil {
+		query.Sort = strings.ToLower(p.sort.value)
+	}
+
+	if root == nil {
+		return nil, &QueryError{text, 0, len(text), "I need a search term, flair or author to find you anything."}
+	}
+
+	return query, nil
+}
+
+func (p *queryParser) peek() queryToken {
+	return p.tokens[p.next]
+}
+
+func (p *queryParser) advance() queryToken {
+	token := p.tokens[p.next]
+	if token.kind != queryEnd {
+		p.next++
+	}
+
+	return token
+}
+
+func (p *queryParser) unexpected(token queryToken) *QueryError {
+	switch token.kind {
+	case queryEnd:
+		return &QueryError{p.text, token.start, token.end, "The query ended before I expected it to."}
+	case queryClose:
+		return &QueryError{p.text, token.start, token.end, "This parenthesis was never opened."}
+	default:
+		return &QueryError{p.text, token.start, token.end, fmt.Sprintf("I didn't expect `%s` here.", p.text[token.start:token.end])}
+	}
+}
+
+func (p *queryParser) parseOr() (queryNode, *QueryError) {
+	first := p.peek()
+	left, qe := p.parseAnd()
+	if qe != nil {
+		return nil, qe
+	}
+
+	children := []queryNode{left}
+	for p.peek().kind == queryOr {
+		operator := p.advance()
+		if left == nil {
+			return nil, p.unexpected(operator)
+		}
+
+		right, qe := p.parseAnd()
+		if qe != nil {
+			return nil, qe
+		}
+
+		if right == nil {
+			return nil, p.unexpected(p.peek())
+		}
+
+		children = append(children, right)
+	}
+
+	if len(children) == 1 {
+		return left, nil
+	}
+
+	return &queryOrNode{first, children}, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, *QueryError) {
+	first := p.peek()
+
+	var children []queryNode
+	for {
+		token := p.peek()
+		switch token.kind {
+		case queryEnd, queryClose, queryOr:
+			switch len(children) {
+			case 0:
+				// Only possible when the whole group was sort:.
+				return nil, nil
+			case 1:
+				return children[0], nil
+			default:
+				return &queryAndNode{first, children}, nil
+			}
+		case queryAnd:
+			p.advance()
+			if len(children) == 0 {
+				return nil, p.unexpected(token)
+			}
+
+			if next := p.peek(); next.kind == queryEnd || next.kind == queryClose || next.kind == queryOr || next.kind == queryAnd {
+				return nil, p.unexpected(next)
+			}
+		}
+
+		child, qe := p.parseNot()
+		if qe != nil {
+			return nil, qe
+		}
+
+		if child != nil {
+			children = append(children, child)
+		}
+	}
+}
+
+func (p *queryParser) parseNot() (queryNode, *QueryError) {
+	token := p.peek()
+	if token.kind != queryNot {
+		return p.parsePrimary(false)
+	}
+
+	p.advance()
+	if next := p.peek(); next.kind == queryNot {
+		return nil, p.unexpected(next)
+	}
+
+	child, qe := p.parsePrimary(true)
+	if qe != nil {
+		return nil, qe
+	}
+
+	// The token spans what's excluded, so errors point at all of it.
+	token.end = child.token().end
+	return &queryNotNode{token, child}, nil
+}
+
+func (p *queryParser) parsePrimary(negated bool) (queryNode, *QueryError) {
+	token := p.advance()
+	switch token.kind {
+	case queryOpen:
+		p.depth++
+		node, qe := p.parseOr()
+		if qe != nil {
+			return nil, qe
+		}
+		p.depth--
+
+		if node == nil {
+			return nil, &QueryError{p.text, token.start, p.peek().end, "These parentheses are empty."}
+		}
+
+		if end := p.advance(); end.kind != queryClose {
+			return nil, &QueryError{p.text, token.start, token.end, "This parenthesis is never closed."}
+		}
+
+		return node, nil
+	case queryWord, queryPhrase:
+		if strings.TrimSpace(token.value) == "" {
+			return nil, &QueryError{p.text, token.start, token.end, "This phrase is empty."}
+		}
+
+		return &queryTermNode{token}, nil
+	case queryField:
+		return p.parseField(token, negated)
+	default:
+		return nil, p.unexpected(token)
+	}
+}
+
+func (p *queryParser) parseField(token queryToken, negated bool) (queryNode, *QueryError) {
+	if token.value == "" {
+		return nil, &QueryError{p.text, token.start, token.end, fmt.Sprintf("`%s:` needs a value.", token.field)}
+	}
+
+	switch token.field {
+	case "flair", "author":
+		return &queryFieldNode{token}, nil
+	case "domain":
+		// Links are accepted as well as domains.
+		if parsed, err := url.Parse(token.value); err == nil && parsed.Host != "" {
+			token.value = parsed.Hostname()
+		}
+
+		if len(domainAndParents(token.value)) == 0 {
+			return nil, &QueryError{p.text, token.start, token.end, fmt.Sprintf("`%s` isn't a domain like github.com.", token.value)}
+		}
+
+		return &queryFieldNode{token}, nil
+	case "before", "after", "in":
+		start, end, ok := parseQueryPeriod(token.value)
+		if !ok {
+			return nil, &QueryError{p.text, token.start, token.end, fmt.Sprintf("`%s` isn't a date like 2020-12-31, 2020-12 or 2020.", token.value)}
+		}
+
+		// after: includes the period itself like the export filters, and before: excludes it.
+		window := timeBetween(float64(start.Unix()), float64(end.Unix()))
+		if token.field == "after" {
+			window = timeAfter(float64(start.Unix()))
+		} else if token.field == "before" {
+			window = timeBefore(float64(start.Unix()))
+		}
+
+		return &queryDateNode{token, window}, nil
+	case "last":
+		since, ok := parseQueryAgo(token.value, time.Now())
+		if !ok {
+			return nil, &QueryError{p.text, token.start, token.end, fmt.Sprintf("`%s` isn't a period like `last:30d`, `last:2w`, `last:6m` or `last:1y`.", token.value)}
+		}
+
+		return &queryDateNode{token, timeAfter(float64(since.Unix()))}, nil
+	case "score":
+		operator := strings.TrimRight(token.value, "0123456789.-")
+		switch operator {
+		case "", "=", ">", ">=", "<", "<=":
+		default:
+			return nil, &QueryError{p.text, token.start, token.end, fmt.Sprintf("`%s` isn't a comparison like `score:>100`.", token.value)}
+		}
+
+		score, err := strconv.ParseFloat(token.value[len(operator):], 64)
+		if err != nil {
+			return nil, &QueryError{p.text, token.start, token.end, fmt.Sprintf("`%s` isn't a comparison like `score:>100`.", token.value)}
+		}
+
+		return &queryScoreNode{token, operator, score}, nil
+	case "sort":
+		if negated || p.depth != 0 {
+			return nil, &QueryError{p.text, token.start, token.end, "`sort:` can't be negated or grouped."}
+		}
+
+		if p.sort != nil {
+			return nil, &QueryError{p.text, token.start, token.end, "The results can only be sorted once."}
+		}
+
+		switch strings.ToLower(token.value) {
+		case QuerySortBest, QuerySortNew, QuerySortTop:
+		default:
+			return nil, &QueryError{p.text, token.start, token.end, fmt.Sprintf("I can only sort by `%s`, `%s` or `%s`.", QuerySortBest, QuerySortNew, QuerySortTop)}
+		}
+
+		p.sort = &token
+		return nil, nil
+	default:
+		return nil, &QueryError{p.text, token.start, token.end, fmt.Sprintf("I don't know the field `%s:`, try flair:, author:, domain:, before:, after:, in:, last:, score: or sort:.", token.field)}
+	}
+}
+
+// querySet is a map of full IDs to their creation epoch.
+type querySet map[string]float64
+
+// queryEvaluator evaluates a query against the sorted sets of the archive.
+// Errors are either a *QueryError, when the query can't be answered, or a *ContextError.
+type queryEvaluator struct {
+	client    *Client
+	query     *Query
+	window    TimeRange          // Narrowed by the date filters of the AND being evaluated, and read from the archive.
+	upvotes   map[string]float64 // Lazily filled by score filters and sorting.
+	relevance map[string]float64 // How many terms, fields and text searches matched each submission.
+
+	flairNames  []string // Lazily read to resolve terms.
+	corrections []queryCorrection
+}
+
+// queryCorrection replaces the text of a misspelt term.
+type queryCorrection struct {
+	start       int
+	end         int
+	replacement string
+}
+
+// EvaluateQuery returns the full IDs matching the query, in its sort order.
+func (c *Client) EvaluateQuery(query *Query) ([]string, error) {
+	e := &queryEvaluator{
+		client:    c,
+		query:     query,
+		upvotes:   make(map[string]float64),
+		relevance: make(map[string]float64),
+	}
+
+	set, err := e.evaluate(query.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	query.Suggestion = e.suggestion()
+
+	fullIDs := make([]string, 0, len(set))
+	for fullID := range set {
+		fullIDs = append(fullIDs, fullID)
+	}
+
+	switch query.Sort {
+	case QuerySortTop:
+		if err := e.loadUpvotes(fullIDs); err != nil {
+			return nil, err
+		}
+
+		sort.Slice(fullIDs, func(i, j int) bool {
+			a, b := e.upvotes[fullIDs[i]], e.upvotes[fullIDs[j]]
+			if a == b {
+				return set[fullIDs[i]] > set[fullIDs[j]]
+			}
+
+			return a > b
+		})
+	case QuerySortNew:
+		sort.Slice(fullIDs, func(i, j int) bool {
+			return set[fullIDs[i]] > set[fullIDs[j]]
+		})
+	default:
+		if err := e.loadUpvotes(fullIDs); err != nil {
+			return nil, err
+		}
+
+		c.Config.Ranking.rank(fullIDs, set, e.upvotes, e.relevance)
+	}
+
+	return fullIDs, nil
+}
+
+// matchQuery returns the submissions matching the query created in the window, unsorted, like EvaluateQuery for a window the query is narrowed to.
+func (c *Client) matchQuery(query *Query, window TimeRange) (querySet, error) {
+	e := &queryEvaluator{
+		client:    c,
+		query:     query,
+		window:    window,
+		upvotes:   make(map[string]float64),
+		relevance: make(map[string]float64),
+	}
+
+	set, err := e.evaluate(query.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	query.Suggestion = e.suggestion()
+	return set, nil
+}
+
+// describeSort explains the order of the results of the query.
+func (c *Client) describeSort(query *Query) string {
+	switch query.Sort {
+	case QuerySortTop:
+		return "most upvoted first"
+	case QuerySortNew:
+		return "newest first"
+	default:
+		return c.Config.Ranking.describe()
+	}
+}
+
+func (e *queryEvaluator) errorAt(node queryNode, message string) *QueryError {
+	token := node.token()
+	return &QueryError{e.query.Text, token.start, token.end, message}
+}
+
+// evaluate returns the set of submissions matching a node.
+// Filters and exclusions only narrow down a set, so they can't be evaluated alone.
+func (e *queryEvaluator) evaluate(node queryNode) (querySet, error) {
+	switch node := node.(type) {
+	case *queryTermNode:
+		return e.evaluateTerm(node)
+	case *queryFieldNode:
+		var set querySet
+		var ce *ContextError
+		switch node.field {
+		case "flair":
+			set, ce = e.zset(e.client.Archive.getFlair(e.client.searchFlairKey(node.value), e.window))
+		case "author":
+			set, ce = e.zset(e.client.Archive.getAuthor(node.value, e.window))
+		default:
+			set, ce = e.zset(e.client.Archive.getDomain(node.value, e.window))
+		}
+
+		if ce != nil {
+			return nil, ce
+		}
+
+		e.addRelevance(set)
+		return set, nil
+	case *queryAndNode:
+		return e.evaluateAnd(node)
+	case *queryOrNode:
+		union := make(querySet)
+		for _, child := range node.children {
+			set, err := e.evaluate(child)
+			if err != nil {
+				return nil, err
+			}
+
+			for fullID, epoch := range set {
+				union[fullID] = epoch
+			}
+		}
+
+		return union, nil
+	default:
+		text := e.query.Text[node.token().start:node.token().end]
+		return nil, e.errorAt(node, fmt.Sprintf("`%s` has to narrow down a search term, flair or author, like `python %s`.", text, text))
+	}
+}
+
+// evaluateTerm finds the configured searches mentioned by the term, or else searches the text of submissions if the archive can.
+// A term close to a search or flair is only corrected when the text of no submission has it, as it's likely an ordinary word otherwise.
+func (e *queryEvaluator) evaluateTerm(node *queryTermNode) (querySet, error) {
+	c := e.client
+	matches := c.Search.getTitleMatches(node.value)
+	if len(matches) == 0 {
+		resolution, ok, err := e.resolveTerm(node.value)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok && resolution.Cost > 0 {
+			if set, ok, ce := e.searchText(node); ce != nil {
+				return nil, ce
+			} else if ok && len(set) != 0 {
+				return set, nil
+			}
+		}
+
+		if ok && resolution.Flair != "" {
+			if !resolution.Exact {
+				e.correct(node, "flair:"+quoteQueryValue(resolution.Flair))
+			}
+
+			set, ce := e.zset(c.Archive.getFlair(c.searchFlairKey(resolution.Flair), e.window))
+			if ce != nil {
+				return nil, ce
+			}
+
+			e.addRelevance(set)
+			return set, nil
+		}
+
+		if ok {
+			e.correct(node, quoteQueryValue(resolution.Search))
+			matches = []string{resolution.Search}
+		}
+	}
+
+	if len(matches) == 0 {
+		set, ok, ce := e.searchText(node)
+		if ce != nil {
+			return nil, ce
+		}
+
+		if !ok {
+			return nil, e.errorAt(node, fmt.Sprintf("I don't know the search term `%s`.", node.value))
+		}
+
+		return set, nil
+	}
+
+	// A phrase mentioning several searches has to match all of them.
+	var result querySet
+	for _, match := range matches {
+		set, ce := e.zset(c.Archive.getSearch(match, e.window))
+		if ce != nil {
+			return nil, ce
+		}
+
+		e.addRelevance(set)
+		if result == nil {
+			result = set
+		} else {
+			result = result.intersect(set)
+		}
+	}
+
+	return result, nil
+}
+
+// searchText searches the text of submissions for the term, or returns false if the archive can't.
+func (e *queryEvaluator) searchText(node *queryTermNode) (querySet, bool, *ContextError) {
+	textSearcher, ok := e.client.Archive.(TextSearcher)
+	if !ok {
+		return nil, false, nil
+	}
+
+	results, ce := textSearcher.searchText(node.value, QueryTextLimit, e.window)
+	if ce != nil {
+		return nil, false, ce
+	}
+
+	// Results are best match first, so earlier ones are more relevant.
+	for i, result := range results {
+		e.relevance[fmt.Sprint(result.Member)] += 1 - float64(i)/float64(len(results))
+	}
+
+	set, _ := e.zset(results, nil)
+	return set, true, nil
+}
+
+// correct records the replacement of a misspelt term for the suggestion.
+func (e *queryEvaluator) correct(node queryNode, replacement string) {
+	token := node.token()
+	e.corrections = append(e.corrections, queryCorrection{token.start, token.end, replacement})
+}
+
+// suggestion returns the query with every correction, or an empty string if there are none.
+func (e *queryEvaluator) suggestion() string {
+	if len(e.corrections) == 0 {
+		return ""
+	}
+
+	sort.Slice(e.corrections, func(i, j int) bool {
+		return e.corrections[i].start < e.corrections[j].start
+	})
+
+	var b strings.Builder
+	last := 0
+	for _, correction := range e.corrections {
+		b.WriteString(e.query.Text[last:correction.start])
+		b.WriteString(correction.replacement)
+		last = correction.end
+	}
+	b.WriteString(e.query.Text[last:])
+
+	return b.String()
+}
+
+// quoteQueryValue quotes a value with spaces so that it's read as a single term.
+func quoteQueryValue(value string) string {
+	if strings.ContainsAny(value, " \t\n\r()") {
+		return `"` + value + `"`
+	}
+
+	return value
+}
+
+// addRelevance counts a match of every submission in the set.
+func (e *queryEvaluator) addRelevance(set querySet) {
+	for fullID := range set {
+		e.relevance[fullID]++
+	}
+}
+
+func (e *queryEvaluator) evaluateAnd(node *queryAndNode) (querySet, error) {
+	var included []queryNode
+	var excluded []*queryNotNode
+	var filters []*queryScoreNode
+	window := e.window
+	for _, child := range node.children {
+		switch child := child.(type) {
+		case *queryNotNode:
+			excluded = append(excluded, child)
+		case *queryDateNode:
+			window = window.intersect(child.window)
+		case *queryScoreNode:
+			filters = append(filters, child)
+		default:
+			included = append(included, child)
+		}
+	}
+
+	// Date filters are read in the archive rather than filtered afterwards, so only submissions in the window are read.
+	outer := e.window
+	e.window = window
+	defer func() { e.window = outer }()
+
+	var result querySet
+	for _, child := range included {
+		set, err := e.evaluate(child)
+		if err != nil {
+			return nil, err
+		}
+
+		if result == nil {
+			result = set
+		} else {
+			result = result.intersect(set)
+		}
+	}
+
+	if result == nil {
+		var first queryNode = node.children[0]
+		if len(excluded) != 0 {
+			first = excluded[0]
+		}
+
+		_, err := e.evaluate(first)
+		return nil, err
+	}
+
+	for _, not := range excluded {
+		set, err := e.evaluate(not.child)
+		if err != nil {
+			return nil, err
+		}
+
+		for fullID := range set {
+			delete(result, fullID)
+		}
+	}
+
+	for _, filter := range filters {
+		if err := e.filter(result, filter); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// filter removes the submissions not matching a score filter from the set.
+func (e *queryEvaluator) filter(set querySet, node *queryScoreNode) error {
+	fullIDs := make([]string, 0, len(set))
+	for fullID := range set {
+		fullIDs = append(fullIDs, fullID)
+	}
+
+	if err := e.loadUpvotes(fullIDs); err != nil {
+		return err
+	}
+
+	for _, fullID := range fullIDs {
+		if !node.matches(e.upvotes[fullID]) {
+			delete(set, fullID)
+		}
+	}
+
+	return nil
+}
+
+func (n *queryScoreNode) matches(upvotes float64) bool {
+	switch n.operator {
+	case ">":
+		return upvotes > n.score
+	case ">=":
+		return upvotes >= n.score
+	case "<":
+		return upvotes < n.score
+	case "<=":
+		return upvotes <= n.score
+	default:
+		return upvotes == n.score
+	}
+}
+
+// loadUpvotes reads the upvotes of the submissions not already read.
+func (e *queryEvaluator) loadUpvotes(fullIDs []string) error {
+	var missing []string
+	for _, fullID := range fullIDs {
+		if _, ok := e.upvotes[fullID]; !ok {
+			missing = append(missing, fullID)
+		}
+	}
+
+	upvotes, ce := e.client.Archive.getUpvotes(missing)
+	if ce != nil {
+		return ce
+	}
+
+	for _, fullID := range missing {
+		e.upvotes[fullID] = upvotes[fullID]
+	}
+
+	return nil
+}
+
+// zset converts the result of an archive getter into a set.
+func (e *queryEvaluator) zset(results []redis.Z, ce *ContextError) (querySet, *ContextError) {
+	if ce != nil {
+		return nil, ce
+	}
+
+	set := make(querySet, len(results))
+	for _, result := range results {
+		set[fmt.Sprint(result.Member)] = result.Score
+	}
+
+	return set, nil
+}
+
+func (s querySet) intersect(other querySet) querySet {
+	if len(other) < len(s) {
+		s, other = other, s
+	}
+
+	intersection := make(querySet, len(s))
+	for fullID, epoch := range s {
+		if _, ok := other[fullID]; ok {
+			intersection[fullID] = epoch
+		}
+	}
+
+	return intersection
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// formatQueryNode writes the syntax tree as nested groups, e.g. (OR (AND a b) c).
+func formatQueryNode(node queryNode) string {
+	switch node := node.(type) {
+	case nil:
+		return "<nil>"
+	case *queryTermNode:
+		if node.kind == queryPhrase {
+			return fmt.Sprintf("%q", node.value)
+		}
+
+		return node.value
+	case *queryFieldNode:
+		return node.field + ":" + quoteQueryValue(node.value)
+	case *queryDateNode:
+		return fmt.Sprintf("%s:%s[%s]", node.field, node.value, formatTimeRange(node.window))
+	case *queryScoreNode:
+		return fmt.Sprintf("score%s%g", node.operator, node.score)
+	case *queryNotNode:
+		return "(NOT " + formatQueryNode(node.child) + ")"
+	case *queryAndNode:
+		return formatQueryGroup("AND", node.children)
+	case *queryOrNode:
+		return formatQueryGroup("OR", node.children)
+	default:
+		return fmt.Sprintf("%T", node)
+	}
+}
+
+func formatQueryGroup(operator string, children []queryNode) string {
+	formatted := make([]string, len(children))
+	for i, child := range children {
+		formatted[i] = formatQueryNode(child)
+	}
+
+	return "(" + operator + " " + strings.Join(formatted, " ") + ")"
+}
+
+// formatTimeRange writes the epochs of the window, with an open end as *.
+func formatTimeRange(window TimeRange) string {
+	after, before := "*", "*"
+	if window.HasAfter {
+		after = fmt.Sprintf("%.0f", window.After)
+	}
+
+	if window.HasBefore {
+		before = fmt.Sprintf("%.0f", window.Before)
+	}
+
+	return after + "," + before
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+		sort string
+	}{
+		// Precedence: NOT binds tightest, then the implied or explicit AND, then OR.
+		{"python", "python", QuerySortBest},
+		{"python django", "(AND python django)", QuerySortBest},
+		{"python AND django", "(AND python django)", QuerySortBest},
+		{"a b OR c", "(OR (AND a b) c)", QuerySortBest},
+		{"a OR b c", "(OR a (AND b c))", QuerySortBest},
+		{"a AND b OR c AND d", "(OR (AND a b) (AND c d))", QuerySortBest},
+		{"a or b", "(OR a b)", QuerySortBest},
+		{"(a OR b) c", "(AND (OR a b) c)", QuerySortBest},
+		{"((a))", "a", QuerySortBest},
+		{"a (b OR (c d))", "(AND a (OR b (AND c d)))", QuerySortBest},
+		{"NOT a b", "(AND (NOT a) b)", QuerySortBest},
+		{"-a OR b", "(OR (NOT a) b)", QuerySortBest},
+		{"a -(b OR c)", "(AND a (NOT (OR b c)))", QuerySortBest},
+		{"a - b", "(AND a - b)", QuerySortBest},
+		{"c++ -java", "(AND c++ (NOT java))", QuerySortBest},
+
+		// Quoting.
+		{`"hello world" python`, `(AND "hello world" python)`, QuerySortBest},
+		{`"a OR b"`, `"a OR b"`, QuerySortBest},
+		{`"(not) a group"`, `"(not) a group"`, QuerySortBest},
+		{`a"b"`, `(AND a "b")`, QuerySortBest},
+
+		// Fields.
+		{`flair:"Help Wanted"`, `flair:"Help Wanted"`, QuerySortBest},
+		{"Author:spez", "author:spez", QuerySortBest},
+		{"domain:https://www.github.com/golang/go", "domain:www.github.com", QuerySortBest},
+		{"python -domain:youtube.com", "(AND python (NOT domain:youtube.com))", QuerySortBest},
+		{"python score:>=100", "(AND python score>=100)", QuerySortBest},
+		{"python score:5", "(AND python score5)", QuerySortBest},
+		{"python after:2020", "(AND python after:2020[1577836800,*])", QuerySortBest},
+		{"python before:2020-06", "(AND python before:2020-06[*,1590969600])", QuerySortBest},
+		{"python in:2020-06-15", "(AND python in:2020-06-15[1592179200,1592265600])", QuerySortBest},
+		{"python sort:TOP", "python", QuerySortTop},
+		{"sort:new a OR b", "(OR a b)", QuerySortNew},
+	}
+
+	for _, test := range tests {
+		query, qe := ParseQuery(test.text)
+		if qe != nil {
+			t.Errorf("ParseQuery(%q): %v", test.text, qe)
+			continue
+		}
+
+		if got := formatQueryNode(query.Root); got != test.want || query.Sort != test.sort {
+			t.Errorf("ParseQuery(%q) = %s sorted by %s, want %s sorted by %s", test.text, got, query.Sort, test.want, test.sort)
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []struct {
+		text       string
+		start, end int
+		message    string
+	}{
+		// Parentheses.
+		{"(a OR b", 0, 1, "This parenthesis is never closed."},
+		{"a (b (c)", 2, 3, "This parenthesis is never closed."},
+		{"a)", 1, 2, "This parenthesis was never opened."},
+		{"(a) b)", 5, 6, "This parenthesis was never opened."},
+		{"a ()", 2, 4, "These parentheses are empty."},
+
+		// Quoting.
+		{`"hello world`, 0, 12, "This quote is never closed."},
+		{`flair:"Help`, 6, 11, "This quote is never closed."},
+		{`a "" b`, 2, 4, "This phrase is empty."},
+
+		// Operators.
+		{"a OR", 4, 4, "The query ended before I expected it to."},
+		{"OR a", 0, 2, "I didn't expect `OR` here."},
+		{"a OR OR b", 5, 7, "I didn't expect `OR` here."},
+		{"AND a", 0, 3, "I didn't expect `AND` here."},
+		{"a AND AND b", 6, 9, "I didn't expect `AND` here."},
+		{"a AND", 5, 5, "The query ended before I expected it to."},
+		{"NOT NOT a", 4, 7, "I didn't expect `NOT` here."},
+		{"a NOT", 5, 5, "The query ended before I expected it to."},
+		{":a", 0, 1, "I didn't expect `:` here."},
+
+		// Fields.
+		{"flair:", 0, 6, "`flair:` needs a value."},
+		{"python topic:go", 7, 15, "I don't know the field `topic:`, try flair:, author:, domain:, before:, after:, in:, last:, score: or sort:."},
+		{"domain:self.python", 0, 18, "`self.python` isn't a domain like github.com."},
+		{"after:yesterday", 0, 15, "`yesterday` isn't a date like 2020-12-31, 2020-12 or 2020."},
+		{"last:forever", 0, 12, "`forever` isn't a period like `last:30d`, `last:2w`, `last:6m` or `last:1y`."},
+		{"score:~5", 0, 8, "`~5` isn't a comparison like `score:>100`."},
+		{"score:>", 0, 7, "`>` isn't a comparison like `score:>100`."},
+		{"a sort:old", 2, 10, "I can only sort by `best`, `new` or `top`."},
+		{"a sort:new sort:top", 11, 19, "The results can only be sorted once."},
+		{"a -sort:new", 3, 11, "`sort:` can't be negated or grouped."},
+		{"(a sort:new)", 3, 11, "`sort:` can't be negated or grouped."},
+		{"sort:new", 0, 8, "I need a search term, flair or author to find you anything."},
+		{"", 0, 0, "I need a search term, flair or author to find you anything."},
+	}
+
+	for _, test := range tests {
+		query, qe := ParseQuery(test.text)
+		if qe == nil {
+			t.Errorf("ParseQuery(%q) = %s, want an error", test.text, formatQueryNode(query.Root))
+			continue
+		}
+
+		if qe.Query != test.text || qe.Start != test.start || qe.End != test.end || qe.Message != test.message {
+			t.Errorf("ParseQuery(%q) errs %q at %d:%d, want %q at %d:%d", test.text, qe.Message, qe.Start, qe.End, test.message, test.start, test.end)
+		}
+	}
+}
+
+func TestQueryErrorMarkdown(t *testing.T) {
+	_, qe := ParseQuery("café (a OR b")
+	if qe == nil {
+		t.Fatal("ParseQuery didn't fail")
+	}
+
+	// The underline counts runes, so é is one column.
+	want := "This parenthesis is never closed.\n\n    café (a OR b\n         ^\n\n"
+	if got := qe.Markdown(); got != want {
+		t.Errorf("Markdown() = %q, want %q", got, want)
+	}
+
+	_, qe = ParseQuery("python topic:go")
+	want = "I don't know the field `topic:`, try flair:, author:, domain:, before:, after:, in:, last:, score: or sort:.\n\n    python topic:go\n           ^~~~~~~~\n\n"
+	if got := qe.Markdown(); got != want {
+		t.Errorf("Markdown() = %q, want %q", got, want)
+	}
+}
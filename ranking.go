@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// RankingDefaultHalfLife is how long it takes for the recency of a submission to halve when Ranking.half_life isn't set.
+const RankingDefaultHalfLife = 90 * 24 * time.Hour
+
+// RankingDefaultPageSize is the number of results in a page when Ranking.page_size isn't set.
+const RankingDefaultPageSize = 25
+
+// RankingConfig weighs how search results are ranked when sorted by QuerySortBest.
+// Each factor is scaled between 0 and 1 before being weighted. When every weight is 0 they're weighed equally.
+type RankingConfig struct {
+	Recency   float64       `toml:"recency"`
+	Upvotes   float64       `toml:"upvotes"`
+	Relevance float64       `toml:"relevance"`
+	HalfLife  time.Duration `toml:"half_life"`
+	PageSize  int           `toml:"page_size"`
+}
+
+// weights returns the weights of recency, upvotes and relevance.
+func (r RankingConfig) weights() (float64, float64, float64) {
+	if r.Recency <= 0 && r.Upvotes <= 0 && r.Relevance <= 0 {
+		return 1, 1, 1
+	}
+
+	return math.Max(r.Recency, 0), math.Max(r.Upvotes, 0), math.Max(r.Relevance, 0)
+}
+
+func (r RankingConfig) halfLife() time.Duration {
+	if r.HalfLife <= 0 {
+		return RankingDefaultHalfLife
+	}
+
+	return r.HalfLife
+}
+
+func (r RankingConfig) pageSize() int {
+	if r.PageSize <= 0 {
+		return RankingDefaultPageSize
+	}
+
+	return r.PageSize
+}
+
+// describe explains the weights, e.g. "best match: 50% relevance, 25% upvotes and 25% recency".
+func (r RankingConfig) describe() string {
+	recency, upvotes, relevance := r.weights()
+	total := recency + upvotes + relevance
+
+	percent := func(weight float64) int {
+		return int(math.Round(weight / total * 100))
+	}
+
+	return fmt.Sprintf("best match: %d%% relevance, %d%% upvotes and %d%% recency", percent(relevance), percent(upvotes), percent(recency))
+}
+
+// rank sorts the full IDs by their weighted score, highest first.
+// epochs are the creation epochs, and relevance is how well each submission matched, any positive scale.
+func (r RankingConfig) rank(fullIDs []string, epochs, upvotes, relevance map[string]float64) {
+	recencyWeight, upvotesWeight, relevanceWeight := r.weights()
+	halfLife := r.halfLife().Seconds()
+	now := float64(time.Now().Unix())
+
+	// Upvotes are compared logarithmically, otherwise a single viral submission would flatten every other.
+	var maxUpvotes, maxRelevance float64
+	for _, fullID := range fullIDs {
+		maxUpvotes = math.Max(maxUpvotes, math.Log1p(math.Max(upvotes[fullID], 0)))
+		maxRelevance = math.Max(maxRelevance, relevance[fullID])
+	}
+
+	scores := make(map[string]float64, len(fullIDs))
+	for _, fullID := range fullIDs {
+		age := math.Max(now-epochs[fullID], 0)
+		score := recencyWeight * math.Pow(0.5, age/halfLife)
+
+		if maxUpvotes > 0 {
+			score += upvotesWeight * math.Log1p(math.Max(upvotes[fullID], 0)) / maxUpvotes
+		}
+
+		if maxRelevance > 0 {
+			score += relevanceWeight * relevance[fullID] / maxRelevance
+		}
+
+		scores[fullID] = score
+	}
+
+	sort.Slice(fullIDs, func(i, j int) bool {
+		a, b := scores[fullIDs[i]], scores[fullIDs[j]]
+		if a == b {
+			return epochs[fullIDs[i]] > epochs[fullIDs[j]]
+		}
+
+		return a > b
+	})
+}
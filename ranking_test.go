@@ -0,0 +1,116 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRankingConfigDefaults(t *testing.T) {
+	tests := []struct {
+		config                      RankingConfig
+		recency, upvotes, relevance float64
+		description                 string
+	}{
+		{RankingConfig{}, 1, 1, 1, "best match: 33% relevance, 33% upvotes and 33% recency"},
+		{RankingConfig{Recency: 1, Upvotes: 1, Relevance: 2}, 1, 1, 2, "best match: 50% relevance, 25% upvotes and 25% recency"},
+		{RankingConfig{Recency: -1, Upvotes: 3}, 0, 3, 0, "best match: 0% relevance, 100% upvotes and 0% recency"},
+		{RankingConfig{Recency: -1, Upvotes: -1, Relevance: -1}, 1, 1, 1, "best match: 33% relevance, 33% upvotes and 33% recency"},
+	}
+
+	for _, test := range tests {
+		recency, upvotes, relevance := test.config.weights()
+		if recency != test.recency || upvotes != test.upvotes || relevance != test.relevance {
+			t.Errorf("%+v weights = %g, %g, %g, want %g, %g, %g", test.config, recency, upvotes, relevance, test.recency, test.upvotes, test.relevance)
+		}
+
+		if description := test.config.describe(); description != test.description {
+			t.Errorf("%+v describe() = %q, want %q", test.config, description, test.description)
+		}
+	}
+
+	if halfLife := (RankingConfig{}).halfLife(); halfLife != RankingDefaultHalfLife {
+		t.Errorf("default halfLife() = %v, want %v", halfLife, RankingDefaultHalfLife)
+	}
+
+	if pageSize := (RankingConfig{PageSize: -5}).pageSize(); pageSize != RankingDefaultPageSize {
+		t.Errorf("pageSize() of -5 = %d, want %d", pageSize, RankingDefaultPageSize)
+	}
+}
+
+func TestRank(t *testing.T) {
+	now := float64(time.Now().Unix())
+	day := float64(24 * 60 * 60)
+
+	tests := []struct {
+		name      string
+		config    RankingConfig
+		epochs    map[string]float64
+		upvotes   map[string]float64
+		relevance map[string]float64
+		want      []string
+	}{
+		{
+			name:   "recency",
+			config: RankingConfig{Recency: 1},
+			epochs: map[string]float64{"old": now - 300*day, "new": now - day, "middle": now - 30*day},
+			want:   []string{"new", "middle", "old"},
+		},
+		{
+			name:    "upvotes with ties broken by the newest",
+			config:  RankingConfig{Upvotes: 1},
+			epochs:  map[string]float64{"a": now - 2*day, "b": now - day, "c": now - 3*day, "d": now},
+			upvotes: map[string]float64{"a": 10, "b": 10, "c": 500, "d": -5},
+			want:    []string{"c", "b", "a", "d"},
+		},
+		{
+			name:      "relevance",
+			config:    RankingConfig{Relevance: 1},
+			epochs:    map[string]float64{"a": now, "b": now, "c": now},
+			relevance: map[string]float64{"a": 1, "b": 3, "c": 2},
+			want:      []string{"b", "c", "a"},
+		},
+		{
+			// Linearly a would score 1 + 0.5 and b 0.1 + 1, but logarithmically b's upvotes are two thirds of a's.
+			name:      "upvotes are logarithmic",
+			config:    RankingConfig{Upvotes: 1, Relevance: 1},
+			epochs:    map[string]float64{"a": now, "b": now},
+			upvotes:   map[string]float64{"a": 1000, "b": 100},
+			relevance: map[string]float64{"a": 1, "b": 2},
+			want:      []string{"b", "a"},
+		},
+		{
+			// b's recency has halved, which its upvotes make up for with a long half-life but not a short one.
+			name:    "long half-life",
+			config:  RankingConfig{Recency: 1, Upvotes: 1, HalfLife: 10 * 24 * time.Hour},
+			epochs:  map[string]float64{"a": now, "b": now - 10*day},
+			upvotes: map[string]float64{"a": 1, "b": 7},
+			want:    []string{"b", "a"},
+		},
+		{
+			name:    "short half-life",
+			config:  RankingConfig{Recency: 1, Upvotes: 1, HalfLife: time.Hour},
+			epochs:  map[string]float64{"a": now, "b": now - 10*day},
+			upvotes: map[string]float64{"a": 1, "b": 7},
+			want:    []string{"a", "b"},
+		},
+		{
+			name:   "future epochs are new",
+			config: RankingConfig{Recency: 1},
+			epochs: map[string]float64{"a": now + day, "b": now - day},
+			want:   []string{"a", "b"},
+		},
+	}
+
+	for _, test := range tests {
+		fullIDs := make([]string, 0, len(test.epochs))
+		for fullID := range test.epochs {
+			fullIDs = append(fullIDs, fullID)
+		}
+
+		test.config.rank(fullIDs, test.epochs, test.upvotes, test.relevance)
+		if !reflect.DeepEqual(fullIDs, test.want) {
+			t.Errorf("%s: rank = %q, want %q", test.name, fullIDs, test.want)
+		}
+	}
+}
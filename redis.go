@@ -2,7 +2,15 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/vartanbeno/go-reddit/reddit"
@@ -19,14 +27,6 @@ const RedisSearchStart = "searchStart"
 // RedisSearchEnd is the end anchor key.
 const RedisSearchEnd = "searchEnd"
 
-// Inbox Anchors
-
-// RedisInboxCurrent is the currently held listing item for iteration.
-const RedisInboxCurrent = "inboxCurrent"
-
-// RedisInboxStart is the first known listing item.
-const RedisInboxStart = "inboxStart"
-
 // Pushshift Anchors
 
 // RedisPushshiftStart is the epoch of the first scanned Pushshift data.
@@ -50,19 +50,31 @@ const RedisSearchPrefix = "search" + RedisDelimiter
 // RedisUpvotes is the key for submission upvotes.
 const RedisUpvotes = "upvotes"
 
-// RedisFlairNames is a set of existing flairs
+// RedisFlairNames is a set of existing flairs, named as shown after renames.
 const RedisFlairNames = "flairNames"
 
-// RedisFlairsPrefix is the prefix for a flair corresponding to a set of submission IDs sorted by date created.
+// RedisFlairsPrefix is the prefix for a flair key, see Client.flairKey, corresponding to a set of submission IDs sorted by date created.
 const RedisFlairsPrefix = "flairs" + RedisDelimiter
 
-// RedisAllSubmissions is a hash with keys of submission IDs to their JSON data.
+// RedisUnflaired is a set of the submission IDs without a flair sorted by date created.
+const RedisUnflaired = "unflaired"
+
+// RedisAuthorsPrefix is the prefix for a lowercased author corresponding to a set of submission IDs sorted by date created.
+const RedisAuthorsPrefix = "authors" + RedisDelimiter
+
+// RedisDomainsPrefix is the prefix for a linked domain, see PushshiftSubmission.domains, corresponding to a set of submission IDs sorted by date created.
+const RedisDomainsPrefix = "domains" + RedisDelimiter
+
+// RedisStatsPrefix is the prefix for a statistics scope, see ArchiveStats, corresponding to a hash of its fields.
+const RedisStatsPrefix = "stats" + RedisDelimiter
+
+// RedisAllSubmissions is a hash with keys of submission IDs to their compacted data, see PushshiftSubmission.MarshalBinary.
 const RedisAllSubmissions = "allSubmissions"
 
-// RedisSubmissions is a hash with keys of not deleted submission IDs to their JSON data.
+// RedisSubmissions is a set of not deleted submission IDs.
 const RedisSubmissions = "submissions"
 
-// RedisRemovedSubmissions is a hash with keys of deleted submission IDs to their JSON data.
+// RedisRemovedSubmissions is a set of deleted submission IDs.
 const RedisRemovedSubmissions = "removedSubmissions"
 
 // RedisLinks is a hash with keys of process full names to a link formatted as [title](permalink).
@@ -71,96 +83,457 @@ const RedisLinks = "links"
 // RedisProcessed is a set of processed full names.
 const RedisProcessed = "processed"
 
+// RedisReplyCommands is a hash of the full names of the bot's replies to the command line they answered, for follow ups.
+const RedisReplyCommands = "replyCommands"
+
+// RedisSubscriptions is a hash of subscription keys, see Subscription.key, to the subscription as JSON.
+const RedisSubscriptions = "subscriptions"
+
+// RedisReplies is a hash of message full names to the full name of the bot's reply, or empty while the reply is being sent.
+const RedisReplies = "replies"
+
+// RedisStatsCounts is a hash of submission full IDs to the StatsCount they're counted as in the statistics.
+const RedisStatsCounts = "statsCounts"
+
+// RedisStatsCountsBatch is the most counts written to RedisStatsCounts by a command when replacing them.
+const RedisStatsCountsBatch = 500
+
+// RedisSchema is the key of the RedisSchemaVersion the archive was last written with.
+const RedisSchema = "schemaVersion"
+
+// RedisSchemaVersion is the version of how the archive is stored, increased when archives written by older versions need converting.
+// Version 1 stores RedisSubmissions and RedisRemovedSubmissions as sets rather than hashes.
+const RedisSchemaVersion = 1
+
+// RedisWatchRetries is how many times a transaction is retried when a key it watches changes before it commits.
+const RedisWatchRetries = 10
+
+// RedisTopTemporary is a sorted set only existing while getTopUpvoted ranks submissions, so it's left out of RedisKeys.
+// getTopUpvoted also uses it followed by RedisDelimiter and ranked.
+const RedisTopTemporary = "topTemporary"
+
+// RedisKeys is every fixed key used by ArchiveBot.
+var RedisKeys = []string{
+	RedisSearchCurrent,
+	RedisSearchStart,
+	RedisSearchEnd,
+	RedisPushshiftStart,
+	RedisPushshiftEnd,
+	RedisPushshiftTraversed,
+	RedisSearchIsForwards,
+	RedisUpvotes,
+	RedisFlairNames,
+	RedisUnflaired,
+	RedisAllSubmissions,
+	RedisSubmissions,
+	RedisRemovedSubmissions,
+	RedisLinks,
+	RedisProcessed,
+	RedisReplies,
+	RedisReplyCommands,
+	RedisSubscriptions,
+	RedisStatsCounts,
+	RedisSchema,
+}
+
+// RedisUntaggedKeys are the fixed keys never used in one command or transaction with another key,
+// so they aren't prefixed with the hash tag and a cluster can keep them on any node, see Redis.key.
+var RedisUntaggedKeys = map[string]bool{
+	RedisSearchCurrent:      true,
+	RedisSearchStart:        true,
+	RedisSearchEnd:          true,
+	RedisPushshiftStart:     true,
+	RedisPushshiftEnd:       true,
+	RedisPushshiftTraversed: true,
+	RedisSearchIsForwards:   true,
+	RedisProcessed:          true,
+	RedisReplies:            true,
+	RedisReplyCommands:      true,
+	RedisSubscriptions:      true,
+	RedisSchema:             true,
+}
+
+// RedisKeyPrefixes is every prefix of the keys created by ArchiveBot.
+var RedisKeyPrefixes = []string{
+	RedisSearchPrefix,
+	RedisFlairsPrefix,
+	RedisAuthorsPrefix,
+	RedisDomainsPrefix,
+	RedisStatsPrefix,
+}
+
+// RedisDefaultClusterHashTag is the hash tag used when connecting to a cluster without one configured.
+const RedisDefaultClusterHashTag = "{ArchiveBot}"
+
+// ErrRedisTLSCertificate is returned when the TLS CA file has no certificates.
+var ErrRedisTLSCertificate = errors.New("no certificates found")
+
 // Redis is a client of redis information
 type Redis struct {
-	*redis.Client
-	client *Client
-	config *Config
+	redis.UniversalClient
+	client  *Client
+	config  *Config
+	hashTag string // Prefixed to the keys used together, see Redis.key.
 }
 
 var ctx = context.Background()
 
 // NewRedisClient creates a new Redis client.
+// A single node, Sentinel or cluster client is created depending on the config.
 func NewRedisClient(client *Client, config *Config) (*Redis, error) {
 	redisConfig := config.Redis
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     redisConfig.Addr,
-		Password: redisConfig.Password,
-		DB:       redisConfig.DB,
-	})
+
+	addrs := redisConfig.Addrs
+	if len(addrs) == 0 {
+		addrs = []string{redisConfig.Addr}
+	}
+
+	tlsConfig, err := redisConfig.TLS.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	options := &redis.UniversalOptions{
+		Addrs:      addrs,
+		DB:         redisConfig.DB,
+		Username:   redisConfig.Username,
+		Password:   redisConfig.Password,
+		TLSConfig:  tlsConfig,
+		MasterName: redisConfig.MasterName,
+	}
+
+	hashTag := redisConfig.HashTag
+
+	var rdb redis.UniversalClient
+	if redisConfig.Cluster {
+		rdb = redis.NewClusterClient(options.Cluster())
+		if hashTag == "" {
+			hashTag = RedisDefaultClusterHashTag
+		}
+	} else if redisConfig.MasterName != "" {
+		failover := options.Failover()
+		failover.SentinelUsername = redisConfig.SentinelUsername
+		failover.SentinelPassword = redisConfig.SentinelPassword
+		rdb = redis.NewFailoverClient(failover)
+	} else {
+		rdb = redis.NewClient(options.Simple())
+	}
 
 	if err := rdb.Ping(ctx).Err(); err != nil {
+		rdb.Close()
 		return nil, err
 	}
 
-	return &Redis{rdb, client, config}, nil
+	r := &Redis{rdb, client, config, hashTag}
+	if ce := r.upgradeSchema(); ce != nil {
+		rdb.Close()
+		return nil, ce
+	}
+
+	return r, nil
+}
+
+// tlsConfig creates the TLS config, or nil if TLS isn't enabled.
+func (t RedisTLSConfig) tlsConfig() (*tls.Config, error) {
+	if !t.Enabled {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if t.CAFile != "" {
+		ca, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read Redis TLS CA file: %w", err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("%w in %s", ErrRedisTLSCertificate, t.CAFile)
+		}
+	}
+
+	if t.CertFile != "" || t.KeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load Redis TLS client certificate: %w", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
 }
 
+// key returns the name of a key in Redis.
+// When connected to a cluster the keys used together in transactions, which are the archived submissions with their indexes and statistics,
+// start with the same hash tag so that they're in the same hash slot. The archive is then kept in one slot on one node:
+// a cluster gives failover, but doesn't spread the archive across its nodes. RedisUntaggedKeys are left as they are.
+func (r *Redis) key(name string) string {
+	if RedisUntaggedKeys[name] {
+		return name
+	}
+
+	return r.hashTag + name
+}
+
+// addSubmissions archives the submissions and counts them in the statistics, uncounting what those archived before were counted as.
+// The counts are read, and the submissions, counts and statistics written, in one transaction, so that the statistics always match the archive
+// and concurrent passes can't uncount a submission twice.
 func (r *Redis) addSubmissions(pushshiftSubmissions []PushshiftSubmission) error {
+	if len(pushshiftSubmissions) == 0 {
+		return nil
+	}
+
 	var submissions []interface{}
 	var links []interface{}
+	var flairNames []interface{}
 	var upvotes []*redis.Z
 
 	flairs := make(map[string][]*redis.Z)
+	authors := make(map[string][]*redis.Z)
+	domains := make(map[string][]*redis.Z)
 	searches := make(map[string][]*redis.Z)
 
-	for _, submission := range pushshiftSubmissions {
+	storedFields := r.config.Storage.storedFields()
+	fullIDs := make([]string, len(pushshiftSubmissions))
+	for i, submission := range pushshiftSubmissions {
 		fullID := "t3_" + submission.ID
-		submissions = append(submissions, fullID, submission)
+		fullIDs[i] = fullID
+		submissions = append(submissions, fullID, submission.Compact(storedFields))
 
-		link := fmt.Sprintf("[%s](%s)", submission.Title, submission.Permalink)
-		links = append(links, fullID, link)
+		links = append(links, fullID, markdownLink(submission.Title, submission.Permalink))
 
 		upvotes = append(upvotes, &redis.Z{Member: fullID, Score: float64(submission.Ups)})
 
-		flairs[submission.LinkFlairText] = append(flairs[submission.LinkFlairText], &redis.Z{Member: fullID, Score: submission.DateCreated})
+		flairKey := r.flairKey(r.client.flairKey(submission.LinkFlairText))
+		flairs[flairKey] = append(flairs[flairKey], &redis.Z{Member: fullID, Score: submission.DateCreated})
+		if submission.LinkFlairText != "" {
+			flairNames = append(flairNames, r.client.flairName(submission.LinkFlairText))
+		}
 
-		matches := r.client.Search.getTitleMatches(submission.Title)
+		submission.addIndexes(authors, domains)
+
+		matches := r.client.Search.getSubmissionMatches(submission)
 		for _, match := range matches {
 			searches[match] = append(searches[match], &redis.Z{Score: submission.DateCreated, Member: fullID})
 		}
 	}
 
-	if err := r.HSet(ctx, RedisAllSubmissions, submissions...).Err(); err != nil {
-		return fmt.Errorf("could not set submissions: %w", err)
+	err := r.watch(func(tx *redis.Tx) error {
+		removed, ce := r.areRemoved(fullIDs)
+		if ce != nil {
+			return ce
+		}
+
+		stats, counts, ce := r.countSubmissions(fullIDs, pushshiftSubmissions, removed)
+		if ce != nil {
+			return ce
+		}
+
+		// Submissions are unremoved until found removed, so they're ranked and shown before their removal is checked.
+		var unremoved []interface{}
+		for i, fullID := range fullIDs {
+			if !removed[i] {
+				unremoved = append(unremoved, fullID)
+			}
+		}
+
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, r.key(RedisAllSubmissions), submissions...)
+			pipe.HSet(ctx, r.key(RedisLinks), links...)
+			pipe.ZAdd(ctx, r.key(RedisUpvotes), upvotes...)
+			if len(unremoved) != 0 {
+				pipe.SAdd(ctx, r.key(RedisSubmissions), unremoved...)
+			}
+
+			for flairKey, members := range flairs {
+				pipe.ZAdd(ctx, r.key(flairKey), members...)
+			}
+
+			if len(flairNames) != 0 {
+				pipe.SAdd(ctx, r.key(RedisFlairNames), flairNames...)
+			}
+
+			r.addIndex(pipe, RedisAuthorsPrefix, authors)
+			r.addIndex(pipe, RedisDomainsPrefix, domains)
+			for searchName, search := range searches {
+				pipe.ZAdd(ctx, r.key(RedisSearchPrefix+searchName), search...)
+			}
+
+			pipe.HSet(ctx, r.key(RedisStatsCounts), counts...)
+			r.incrStats(pipe, stats)
+			pipe.HSet(ctx, r.key(RedisStatsPrefix+StatsScopeAll), StatsUpdated, time.Now().Unix())
+			return nil
+		})
+		return err
+	}, RedisStatsCounts, RedisRemovedSubmissions, RedisAllSubmissions)
+	if err != nil {
+		return fmt.Errorf("could not archive submissions: %w", err)
+	}
+
+	return nil
+}
+
+// countSubmissions returns the changes to the statistics archiving the submissions makes, and what each is counted as, as the values of RedisStatsCounts.
+// Submissions archived before counts were stored are uncounted as archived.
+func (r *Redis) countSubmissions(fullIDs []string, submissions []PushshiftSubmission, removed []bool) (ArchiveStats, []interface{}, *ContextError) {
+	counts, ce := r.getStatsCounts(fullIDs)
+	if ce != nil {
+		return nil, nil, ce
 	}
 
-	if err := r.HSet(ctx, RedisLinks, links...).Err(); err != nil {
-		return fmt.Errorf("could not add submission title: %w", err)
+	var uncounted []string
+	for _, fullID := range fullIDs {
+		if _, ok := counts[fullID]; !ok {
+			uncounted = append(uncounted, fullID)
+		}
 	}
 
-	if err := r.ZAdd(ctx, RedisUpvotes, upvotes...).Err(); err != nil {
-		return fmt.Errorf("could not add submission upvotes: %w", err)
+	archived, ce := r.getSubmissions(uncounted)
+	if ce != nil {
+		return nil, nil, ce
 	}
 
-	var flairNames []interface{}
-	flairsSet := make(map[string]struct{})
-	for flairName, members := range flairs {
-		if err := r.ZAdd(ctx, RedisFlairsPrefix+flairName, members...).Err(); err != nil {
-			return fmt.Errorf("could not add flairs for %s: %w", flairName, err)
+	stats := make(ArchiveStats)
+	values := make([]interface{}, 0, 2*len(submissions))
+	for i, submission := range submissions {
+		fullID := fullIDs[i]
+		var previous *StatsCount
+		if count, ok := counts[fullID]; ok {
+			previous = &count
+		} else if archivedSubmission, ok := archived[fullID]; ok {
+			count := r.client.statsCount(archivedSubmission)
+			previous = &count
+		}
+
+		count := r.client.statsCount(submission)
+		stats.recount(previous, count, removed[i])
+		values = append(values, fullID, count)
+	}
+
+	return stats, values, nil
+}
+
+// watch runs the transaction watching the keys, retrying it when one changed before it committed.
+func (r *Redis) watch(f func(tx *redis.Tx) error, keys ...string) error {
+	watched := make([]string, len(keys))
+	for i, key := range keys {
+		watched[i] = r.key(key)
+	}
+
+	for i := 0; i < RedisWatchRetries; i++ {
+		if err := r.Watch(ctx, f, watched...); err != redis.TxFailedErr {
+			return err
+		}
+	}
+
+	return redis.TxFailedErr
+}
+
+// getStatsCounts returns a map of full IDs to what they're counted as in the statistics, skipping those without a stored count.
+func (r *Redis) getStatsCounts(fullIDs []string) (map[string]StatsCount, *ContextError) {
+	counts := make(map[string]StatsCount, len(fullIDs))
+	if len(fullIDs) == 0 {
+		return counts, nil
+	}
+
+	values, err := r.HMGet(ctx, r.key(RedisStatsCounts), fullIDs...).Result()
+	if err != nil {
+		return nil, NewContextError(err, []ContextParam{
+			{"Redis Key", RedisStatsCounts},
+			{"IDs", fmt.Sprint(fullIDs)},
+		})
+	}
+
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
 		}
 
-		if _, ok := flairsSet[flairName]; !ok {
-			flairsSet[flairName] = struct{}{}
-			flairNames = append(flairNames, flairName)
+		var count StatsCount
+		if err := count.UnmarshalBinary([]byte(data)); err != nil {
+			return nil, NewContextError(err, []ContextParam{
+				{"ID", fullIDs[i]},
+			})
 		}
+
+		counts[fullIDs[i]] = count
 	}
 
-	if err := r.SAdd(ctx, RedisFlairNames, flairNames...).Err(); err != nil {
-		return fmt.Errorf("could not add Redis flair names: %w", err)
+	return counts, nil
+}
+
+// areRemoved returns whether each submission is in RedisRemovedSubmissions.
+func (r *Redis) areRemoved(fullIDs []string) ([]bool, *ContextError) {
+	pipe := r.Pipeline()
+	cmds := make([]*redis.BoolCmd, len(fullIDs))
+	for i, fullID := range fullIDs {
+		cmds[i] = pipe.SIsMember(ctx, r.key(RedisRemovedSubmissions), fullID)
 	}
 
-	for searchName, search := range searches {
-		if err := r.ZAdd(ctx, RedisSearchPrefix+searchName, search...).Err(); err != nil {
-			return fmt.Errorf("could not add search term %s: %w", searchName, err)
+	if len(fullIDs) != 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, NewWrappedError("could not read removed submissions", err, nil)
 		}
 	}
 
-	return nil
+	removed := make([]bool, len(fullIDs))
+	for i, cmd := range cmds {
+		removed[i] = cmd.Val()
+	}
+
+	return removed, nil
+}
+
+// areArchived returns whether each submission is in RedisAllSubmissions.
+func (r *Redis) areArchived(fullIDs []string) ([]bool, *ContextError) {
+	pipe := r.Pipeline()
+	cmds := make([]*redis.BoolCmd, len(fullIDs))
+	for i, fullID := range fullIDs {
+		cmds[i] = pipe.HExists(ctx, r.key(RedisAllSubmissions), fullID)
+	}
+
+	if len(fullIDs) != 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, NewWrappedError("could not read archived submissions", err, nil)
+		}
+	}
+
+	archived := make([]bool, len(fullIDs))
+	for i, cmd := range cmds {
+		archived[i] = cmd.Val()
+	}
+
+	return archived, nil
+}
+
+// addIndexes adds the submission to the sorted sets of its author and domains.
+func (s PushshiftSubmission) addIndexes(authors, domains map[string][]*redis.Z) {
+	member := &redis.Z{Member: "t3_" + s.ID, Score: s.DateCreated}
+	if author := s.author(); author != "" {
+		authors[author] = append(authors[author], member)
+	}
+
+	for _, domain := range s.domains() {
+		domains[domain] = append(domains[domain], member)
+	}
+}
+
+// addIndex queues adding submissions to sorted sets of the prefix, such as the sets of their authors.
+func (r *Redis) addIndex(pipe redis.Pipeliner, prefix string, sets map[string][]*redis.Z) {
+	for name, members := range sets {
+		pipe.ZAdd(ctx, r.key(prefix+name), members...)
+	}
 }
 
 func (r *Redis) getHashMap(key string) (map[string]string, *ContextError) {
-	submissions, err := r.HGetAll(ctx, key).Result()
+	submissions, err := r.HGetAll(ctx, r.key(key)).Result()
 
 	if err != nil {
 		return nil, NewWrappedError(fmt.Sprintf("error in reading %s", key), err, nil)
@@ -172,10 +545,10 @@ func (r *Redis) getHashMap(key string) (map[string]string, *ContextError) {
 func (r *Redis) getSetMap(prefix string) (map[string][]redis.Z, *ContextError) {
 	items := make(map[string][]redis.Z)
 
-	iter := r.Scan(ctx, 0, prefix+"*", 0).Iterator()
+	iter := r.Scan(ctx, 0, r.key(prefix)+"*", 0).Iterator()
 
 	for iter.Next(ctx) {
-		val := iter.Val()
+		val := strings.TrimPrefix(iter.Val(), r.hashTag)
 		scores, ce := r.getZSet(val)
 
 		if ce != nil {
@@ -201,7 +574,7 @@ func (r *Redis) getZSet(name string) ([]redis.Z, *ContextError) {
 }
 
 func (r *Redis) getZSetRange(name string, rangeBy *redis.ZRangeBy) ([]redis.Z, *ContextError) {
-	result, err := r.ZRangeByScoreWithScores(ctx, name, rangeBy).Result()
+	result, err := r.ZRangeByScoreWithScores(ctx, r.key(name), rangeBy).Result()
 
 	if err != nil {
 		return nil, NewContextError(err, []ContextParam{
@@ -216,11 +589,11 @@ func (r *Redis) updateVotes(submissions []PushshiftSubmission) *ContextError {
 	i := 0
 	updates := make([]*redis.Z, len(submissions))
 	for _, submission := range submissions {
-		updates[i] = &redis.Z{Score: float64(submission.Ups), Member: submission.ID}
+		updates[i] = &redis.Z{Score: float64(submission.Ups), Member: "t3_" + submission.ID}
 		i++
 	}
 
-	if err := r.ZAdd(ctx, RedisUpvotes, updates...).Err(); err != nil {
+	if err := r.ZAdd(ctx, r.key(RedisUpvotes), updates...).Err(); err != nil {
 		return NewWrappedError("could not update submission upvotes", err, []ContextParam{
 			{"updates", fmt.Sprint(updates)},
 		})
@@ -230,26 +603,665 @@ func (r *Redis) updateVotes(submissions []PushshiftSubmission) *ContextError {
 }
 
 func (r *Redis) setRemoved(submissions []PushshiftSubmission, removedMap map[string]bool) *ContextError {
-	removed := make([]interface{}, 0, len(submissions)*2)
-	unremoved := make([]interface{}, 0, len(submissions)*2)
-	for _, submission := range submissions {
-		isRemoved := removedMap[submission.ID]
-		if isRemoved {
-			removed = append(removed, submission.ID, submission)
+	if len(submissions) == 0 {
+		return nil
+	}
+
+	removed := make([]interface{}, 0, len(submissions))
+	unremoved := make([]interface{}, 0, len(submissions))
+	fullIDs := make([]string, len(submissions))
+	for i, submission := range submissions {
+		fullIDs[i] = "t3_" + submission.ID
+		if removedMap[submission.ID] {
+			removed = append(removed, fullIDs[i])
 		} else {
-			unremoved = append(unremoved, submission.ID, submission)
+			unremoved = append(unremoved, fullIDs[i])
+		}
+	}
+
+	// A submission is only ever in one of the sets.
+	// Whether it moved in or out of RedisRemovedSubmissions is counted in the scopes of its StatsCount, in the same transaction.
+	err := r.watch(func(tx *redis.Tx) error {
+		wasRemoved, ce := r.areRemoved(fullIDs)
+		if ce != nil {
+			return ce
+		}
+
+		counts, ce := r.getStatsCounts(fullIDs)
+		if ce != nil {
+			return ce
+		}
+
+		archived, ce := r.areArchived(fullIDs)
+		if ce != nil {
+			return ce
+		}
+
+		// Submissions which aren't archived yet aren't counted, and are counted as removed when they're archived, see ArchiveStats.recount.
+		stats := make(ArchiveStats)
+		for i, submission := range submissions {
+			isRemoved := removedMap[submission.ID]
+			if isRemoved == wasRemoved[i] || !archived[i] {
+				continue
+			}
+
+			count, ok := counts[fullIDs[i]]
+			if !ok {
+				count = r.client.statsCount(submission)
+			}
+
+			sign := 1.0
+			if !isRemoved {
+				sign = -1
+			}
+			stats.addRemoval(count.FlairKey, count.Searches, sign)
+		}
+
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if len(removed) != 0 {
+				pipe.SAdd(ctx, r.key(RedisRemovedSubmissions), removed...)
+				pipe.SRem(ctx, r.key(RedisSubmissions), removed...)
+			}
+
+			if len(unremoved) != 0 {
+				pipe.SRem(ctx, r.key(RedisRemovedSubmissions), unremoved...)
+				pipe.SAdd(ctx, r.key(RedisSubmissions), unremoved...)
+			}
+
+			r.incrStats(pipe, stats)
+			return nil
+		})
+		return err
+	}, RedisRemovedSubmissions, RedisStatsCounts, RedisAllSubmissions)
+	if err != nil {
+		return NewWrappedError("could not update "+RedisSubmissions+" and "+RedisRemovedSubmissions, err, []ContextParam{
+			{"Removed", fmt.Sprint(removed)},
+			{"Unremoved", fmt.Sprint(unremoved)},
+		})
+	}
+
+	return nil
+}
+
+// incrStats queues adding the changes to the statistics of each scope.
+func (r *Redis) incrStats(pipe redis.Pipeliner, stats ArchiveStats) {
+	for scope, fields := range stats {
+		for field, value := range fields {
+			if value != 0 {
+				pipe.HIncrByFloat(ctx, r.key(RedisStatsPrefix+scope), field, value)
+			}
 		}
 	}
+}
 
-	if err := r.HSet(ctx, RedisSubmissions, unremoved).Err(); err != nil {
-		return NewWrappedError("could not update " + RedisSubmissions, err, []ContextParam{
-			{"updates", fmt.Sprint(unremoved)},
+func (r *Redis) getStats(scope string) (map[string]float64, *ContextError) {
+	values, err := r.HGetAll(ctx, r.key(RedisStatsPrefix+scope)).Result()
+	if err != nil {
+		return nil, NewContextError(err, []ContextParam{
+			{"Redis Key", RedisStatsPrefix + scope},
 		})
 	}
 
-	if err := r.HSet(ctx, RedisRemovedSubmissions, removed).Err(); err != nil {
-		return NewWrappedError("could not update " + RedisRemovedSubmissions, err, []ContextParam{
-			{"updates", fmt.Sprint(removed)},
+	return parseStats(values)
+}
+
+// parseStats parses the fields of a statistics hash.
+func parseStats(values map[string]string) (map[string]float64, *ContextError) {
+	stats := make(map[string]float64, len(values))
+	for field, value := range values {
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, NewWrappedError("invalid statistic", err, []ContextParam{
+				{"Field", field},
+				{"Value", value},
+			})
+		}
+
+		stats[field] = number
+	}
+
+	return stats, nil
+}
+
+// replaceStats deletes every statistics hash and RedisStatsCounts before writing the new ones.
+func (r *Redis) replaceStats(stats ArchiveStats, counts map[string]StatsCount) *ContextError {
+	keys := []string{r.key(RedisStatsCounts)}
+	ce := r.scanKeys(RedisStatsPrefix, func(key string) error {
+		keys = append(keys, r.key(key))
+		return nil
+	})
+	if ce != nil {
+		return ce
+	}
+
+	pipe := r.TxPipeline()
+	pipe.Del(ctx, keys...)
+
+	values := make([]interface{}, 0, 2*RedisStatsCountsBatch)
+	for fullID, count := range counts {
+		values = append(values, fullID, count)
+		if len(values) == 2*RedisStatsCountsBatch {
+			pipe.HSet(ctx, r.key(RedisStatsCounts), values...)
+			values = make([]interface{}, 0, 2*RedisStatsCountsBatch)
+		}
+	}
+
+	if len(values) != 0 {
+		pipe.HSet(ctx, r.key(RedisStatsCounts), values...)
+	}
+
+	for scope, fields := range stats {
+		values := make([]interface{}, 0, 2*len(fields))
+		for field, value := range fields {
+			values = append(values, field, value)
+		}
+
+		if len(values) != 0 {
+			pipe.HSet(ctx, r.key(RedisStatsPrefix+scope), values...)
+		}
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return NewWrappedError("could not replace statistics", err, nil)
+	}
+
+	return nil
+}
+
+func (r *Redis) getSubmissionIDs() ([]string, *ContextError) {
+	ids, err := r.HKeys(ctx, r.key(RedisAllSubmissions)).Result()
+	if err != nil {
+		return nil, NewWrappedError(fmt.Sprintf("error in reading %s", RedisAllSubmissions), err, nil)
+	}
+
+	return ids, nil
+}
+
+func (r *Redis) getSearch(search string, window TimeRange) ([]redis.Z, *ContextError) {
+	return r.getZSetRange(RedisSearchPrefix+search, window.zRangeBy())
+}
+
+func (r *Redis) getFlair(flairKey string, window TimeRange) ([]redis.Z, *ContextError) {
+	return r.getZSetRange(r.flairKey(flairKey), window.zRangeBy())
+}
+
+// flairKey returns the Redis key of a flair key.
+func (r *Redis) flairKey(flairKey string) string {
+	if flairKey == "" {
+		return RedisUnflaired
+	}
+
+	return RedisFlairsPrefix + flairKey
+}
+
+// mergeFlairs moves every flair set to the key of its flair, which merges renamed flairs and
+// moves the sets of older versions, keyed by the exact flair, to normalized keys and RedisUnflaired.
+func (r *Redis) mergeFlairs() *ContextError {
+	var keys []string
+	ce := r.scanKeys(RedisFlairsPrefix, func(key string) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if ce != nil {
+		return ce
+	}
+
+	merged := make(map[string]string)
+	for _, key := range keys {
+		target := r.flairKey(r.client.flairKey(strings.TrimPrefix(key, RedisFlairsPrefix)))
+		if target == key {
+			continue
+		}
+
+		// Every flair key shares the hash tag, so the sets can be merged in one slot.
+		pipe := r.TxPipeline()
+		pipe.ZUnionStore(ctx, r.key(target), &redis.ZStore{Keys: []string{r.key(target), r.key(key)}, Aggregate: "MIN"})
+		pipe.Del(ctx, r.key(key))
+		if _, err := pipe.Exec(ctx); err != nil {
+			return NewContextError(err, []ContextParam{
+				{"From", key},
+				{"To", target},
+			})
+		}
+
+		merged[key] = target
+	}
+
+	names, err := r.SMembers(ctx, r.key(RedisFlairNames)).Result()
+	if err != nil {
+		return NewWrappedError(fmt.Sprintf("error in reading %s", RedisFlairNames), err, nil)
+	}
+
+	var renamed []interface{}
+	for _, name := range names {
+		if name != "" {
+			renamed = append(renamed, r.client.flairName(name))
+		}
+	}
+
+	pipe := r.TxPipeline()
+	pipe.Del(ctx, r.key(RedisFlairNames))
+	if len(renamed) != 0 {
+		pipe.SAdd(ctx, r.key(RedisFlairNames), renamed...)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return NewContextError(err, []ContextParam{
+			{"Redis Key", RedisFlairNames},
+		})
+	}
+
+	r.client.logMergedFlairs(merged)
+	return nil
+}
+
+func (r *Redis) getFlairNames() ([]string, *ContextError) {
+	names, err := r.SMembers(ctx, r.key(RedisFlairNames)).Result()
+	if err != nil {
+		return nil, NewWrappedError(fmt.Sprintf("error in reading %s", RedisFlairNames), err, nil)
+	}
+
+	return names, nil
+}
+
+// getFlairStats reads the size and the first and last submission of every flair set, named from RedisFlairNames.
+func (r *Redis) getFlairStats() ([]FlairStats, *ContextError) {
+	keys := []string{RedisUnflaired}
+	ce := r.scanKeys(RedisFlairsPrefix, func(key string) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if ce != nil {
+		return nil, ce
+	}
+
+	names, ce := r.getFlairNames()
+	if ce != nil {
+		return nil, ce
+	}
+
+	pipe := r.Pipeline()
+	counts := make([]*redis.IntCmd, len(keys))
+	firsts := make([]*redis.ZSliceCmd, len(keys))
+	lasts := make([]*redis.ZSliceCmd, len(keys))
+	for i, key := range keys {
+		counts[i] = pipe.ZCard(ctx, r.key(key))
+		firsts[i] = pipe.ZRangeWithScores(ctx, r.key(key), 0, 0)
+		lasts[i] = pipe.ZRevRangeWithScores(ctx, r.key(key), 0, 0)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, NewWrappedError("error in counting flairs", err, nil)
+	}
+
+	stats := make([]FlairStats, 0, len(keys)+len(names))
+	for i, key := range keys {
+		first, last := firsts[i].Val(), lasts[i].Val()
+		if len(first) == 0 || len(last) == 0 {
+			continue
+		}
+
+		flairKey := ""
+		if key != RedisUnflaired {
+			flairKey = strings.TrimPrefix(key, RedisFlairsPrefix)
+		}
+
+		stats = append(stats, FlairStats{
+			Key:   flairKey,
+			Count: counts[i].Val(),
+			First: first[0].Score,
+			Last:  last[0].Score,
+		})
+	}
+
+	// Flairs without submissions are skipped when combined, so the names only name the sets.
+	for _, name := range names {
+		stats = append(stats, FlairStats{Key: r.client.flairKey(name), Name: name})
+	}
+
+	return r.client.combineFlairStats(stats), nil
+}
+
+// getTopUpvoted ranks the submissions in Redis in one transaction: the flair sets of the filter are merged and the search sets intersected keeping creation dates,
+// trimmed to the window and intersected with RedisUpvotes and RedisSubmissions, keeping only the upvotes.
+func (r *Redis) getTopUpvoted(filter TopFilter, window TimeRange, limit int) ([]redis.Z, *ContextError) {
+	// Each step stores the set into the other temporary key than it reads, as some servers empty the destination before reading the sources.
+	temporaries := []string{r.key(RedisTopTemporary), r.key(RedisTopTemporary + RedisDelimiter + "ranked")}
+	current := ""
+	next := func() string {
+		if current == temporaries[0] {
+			current = temporaries[1]
+		} else {
+			current = temporaries[0]
+		}
+		return current
+	}
+
+	var searchKeys []string
+	for _, search := range filter.Searches {
+		searchKeys = append(searchKeys, r.key(RedisSearchPrefix+search))
+	}
+
+	var keys []string
+	for _, flairKey := range filter.FlairKeys {
+		keys = append(keys, r.key(r.flairKey(flairKey)))
+	}
+
+	if len(filter.Searches) == 0 && len(filter.FlairKeys) == 0 {
+		// Every submission is either unflaired or has a flair, so together the flair sets have every creation date.
+		keys = append(keys, r.key(RedisUnflaired))
+		ce := r.scanKeys(RedisFlairsPrefix, func(key string) error {
+			keys = append(keys, r.key(key))
+			return nil
+		})
+		if ce != nil {
+			return nil, ce
+		}
+	}
+
+	rangeBy := window.zRangeBy()
+
+	pipe := r.TxPipeline()
+	if len(keys) != 0 {
+		source := next()
+		pipe.ZUnionStore(ctx, source, &redis.ZStore{Keys: keys, Aggregate: "MIN"})
+		searchKeys = append(searchKeys, source)
+	}
+
+	if len(filter.Searches) != 0 {
+		pipe.ZInterStore(ctx, next(), &redis.ZStore{Keys: searchKeys, Aggregate: "MIN"})
+	}
+
+	if window.HasAfter {
+		pipe.ZRemRangeByScore(ctx, current, "-inf", "("+rangeBy.Min)
+	}
+
+	if window.HasBefore {
+		pipe.ZRemRangeByScore(ctx, current, strings.TrimPrefix(rangeBy.Max, "("), "+inf")
+	}
+
+	source := current
+	pipe.ZInterStore(ctx, next(), &redis.ZStore{
+		Keys:    []string{source, r.key(RedisUpvotes), r.key(RedisSubmissions)},
+		Weights: []float64{0, 1, 0},
+	})
+	top := pipe.ZRevRangeWithScores(ctx, current, 0, int64(limit-1))
+	pipe.Del(ctx, temporaries...)
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, NewWrappedError("error in ranking upvotes", err, []ContextParam{
+			{"Searches", fmt.Sprint(filter.Searches)},
+			{"Flair Keys", fmt.Sprint(filter.FlairKeys)},
+		})
+	}
+
+	return top.Val(), nil
+}
+
+func (r *Redis) getAuthor(author string, window TimeRange) ([]redis.Z, *ContextError) {
+	return r.getZSetRange(RedisAuthorsPrefix+strings.ToLower(author), window.zRangeBy())
+}
+
+func (r *Redis) getDomain(domain string, window TimeRange) ([]redis.Z, *ContextError) {
+	domains := domainAndParents(domain)
+	if len(domains) == 0 {
+		return nil, nil
+	}
+
+	return r.getZSetRange(RedisDomainsPrefix+domains[0], window.zRangeBy())
+}
+
+func (r *Redis) getUpvotes(fullIDs []string) (map[string]float64, *ContextError) {
+	upvotes := make(map[string]float64, len(fullIDs))
+	if len(fullIDs) == 0 {
+		return upvotes, nil
+	}
+
+	pipe := r.Pipeline()
+	scores := make([]*redis.FloatCmd, len(fullIDs))
+	for i, fullID := range fullIDs {
+		scores[i] = pipe.ZScore(ctx, r.key(RedisUpvotes), fullID)
+	}
+
+	// Submissions without upvotes are missing from the result rather than an error.
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, NewContextError(err, []ContextParam{
+			{"Redis Key", RedisUpvotes},
+		})
+	}
+
+	for i, cmd := range scores {
+		if cmd.Err() == nil {
+			upvotes[fullIDs[i]] = cmd.Val()
+		}
+	}
+
+	return upvotes, nil
+}
+
+func (r *Redis) getSubmissions(fullIDs []string) (map[string]PushshiftSubmission, *ContextError) {
+	submissions := make(map[string]PushshiftSubmission, len(fullIDs))
+	if len(fullIDs) == 0 {
+		return submissions, nil
+	}
+
+	values, err := r.HMGet(ctx, r.key(RedisAllSubmissions), fullIDs...).Result()
+	if err != nil {
+		return nil, NewContextError(err, []ContextParam{
+			{"Redis Key", RedisAllSubmissions},
+			{"IDs", fmt.Sprint(fullIDs)},
+		})
+	}
+
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		var submission PushshiftSubmission
+		if err := submission.UnmarshalBinary([]byte(data)); err != nil {
+			return nil, NewContextError(err, []ContextParam{
+				{"ID", fullIDs[i]},
+			})
+		}
+
+		submissions[fullIDs[i]] = submission
+	}
+
+	return submissions, nil
+}
+
+func (r *Redis) getLinks(fullIDs []string) (map[string]string, *ContextError) {
+	links := make(map[string]string, len(fullIDs))
+	if len(fullIDs) == 0 {
+		return links, nil
+	}
+
+	values, err := r.HMGet(ctx, r.key(RedisLinks), fullIDs...).Result()
+	if err != nil {
+		return nil, NewContextError(err, []ContextParam{
+			{"Redis Key", RedisLinks},
+			{"IDs", fmt.Sprint(fullIDs)},
+		})
+	}
+
+	for i, value := range values {
+		if link, ok := value.(string); ok {
+			links[fullIDs[i]] = link
+		}
+	}
+
+	return links, nil
+}
+
+func (r *Redis) getUnremoved(fullIDs []string) ([]string, *ContextError) {
+	pipe := r.Pipeline()
+	exists := make([]*redis.BoolCmd, len(fullIDs))
+	for i, fullID := range fullIDs {
+		exists[i] = pipe.SIsMember(ctx, r.key(RedisSubmissions), fullID)
+	}
+
+	if len(fullIDs) != 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, NewContextError(err, []ContextParam{
+				{"Redis Key", RedisSubmissions},
+			})
+		}
+	}
+
+	unremoved := make([]string, 0, len(fullIDs))
+	for i, cmd := range exists {
+		if cmd.Val() {
+			unremoved = append(unremoved, fullIDs[i])
+		}
+	}
+
+	return unremoved, nil
+}
+
+func (r *Redis) addProcessed(fullIDs []string) *ContextError {
+	if len(fullIDs) == 0 {
+		return nil
+	}
+
+	processed := make([]interface{}, len(fullIDs))
+	for i, id := range fullIDs {
+		processed[i] = id
+	}
+
+	if err := r.SAdd(ctx, r.key(RedisProcessed), processed...).Err(); err != nil {
+		return NewWrappedError("could not add processed submissions to Redis", err, []ContextParam{
+			{"IDs", fmt.Sprint(fullIDs)},
+		})
+	}
+
+	return nil
+}
+
+func (r *Redis) getProcessed(fullIDs []string) (map[string]bool, *ContextError) {
+	processed := make(map[string]bool, len(fullIDs))
+	if len(fullIDs) == 0 {
+		return processed, nil
+	}
+
+	pipe := r.Pipeline()
+	exists := make([]*redis.BoolCmd, len(fullIDs))
+	for i, fullID := range fullIDs {
+		exists[i] = pipe.SIsMember(ctx, r.key(RedisProcessed), fullID)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, NewContextError(err, []ContextParam{
+			{"Redis Key", RedisProcessed},
+		})
+	}
+
+	for i, fullID := range fullIDs {
+		processed[fullID] = exists[i].Val()
+	}
+
+	return processed, nil
+}
+
+func (r *Redis) getReplies(fullIDs []string) (map[string]string, *ContextError) {
+	replies := make(map[string]string, len(fullIDs))
+	if len(fullIDs) == 0 {
+		return replies, nil
+	}
+
+	values, err := r.HMGet(ctx, r.key(RedisReplies), fullIDs...).Result()
+	if err != nil {
+		return nil, NewContextError(err, []ContextParam{
+			{"Redis Key", RedisReplies},
+			{"IDs", fmt.Sprint(fullIDs)},
+		})
+	}
+
+	for i, value := range values {
+		if replyID, ok := value.(string); ok {
+			replies[fullIDs[i]] = replyID
+		}
+	}
+
+	return replies, nil
+}
+
+func (r *Redis) setReply(fullID string, replyID string) *ContextError {
+	if err := r.HSet(ctx, r.key(RedisReplies), fullID, replyID).Err(); err != nil {
+		return NewContextError(err, []ContextParam{
+			{"Redis Key", RedisReplies},
+			{"ID", fullID},
+			{"Reply ID", replyID},
+		})
+	}
+
+	return nil
+}
+
+func (r *Redis) getSubscriptions() ([]Subscription, *ContextError) {
+	var subscriptions []Subscription
+	ce := r.scanHash(RedisSubscriptions, func(field, value string) error {
+		var subscription Subscription
+		if err := subscription.UnmarshalBinary([]byte(value)); err != nil {
+			return err
+		}
+
+		subscriptions = append(subscriptions, subscription)
+		return nil
+	})
+	if ce != nil {
+		return nil, ce
+	}
+
+	return subscriptions, nil
+}
+
+func (r *Redis) setSubscription(subscription Subscription) *ContextError {
+	if err := r.HSet(ctx, r.key(RedisSubscriptions), subscription.key(), subscription).Err(); err != nil {
+		return NewContextError(err, []ContextParam{
+			{"Redis Key", RedisSubscriptions},
+			{"User", subscription.User},
+			{"Query", subscription.Query},
+		})
+	}
+
+	return nil
+}
+
+func (r *Redis) removeSubscription(user string, query string) (bool, *ContextError) {
+	removed, err := r.HDel(ctx, r.key(RedisSubscriptions), subscriptionKey(user, query)).Result()
+	if err != nil {
+		return false, NewContextError(err, []ContextParam{
+			{"Redis Key", RedisSubscriptions},
+			{"User", user},
+			{"Query", query},
+		})
+	}
+
+	return removed != 0, nil
+}
+
+func (r *Redis) getReplyCommand(replyID string) (string, *ContextError) {
+	commandLine, err := r.HGet(ctx, r.key(RedisReplyCommands), replyID).Result()
+	if err == redis.Nil {
+		return "", nil
+	} else if err != nil {
+		return "", NewContextError(err, []ContextParam{
+			{"Redis Key", RedisReplyCommands},
+			{"Reply ID", replyID},
+		})
+	}
+
+	return commandLine, nil
+}
+
+func (r *Redis) setReplyCommand(replyID string, commandLine string) *ContextError {
+	if err := r.HSet(ctx, r.key(RedisReplyCommands), replyID, commandLine).Err(); err != nil {
+		return NewContextError(err, []ContextParam{
+			{"Redis Key", RedisReplyCommands},
+			{"Reply ID", replyID},
+			{"Command", commandLine},
 		})
 	}
 
@@ -262,7 +1274,7 @@ func (r *Redis) setAnchor(anchorKey string, fullID string, timestamp reddit.Time
 	c := r.client
 	c.Logger.Infof("Setting %s to %s.", anchorKey, anchorString)
 
-	if err := r.Set(ctx, anchorKey, anchorString, 0).Err(); err != nil {
+	if err := r.Set(ctx, r.key(anchorKey), anchorString, 0).Err(); err != nil {
 		c.dfatal(NewContextError(fmt.Errorf("could not set %s: %w", anchorKey, err), []ContextParam{
 			{"Anchor String", anchorString},
 		}))
@@ -277,9 +1289,83 @@ func (r *Redis) setCurrentAnchor(anchorKey string, fullID string, timestamp redd
 		return err
 	}
 
-	if err := r.Set(ctx, RedisSearchIsForwards, isForwards, 0).Err(); err != nil {
+	if err := r.Set(ctx, r.key(RedisSearchIsForwards), isForwards, 0).Err(); err != nil {
 		r.client.dfatal(NewContextlessError(fmt.Errorf("could not set %s: %w", RedisSearchIsForwards, err)))
 	}
 
 	return nil
 }
+
+// scanHash calls f with every field and value of the hash without loading the whole hash into memory.
+func (r *Redis) scanHash(key string, f func(field, value string) error) *ContextError {
+	iter := r.HScan(ctx, r.key(key), 0, "", 500).Iterator()
+	for iter.Next(ctx) {
+		field := iter.Val()
+		if !iter.Next(ctx) {
+			break
+		}
+
+		if err := f(field, iter.Val()); err != nil {
+			return NewWrappedError(fmt.Sprintf("error in scanning %s", key), err, []ContextParam{
+				{"Field", field},
+			})
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		return NewWrappedError(fmt.Sprintf("error in scanning %s", key), err, nil)
+	}
+
+	return nil
+}
+
+// scanKeys calls f with every key of the given prefix, named without the hash tag.
+// On a cluster every master is scanned.
+func (r *Redis) scanKeys(prefix string, f func(key string) error) *ContextError {
+	var lock sync.Mutex
+	scan := func(client redis.Cmdable) error {
+		iter := client.Scan(ctx, 0, r.key(prefix)+"*", 500).Iterator()
+		for iter.Next(ctx) {
+			key := strings.TrimPrefix(iter.Val(), r.hashTag)
+
+			lock.Lock()
+			err := f(key)
+			lock.Unlock()
+
+			if err != nil {
+				return fmt.Errorf("error in key %s: %w", key, err)
+			}
+		}
+
+		return iter.Err()
+	}
+
+	var err error
+	if cluster, ok := r.UniversalClient.(*redis.ClusterClient); ok {
+		err = cluster.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+			return scan(master)
+		})
+	} else {
+		err = scan(r.UniversalClient)
+	}
+
+	if err != nil {
+		return NewWrappedError(fmt.Sprintf(`error in keys of prefix "%s"`, prefix), err, nil)
+	}
+
+	return nil
+}
+
+// getMembers returns the members of a set, or the fields of a hash as RedisSubmissions and RedisRemovedSubmissions were stored before being compacted.
+func (r *Redis) getMembers(key string) ([]string, error) {
+	keyType, err := r.Type(ctx, r.key(key)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	if keyType == "hash" {
+		return r.HKeys(ctx, r.key(key)).Result()
+	}
+
+	return r.SMembers(ctx, r.key(key)).Result()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"go.uber.org/zap"
+)
+
+func TestRedisHashTag(t *testing.T) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	config := &Config{Constants: testConstants}
+	config.Redis.Addr = server.Addr()
+	config.Redis.HashTag = "{ArchiveBot}"
+	c := &Client{Logger: zap.NewNop().Sugar(), Config: config, Flairs: NewFlairLookup(config.Flairs)}
+	c.Search = &Search{client: c, config: config}
+	if err := c.Search.setSearches(config.Constants); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewRedisClient(c, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if err := r.addSubmissions(testSubmissions); err != nil {
+		t.Fatal(err)
+	}
+	if ce := r.addProcessed([]string{"t4_a"}); ce != nil {
+		t.Fatal(ce)
+	}
+	if ce := r.setReply("t4_a", "t1_b"); ce != nil {
+		t.Fatal(ce)
+	}
+	if ce := r.setReplyCommand("t1_b", "search python"); ce != nil {
+		t.Fatal(ce)
+	}
+
+	for _, key := range server.Keys() {
+		name := strings.TrimPrefix(key, config.Redis.HashTag)
+		if tagged := name != key; tagged == RedisUntaggedKeys[name] {
+			t.Errorf("%s is tagged %v, want %v", key, tagged, !tagged)
+		}
+	}
+
+	for _, name := range []string{RedisAllSubmissions, RedisStatsCounts, RedisSchema, RedisProcessed} {
+		if !server.Exists(r.key(name)) {
+			t.Errorf("%s wasn't written as %s", name, r.key(name))
+		}
+	}
+}
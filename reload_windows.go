@@ -0,0 +1,7 @@
+//go:build windows
+// +build windows
+
+package main
+
+// ReloadOnHangup does nothing since Windows has no SIGHUP.
+func (c *Client) ReloadOnHangup() {}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// RedditMaxCommentLength is the most characters Reddit allows in a comment or private message.
+const RedditMaxCommentLength = 10000
+
+// ReplyMaxParts is the most comments a reply is split over, replies to each other, before items are left out.
+const ReplyMaxParts = 3
+
+// ReplyTruncated ends text cut short to fit in a comment.
+const ReplyTruncated = "…"
+
+// DefaultReplyMore is the hint for items left out of a reply when Reply.More isn't set.
+const DefaultReplyMore = "…and %d more."
+
+// markdownEscaper escapes the characters which would change how text is rendered within a link or a table.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`[`, `\[`,
+	`]`, `\]`,
+	"`", "\\`",
+	`*`, `\*`,
+	`_`, `\_`,
+	`~`, `\~`,
+	`^`, `\^`,
+	`|`, `\|`,
+)
+
+// markdownURLEscaper escapes the characters which would end a link's URL early.
+var markdownURLEscaper = strings.NewReplacer(
+	` `, `%20`,
+	`(`, `%28`,
+	`)`, `%29`,
+)
+
+// escapeMarkdown escapes text so that it's shown as written, e.g. a title with brackets within a link.
+func escapeMarkdown(text string) string {
+	return markdownEscaper.Replace(text)
+}
+
+// markdownLink formats a link as [title](url), escaping the title and URL.
+func markdownLink(title string, url string) string {
+	return fmt.Sprintf("[%s](%s)", escapeMarkdown(title), markdownURLEscaper.Replace(url))
+}
+
+// Reply is a reply with a list of items, which is split over several comments when it's too long for one.
+// Items are never split between comments, and are left out with a hint when they don't fit in ReplyMaxParts comments.
+type Reply struct {
+	Header      string   // The start of the first comment.
+	ItemsHeader string   // The start of the items of every comment, e.g. the header row of a table.
+	Items       []string // Lines of the list.
+	Separator   string   // Between items.
+	Footer      string   // The end of the last comment.
+	More        string   // The hint for items left out, taking their number. Defaults to DefaultReplyMore.
+}
+
+// render formats a comment with the start, the items and the end.
+func (r Reply) render(start string, items []string, end string) string {
+	if len(items) == 0 {
+		return start + end
+	}
+
+	return start + r.ItemsHeader + strings.Join(items, r.Separator) + end
+}
+
+// more is the hint for the number of items left out.
+func (r Reply) more(count int) string {
+	more := r.More
+	if more == "" {
+		more = DefaultReplyMore
+	}
+
+	return r.Separator + fmt.Sprintf(more, count)
+}
+
+// split splits the reply into comments of at most limit characters, measured as the markdown sent.
+// Every comment makes room for the footer and the hint, so the last one kept always has room for both.
+func (r Reply) split(limit int, maxParts int) []string {
+	reserved := runeCount(r.Footer)
+	if len(r.Items) != 0 {
+		reserved += runeCount(r.more(len(r.Items)))
+	}
+
+	capacity := limit - reserved
+	start := truncateMarkdown(r.Header, capacity)
+
+	var parts []string
+	var items []string
+	left := len(r.Items)
+	for _, item := range r.Items {
+		if len(items) != 0 && runeCount(r.render(start, append(items, item), "")) > capacity {
+			if len(parts) == maxParts-1 {
+				break
+			}
+
+			parts = append(parts, r.render(start, items, ""))
+			start, items = "", nil
+		}
+
+		if len(items) == 0 {
+			item = truncateMarkdown(item, capacity-runeCount(r.render(start, []string{""}, "")))
+		}
+
+		items = append(items, item)
+		left--
+	}
+
+	end := r.Footer
+	if left != 0 {
+		end = r.more(left) + end
+	}
+
+	return append(parts, r.render(start, items, end))
+}
+
+// runeCount is the number of characters of the text, as Reddit counts them.
+func runeCount(text string) int {
+	return utf8.RuneCountInString(text)
+}
+
+// truncateMarkdown cuts the text short to at most max characters, ending it with ReplyTruncated.
+// Links are never broken: a link which doesn't fit has its title cut short instead, and escapes are kept whole.
+func truncateMarkdown(text string, max int) string {
+	if runeCount(text) <= max {
+		return text
+	}
+
+	keep := max - runeCount(ReplyTruncated)
+	if keep <= 0 {
+		return ""
+	}
+
+	runes := []rune(text)
+	var kept []rune
+	for i := 0; i < len(runes); {
+		titleEnd, end, isLink := markdownLinkAt(runes, i)
+		if !isLink {
+			end = i + escapedLength(runes, i)
+		}
+
+		if len(kept)+end-i <= keep {
+			kept = append(kept, runes[i:end]...)
+			i = end
+			continue
+		}
+
+		if isLink {
+			// The title ends with ReplyTruncated instead of the text, so the link's [ and ](url) are all that's reserved.
+			title := cutEscaped(runes[i+1:titleEnd], keep-len(kept)-1-(end-titleEnd))
+			if len(title) != 0 {
+				return string(kept) + "[" + string(title) + ReplyTruncated + string(runes[titleEnd:end])
+			}
+		}
+
+		break
+	}
+
+	return string(kept) + ReplyTruncated
+}
+
+// markdownLinkAt returns where the title ends and the link ends if a link made by markdownLink starts at i.
+func markdownLinkAt(runes []rune, i int) (int, int, bool) {
+	if runes[i] != '[' {
+		return 0, 0, false
+	}
+
+	titleEnd := i + 1
+	for titleEnd < len(runes) && runes[titleEnd] != ']' {
+		if runes[titleEnd] == '[' || runes[titleEnd] == '\n' {
+			return 0, 0, false
+		}
+		titleEnd += escapedLength(runes, titleEnd)
+	}
+
+	if titleEnd+1 >= len(runes) || runes[titleEnd+1] != '(' {
+		return 0, 0, false
+	}
+
+	for end := titleEnd + 2; end < len(runes) && runes[end] != ' ' && runes[end] != '\n'; end++ {
+		if runes[end] == ')' {
+			return titleEnd, end + 1, true
+		}
+	}
+
+	return 0, 0, false
+}
+
+// escapedLength is the length of the character at i, which is two for an escaped character.
+func escapedLength(runes []rune, i int) int {
+	if runes[i] == '\\' && i+1 < len(runes) {
+		return 2
+	}
+
+	return 1
+}
+
+// cutEscaped cuts the text to at most max characters without splitting an escape.
+func cutEscaped(runes []rune, max int) []rune {
+	end := 0
+	for end < len(runes) {
+		next := end + escapedLength(runes, end)
+		if next > max {
+			break
+		}
+		end = next
+	}
+
+	return runes[:end]
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTruncateMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		max  int
+		want string
+	}{
+		{"fits", "short", 5, "short"},
+		{"plain", "a long line", 7, "a long…"},
+		{"no room", "a long line", 1, ""},
+		{"escape kept whole", `ab\*cd`, 4, `ab…`},
+		{"link title cut", "- [a long title](https://redd.it/x) 10", 30, "- [a long…](https://redd.it/x)"},
+		{"escaped title cut", `- [a \[b\] c](u)`, 12, `- [a \[…](u)`},
+		{"no room for the title", "- [title](https://redd.it/x)", 10, "- …"},
+		{"after the link", "- [title](u) and more", 15, "- [title](u) a…"},
+		{"brackets which aren't a link", "[not a link] text", 8, "[not a …"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := truncateMarkdown(test.text, test.max)
+			if got != test.want {
+				t.Errorf("truncateMarkdown(%q, %d) = %q, want %q", test.text, test.max, got, test.want)
+			}
+
+			if runeCount(got) > test.max {
+				t.Errorf("truncateMarkdown(%q, %d) is %d characters", test.text, test.max, runeCount(got))
+			}
+		})
+	}
+}
+
+// testReplyItems are count links to posts, each 50 characters long.
+func testReplyItems(count int) []string {
+	items := make([]string, count)
+	for i := range items {
+		items[i] = fmt.Sprintf("- [Post number %03d](https://redd.it/%020d)", i, i)
+	}
+
+	return items
+}
+
+func TestReplySplit(t *testing.T) {
+	tests := []struct {
+		name      string
+		reply     Reply
+		limit     int
+		maxParts  int
+		wantParts int
+		wantMore  bool
+	}{
+		{"one part", Reply{Header: "Results:\n\n", Items: testReplyItems(3), Separator: "\n", Footer: "\n\nfooter"}, 1000, 3, 1, false},
+		{"no items", Reply{Header: "Nothing found.", Footer: "\n\nfooter"}, 1000, 3, 1, false},
+		{"several parts", Reply{Header: "Results:\n\n", Items: testReplyItems(10), Separator: "\n", Footer: "\n\nfooter"}, 200, 5, 4, false},
+		{"items left out", Reply{Header: "Results:\n\n", Items: testReplyItems(20), Separator: "\n", Footer: "\n\nfooter"}, 200, 3, 3, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parts := test.reply.split(test.limit, test.maxParts)
+			if len(parts) != test.wantParts {
+				t.Fatalf("split into %d parts, want %d: %q", len(parts), test.wantParts, parts)
+			}
+
+			joined := strings.Join(parts, "\n")
+			for i, part := range parts {
+				if runeCount(part) > test.limit {
+					t.Errorf("part %d is %d characters, more than %d", i, runeCount(part), test.limit)
+				}
+			}
+
+			if !strings.HasPrefix(parts[0], test.reply.Header) || !strings.HasSuffix(parts[len(parts)-1], test.reply.Footer) {
+				t.Errorf("the header or footer is missing: %q", parts)
+			}
+
+			// Every item is either sent whole or counted in the hint.
+			sent := 0
+			for _, item := range test.reply.Items {
+				if strings.Contains(joined, item) {
+					sent++
+				}
+			}
+
+			more := fmt.Sprintf(DefaultReplyMore, len(test.reply.Items)-sent)
+			if hasMore := strings.Contains(joined, more); hasMore != test.wantMore || (!test.wantMore && sent != len(test.reply.Items)) {
+				t.Errorf("sent %d of %d items, the hint %q is shown: %v", sent, len(test.reply.Items), more, hasMore)
+			}
+		})
+	}
+}
+
+func TestReplySplitLongItem(t *testing.T) {
+	item := "- " + markdownLink(strings.Repeat("Very long title ", 20), "https://www.reddit.com/r/test/comments/abc/") + " (5)"
+	parts := Reply{Header: "Results:\n\n", Items: []string{item}, Footer: "\n\nfooter"}.split(120, 3)
+	if len(parts) != 1 {
+		t.Fatalf("split into %d parts, want 1: %q", len(parts), parts)
+	}
+
+	if runeCount(parts[0]) > 120 {
+		t.Errorf("the part is %d characters, more than 120", runeCount(parts[0]))
+	}
+
+	if !strings.Contains(parts[0], ReplyTruncated+"](https://www.reddit.com/r/test/comments/abc/)") {
+		t.Errorf("the link was broken instead of its title cut short: %q", parts[0])
+	}
+}
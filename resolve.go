@@ -0,0 +1,151 @@
+package main
+
+import (
+	"strings"
+)
+
+// ResolvePrefixCost is the cost of a term being the start of a name, less than a single typo.
+const ResolvePrefixCost = 0.9
+
+// ResolveMinPrefix is the shortest term matched as the start of a name.
+const ResolveMinPrefix = 3
+
+// termResolution is what a term that isn't exactly a search alias was resolved to.
+type termResolution struct {
+	Search string  // The canonical name of a search, or empty.
+	Flair  string  // The name of a flair, or empty.
+	Exact  bool    // Whether the term was written exactly as the flair.
+	Cost   float64 // How far the term is from the name, 0 if they're the same once normalized, see nameCost.
+}
+
+// maxTypos is the most edits allowed between a term and a name, longer terms allowing more.
+func maxTypos(length int) int {
+	switch {
+	case length < 3:
+		return 0
+	case length <= 5:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// nameCost is how far apart a normalized term and name are, or false if they're too different.
+func nameCost(term []rune, name []rune) (float64, bool) {
+	if string(name) == string(term) {
+		return 0, true
+	}
+
+	if len(term) >= ResolveMinPrefix && len(term) < len(name) && string(name[:len(term)]) == string(term) {
+		return ResolvePrefixCost, true
+	}
+
+	// Lengths too far apart can't be within the allowed edits.
+	typos := maxTypos(len(term))
+	if diff := len(name) - len(term); diff > typos || -diff > typos {
+		return 0, false
+	}
+
+	distance := editDistance(term, name)
+	if distance > typos {
+		return 0, false
+	}
+
+	return float64(distance), true
+}
+
+// editDistance is the optimal string alignment distance, counting insertions, deletions, substitutions and swapping adjacent runes.
+func editDistance(a, b []rune) int {
+	// Three rows of the matrix are kept, as a swap looks two rows back.
+	previous2 := make([]int, len(b)+1)
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			current[j] = minInt(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				current[j] = minInt(current[j], previous2[j-2]+1)
+			}
+		}
+
+		previous2, previous, current = previous, current, previous2
+	}
+
+	return previous[len(b)]
+}
+
+func minInt(values ...int) int {
+	min := values[0]
+	for _, value := range values[1:] {
+		if value < min {
+			min = value
+		}
+	}
+
+	return min
+}
+
+// Closest returns the canonical name of the search with the name or alias closest to the term, see nameCost.
+func (m *TitleMatcher) Closest(term string) (string, float64, bool) {
+	folded := m.normalizer.Fold(strings.TrimSpace(term))
+
+	best, bestCost, found := "", 0.0, false
+	for i, alias := range m.aliases {
+		cost, ok := nameCost(folded, []rune(alias))
+		if ok && (!found || cost < bestCost) {
+			best, bestCost, found = m.canonical[m.groups[i]], cost, true
+		}
+	}
+
+	return best, bestCost, found
+}
+
+// resolveTerm finds the search or flair a term most likely meant.
+// A flair matching the normalized term exactly wins over a misspelt search, and otherwise searches win ties.
+func (e *queryEvaluator) resolveTerm(term string) (termResolution, bool, error) {
+	matcher := e.client.Search.Matcher()
+	search, searchCost, foundSearch := matcher.Closest(term)
+
+	if e.flairNames == nil {
+		flairNames, ce := e.client.Archive.getFlairNames()
+		if ce != nil {
+			return termResolution{}, false, ce
+		}
+
+		e.flairNames = append(flairNames, e.client.searchableFlairNames()...)
+	}
+
+	folded := matcher.normalizer.Fold(strings.TrimSpace(term))
+	flair, flairCost, foundFlair := "", 0.0, false
+	for _, name := range e.flairNames {
+		if name == "" {
+			continue
+		}
+
+		cost, ok := nameCost(folded, matcher.normalizer.Fold(name))
+		if ok && (!foundFlair || cost < flairCost) {
+			flair, flairCost, foundFlair = name, cost, true
+		}
+	}
+
+	switch {
+	case foundFlair && flairCost == 0:
+		return termResolution{Flair: flair, Exact: flair == term}, true, nil
+	case foundSearch && (!foundFlair || searchCost <= flairCost):
+		return termResolution{Search: search, Cost: searchCost}, true, nil
+	case foundFlair:
+		return termResolution{Flair: flair, Cost: flairCost}, true, nil
+	default:
+		return termResolution{}, false, nil
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"", "abc", 3},
+		{"abc", "", 3},
+		{"python", "python", 0},
+		{"pyhton", "python", 1},  // Swap.
+		{"pythn", "python", 1},   // Insertion.
+		{"pythoon", "python", 1}, // Deletion.
+		{"pithon", "python", 1},  // Substitution.
+		{"ypthno", "python", 2},  // Two swaps.
+		{"ca", "abc", 3},         // A swapped pair isn't edited again.
+		{"kitten", "sitting", 3},
+		{"café", "cafe", 1}, // Runes, not bytes.
+		{"джанго", "джнаго", 1},
+	}
+
+	for _, test := range tests {
+		if got := editDistance([]rune(test.a), []rune(test.b)); got != test.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+
+		if got := editDistance([]rune(test.b), []rune(test.a)); got != test.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", test.b, test.a, got, test.want)
+		}
+	}
+}
+
+func TestNameCost(t *testing.T) {
+	tests := []struct {
+		term, name string
+		cost       float64
+		ok         bool
+	}{
+		{"python", "python", 0, true},
+		{"pyt", "python", ResolvePrefixCost, true},
+		{"py", "python", 0, false}, // Too short to be a prefix.
+		{"pyhton", "python", 1, true},
+		{"pyhtno", "python", 2, true},
+		{"pxtxxn", "python", 0, false}, // Three edits.
+		{"dajngo", "django", 1, true},
+		{"go", "js", 0, false}, // Short terms must be exact.
+		{"rust", "rusk", 1, true},
+		{"rsut", "rusk", 0, false}, // Four letters allow one typo.
+		{"javascript", "java", 0, false},
+	}
+
+	for _, test := range tests {
+		cost, ok := nameCost([]rune(test.term), []rune(test.name))
+		if cost != test.cost || ok != test.ok {
+			t.Errorf("nameCost(%q, %q) = %g, %v, want %g, %v", test.term, test.name, cost, ok, test.cost, test.ok)
+		}
+	}
+}
+
+func TestResolveTerm(t *testing.T) {
+	forEachBackend(t, testConstants, func(t *testing.T, c *Client) {
+		// Help, Solved and a flair named like a misspelt search.
+		submissions := append(testSubmissions, testSubmission("d", "Asking about Django", "Djangoo", 1, 4000))
+		if err := c.Archive.addSubmissions(submissions); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			term string
+			want termResolution
+			ok   bool
+		}{
+			{"pyhton", termResolution{Search: "Python", Cost: 1}, true},
+			{"djang", termResolution{Search: "Django", Cost: ResolvePrefixCost}, true},
+			{"Solved", termResolution{Flair: "Solved", Exact: true}, true},
+			{"solved", termResolution{Flair: "Solved"}, true},
+			{"slved", termResolution{Flair: "Solved", Cost: 1}, true},
+			{"unflared", termResolution{Flair: FlairsDefaultUnflaired, Cost: 1}, true},
+			// A flair matching exactly wins over a misspelt search, otherwise searches win ties.
+			{"djangoo", termResolution{Flair: "Djangoo"}, true},
+			{"djangoa", termResolution{Search: "Django", Cost: 1}, true},
+			{"djangooo", termResolution{Flair: "Djangoo", Cost: 1}, true},
+			{"kubernetes", termResolution{}, false},
+		}
+
+		e := &queryEvaluator{client: c}
+		for _, test := range tests {
+			resolution, ok, err := e.resolveTerm(test.term)
+			if err != nil {
+				t.Errorf("resolveTerm(%q): %v", test.term, err)
+				continue
+			}
+
+			if resolution != test.want || ok != test.ok {
+				t.Errorf("resolveTerm(%q) = %+v, %v, want %+v, %v", test.term, resolution, ok, test.want, test.ok)
+			}
+		}
+	})
+}
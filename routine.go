@@ -18,8 +18,8 @@ import (
 
 // AnalyzeSubmissions analyzes Reddit submissions.
 // The parameters Before, After, and Count params are handled in AnalyzeSubmissions.
-func (c Client) AnalyzeSubmissions() *ContextError {
-	submissions, err := c.Redis.getHashMap(RedisAllSubmissions)
+func (c *Client) AnalyzeSubmissions() *ContextError {
+	fullIDs, err := c.Archive.getSubmissionIDs()
 	if err != nil {
 		if !c.IsProduction {
 			return err
@@ -27,6 +27,11 @@ func (c Client) AnalyzeSubmissions() *ContextError {
 		err.LogError(c.Logger)
 	}
 
+	submissions := make(map[string]struct{}, len(fullIDs))
+	for _, fullID := range fullIDs {
+		submissions[fullID] = struct{}{}
+	}
+
 	submissions, err = c.analyzeRedditSubmissions(submissions)
 	if err != nil {
 		if !c.IsProduction {
@@ -65,18 +70,18 @@ type PostListing struct {
 	} `json:"data"`
 }
 
-func (c *Client) analyzeRedditSubmissions(submissionsMap map[string]string) (map[string]string, *ContextError) {
+func (c *Client) analyzeRedditSubmissions(submissionsMap map[string]struct{}) (map[string]struct{}, *ContextError) {
 	totalSubmissions := 0
 	for len(submissionsMap) > 0 {
 		ids := make([]string, 0, c.Config.Reddit.SearchLimit)
 		removed := make(map[string]bool, c.Config.Reddit.SearchLimit)
-		for _, submission := range submissionsMap {
+		for fullID := range submissionsMap {
 			if len(ids) >= c.Config.Reddit.SearchLimit {
 				break
 			}
 
-			ids = append(ids, submission)
-			removed[submission] = true
+			ids = append(ids, fullID)
+			removed[strings.TrimPrefix(fullID, "t3_")] = true
 		}
 
 		c.Logger.Infof("Reading submission IDs: %v", ids)
@@ -93,7 +98,7 @@ func (c *Client) analyzeRedditSubmissions(submissionsMap map[string]string) (map
 		}
 
 		submissions := l.Data.Children
-		err = c.Redis.addSubmissions(submissions)
+		err = c.Archive.addSubmissions(submissions)
 		if err != nil {
 			return submissionsMap, NewContextlessError(err)
 		}
@@ -106,15 +111,15 @@ func (c *Client) analyzeRedditSubmissions(submissionsMap map[string]string) (map
 		}
 
 		for _, submission := range submissions {
-			delete(submissionsMap, submission.ID)
+			delete(submissionsMap, "t3_"+submission.ID)
 			removed[submission.ID] = false
 		}
 
-		if ce := c.Redis.updateVotes(submissions); ce != nil {
+		if ce := c.Archive.updateVotes(submissions); ce != nil {
 			c.dfatal(ce)
 		}
 
-		if ce := c.Redis.setRemoved(submissions, removed); ce != nil {
+		if ce := c.Archive.setRemoved(submissions, removed); ce != nil {
 			c.dfatal(ce)
 		}
 	}
@@ -283,7 +288,7 @@ type Anchor struct {
 }
 
 func (r *Redis) getAnchor(anchorKey string) (*Anchor, *ContextError) {
-	anchorString, err := r.Get(ctx, anchorKey).Result()
+	anchorString, err := r.Get(ctx, r.key(anchorKey)).Result()
 	if err != nil {
 		if err.Error() == redis.Nil.Error() {
 			return nil, NewContextlessError(err)
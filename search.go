@@ -1,7 +1,8 @@
 package main
 
 import (
-	"regexp"
+	"errors"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -20,17 +21,103 @@ type Search struct {
 	IsForwards   bool          // Whether the current anchor is traversing forwards or not.
 	LockTime     time.Duration // The amount of time a submission has until it is locked. Currently 60 days.
 	MaxRequests  int           // The max requests a search iteration can have. -1 is infinite.
+	matcher      *TitleMatcher // The compiled Constants.Searches, replaced when the config is reloaded.
+	matcherLock  sync.RWMutex
 }
 
 // ConstantsConfig is the search data.
 type ConstantsConfig struct {
-	CouldNotParse string     `toml:"could_not_parse"`
-	HelpStart     string     `toml:"help_start"`
-	HelpBody      string     `toml:"help_body"`
-	NoResults     string     `toml:"no_results"`
-	FoundResults  string     `toml:"found_results"`
-	Footer        string     `toml:"footer"`
-	Searches      [][]string `toml:"searches"`
+	CouldNotParse  string       `toml:"could_not_parse"`
+	HelpStart      string       `toml:"help_start"`
+	HelpBody       string       `toml:"help_body"`
+	NoResults      string       `toml:"no_results"`
+	FoundResults   string       `toml:"found_results"`
+	ResultsPage    string       `toml:"results_page"`
+	DidYouMean     string       `toml:"did_you_mean"`
+	SimilarResults string       `toml:"similar_results"`
+	NoSimilar      string       `toml:"no_similar"`
+	TopResults     string       `toml:"top_results"`
+	NoTop          string       `toml:"no_top"`
+	Footer         string       `toml:"footer"`
+	Searches       [][]string   `toml:"searches"`
+	Terms          []SearchTerm `toml:"terms"`
+
+	// StripDiacritics makes searches match letters with and without accents alike, see Normalizer.
+	StripDiacritics bool `toml:"strip_diacritics"`
+}
+
+// Search scopes, the parts of a submission a search term is matched against.
+const (
+	SearchScopeTitle    = "title"
+	SearchScopeSelftext = "selftext"
+	SearchScopeURL      = "url"
+	SearchScopeDomain   = "domain"
+)
+
+// ErrInvalidSearch is returned when a search in the config can't be compiled.
+var ErrInvalidSearch = errors.New("invalid search")
+
+// SearchTerm is a search defined as a table in Constants.terms.
+// Its name and aliases are matched as whole words, its patterns as case insensitive regexps, and matches inside a match of an exclude regexp don't count.
+type SearchTerm struct {
+	Name     string   `toml:"name"`
+	Aliases  []string `toml:"aliases"`
+	Patterns []string `toml:"patterns"`
+	Exclude  []string `toml:"exclude"`
+	Scope    []string `toml:"scope"` // Defaults to only the title.
+}
+
+// searchTerms returns every configured search, the arrays of aliases in Searches first.
+// Arrays with the same name are merged, as older configs repeated them.
+func (c ConstantsConfig) searchTerms() []SearchTerm {
+	terms := make([]SearchTerm, 0, len(c.Searches)+len(c.Terms))
+	named := make(map[string]int, len(c.Searches))
+	for _, search := range c.Searches {
+		if len(search) == 0 {
+			continue
+		}
+
+		if i, ok := named[search[0]]; ok {
+			terms[i].Aliases = append(terms[i].Aliases, search[1:]...)
+			continue
+		}
+
+		named[search[0]] = len(terms)
+		terms = append(terms, SearchTerm{Name: search[0], Aliases: append([]string(nil), search[1:]...)})
+	}
+
+	return append(terms, c.Terms...)
+}
+
+// DefaultResultsPage is used when ConstantsConfig.ResultsPage isn't set.
+// It's formatted with the number of results, how they're ordered, the page and the number of pages.
+const DefaultResultsPage = "%d results ordered by %s, showing page %d of %d."
+
+// DefaultDidYouMean is used when ConstantsConfig.DidYouMean isn't set.
+// It's formatted with the query after correcting misspelt terms.
+const DefaultDidYouMean = "Did you mean `%s`? Showing results for it."
+
+// withDefaults returns the constants with the unset ones that have a default set to it, for rendering replies.
+func (c ConstantsConfig) withDefaults() ConstantsConfig {
+	defaults := []struct {
+		constant *string
+		value    string
+	}{
+		{&c.ResultsPage, DefaultResultsPage},
+		{&c.DidYouMean, DefaultDidYouMean},
+		{&c.SimilarResults, DefaultSimilarResults},
+		{&c.NoSimilar, DefaultNoSimilar},
+		{&c.TopResults, DefaultTopResults},
+		{&c.NoTop, DefaultNoTop},
+	}
+
+	for _, d := range defaults {
+		if *d.constant == "" {
+			*d.constant = d.value
+		}
+	}
+
+	return c
 }
 
 // NewSearch initializes all the information needed for a bidirectional search.
@@ -40,7 +127,7 @@ func NewSearch(client *Client, config *Config) (*Search, *ContextError) {
 	anchors := make([]*Anchor, len(anchorKeys))
 
 	for i, anchorKey := range anchorKeys {
-		anchor, ce := client.Redis.getAnchor(anchorKey)
+		anchor, ce := client.Archive.getAnchor(anchorKey)
 		if ce != nil && ce.Unwrap().Error() != redis.Nil.Error() {
 			return nil, ce
 		}
@@ -50,7 +137,22 @@ func NewSearch(client *Client, config *Config) (*Search, *ContextError) {
 	// A lock occurs after 60 days.
 	lock := 60 * (time.Duration(24) * time.Hour)
 
-	return &Search{client, config, anchors[0], anchors[1], anchors[2], false, true, lock, 10}, nil
+	search := &Search{
+		client:       client,
+		config:       config,
+		Current:      anchors[0],
+		Start:        anchors[1],
+		End:          anchors[2],
+		TraversedAll: false,
+		IsForwards:   true,
+		LockTime:     lock,
+		MaxRequests:  10,
+	}
+	if err := search.setSearches(config.Constants); err != nil {
+		return nil, NewContextlessError(err)
+	}
+
+	return search, nil
 }
 
 func (s *Search) getSubmissions() {
@@ -63,24 +165,37 @@ func (s *Search) GetLockUnix() int64 {
 	return lockTime.Unix()
 }
 
-func (s *Search) getTitleMatches(title string) []string {
-	var matches []string
-	for _, searches := range s.config.Constants.Searches {
-		canonical := searches[0]
-		for _, search := range searches {
-			expr := "(?i)\\b" + regexp.QuoteMeta(search) + "\\b"
-			exp, err := regexp.Compile(expr)
-			if err != nil {
-				s.client.dfatal(err)
-				break
-			}
-
-			if exp.MatchString(title) {
-				matches = append(matches, canonical)
-				break
-			}
-		}
+// Matcher returns the compiled searches.
+func (s *Search) Matcher() *TitleMatcher {
+	s.matcherLock.RLock()
+	defer s.matcherLock.RUnlock()
+	return s.matcher
+}
+
+// setSearches compiles the searches, replacing the previous ones unless they're invalid.
+func (s *Search) setSearches(constants ConstantsConfig) error {
+	matcher, err := NewTitleMatcher(constants.searchTerms(), Normalizer{constants.StripDiacritics})
+	if err != nil {
+		return err
 	}
 
-	return matches
+	s.matcherLock.Lock()
+	defer s.matcherLock.Unlock()
+	s.matcher = matcher
+	return nil
+}
+
+// getTitleMatches returns the canonical name of every search mentioned in the title.
+func (s *Search) getTitleMatches(title string) []string {
+	return s.Matcher().Match(title)
+}
+
+// normalize normalizes text the same way as the searches, see Normalizer.
+func (s *Search) normalize(text string) string {
+	return s.Matcher().normalizer.Normalize(text)
+}
+
+// getSubmissionMatches returns the canonical name of every search mentioned in the parts of the submission it's scoped to.
+func (s *Search) getSubmissionMatches(submission PushshiftSubmission) []string {
+	return s.Matcher().MatchSubmission(submission)
 }
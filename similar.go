@@ -0,0 +1,352 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/vartanbeno/go-reddit/reddit"
+)
+
+// SimilarDefaultLimit is the number of similar posts replied with when Similar.limit isn't set.
+const SimilarDefaultLimit = 5
+
+// SimilarDefaultMinScore is the lowest similarity replied with when Similar.min_score isn't set.
+const SimilarDefaultMinScore = 0.1
+
+// SimilarDefaultCandidates is the most archived submissions compared when Similar.candidates isn't set.
+const SimilarDefaultCandidates = 2000
+
+// SimilarTextTerms is the most title words looked up with full text search to find candidates.
+const SimilarTextTerms = 8
+
+// SimilarMinWordLength is the shortest word compared, shorter ones rarely say anything about the topic.
+const SimilarMinWordLength = 3
+
+// DefaultSimilarResults is used when ConstantsConfig.SimilarResults isn't set.
+const DefaultSimilarResults = "Earlier posts similar to this one:"
+
+// DefaultNoSimilar is used when ConstantsConfig.NoSimilar isn't set.
+const DefaultNoSimilar = "I couldn't find any earlier posts similar to this one."
+
+// ErrNoParentPost is returned when the post a comment was made on can't be found.
+var ErrNoParentPost = errors.New("could not find the parent post")
+
+// SimilarConfig configures the similar command.
+type SimilarConfig struct {
+	Default    bool    `toml:"default"`    // Whether a mention without a command runs the similar command.
+	Limit      int     `toml:"limit"`      // The number of similar posts to reply with.
+	MinScore   float64 `toml:"min_score"`  // The lowest similarity, between 0 and 1, to reply with.
+	Candidates int     `toml:"candidates"` // The most archived submissions compared.
+}
+
+func (s SimilarConfig) limit() int {
+	if s.Limit <= 0 {
+		return SimilarDefaultLimit
+	}
+
+	return s.Limit
+}
+
+func (s SimilarConfig) minScore() float64 {
+	if s.MinScore <= 0 {
+		return SimilarDefaultMinScore
+	}
+
+	return s.MinScore
+}
+
+func (s SimilarConfig) candidates() int {
+	if s.Candidates <= 0 {
+		return SimilarDefaultCandidates
+	}
+
+	return s.Candidates
+}
+
+// similarStopWords are common English words which say nothing about what a post is about.
+var similarStopWords = map[string]bool{
+	"about": true, "after": true, "all": true, "and": true, "any": true, "are": true, "been": true, "but": true,
+	"can": true, "could": true, "did": true, "does": true, "for": true, "from": true, "get": true, "had": true,
+	"has": true, "have": true, "how": true, "just": true, "like": true, "not": true, "now": true, "one": true,
+	"only": true, "our": true, "out": true, "should": true, "some": true, "than": true, "that": true, "the": true,
+	"their": true, "them": true, "then": true, "there": true, "these": true, "they": true, "this": true,
+	"was": true, "what": true, "when": true, "where": true, "which": true, "who": true, "why": true, "will": true,
+	"with": true, "would": true, "you": true, "your": true,
+}
+
+func init() {
+	registerInboxCommand(InboxCommand{
+		Name: "similar",
+		Help: "Finds earlier posts like the one you comment on.",
+		Run:  (*Client).SimilarCommand,
+	})
+}
+
+// SimilarCommand replies with the earlier posts most similar to the post the mention was commented on.
+func (c *Client) SimilarCommand(m *reddit.Message, arguments []string) *ContextError {
+	if !m.IsComment {
+		return c.replyCouldNotParse(m, "I can only find posts similar to one you comment on.")
+	}
+
+	post, ce := c.getParentPost(m)
+	if ce != nil {
+		return ce
+	}
+
+	parent := PushshiftSubmission{
+		PushshiftFields: PushshiftFields{
+			ID:          post.ID,
+			Title:       post.Title,
+			DateCreated: float64(post.Created.Unix()),
+		},
+		Raw: map[string]interface{}{
+			"selftext": post.Body,
+			"url":      post.URL,
+		},
+	}
+
+	candidates, ce := c.similarCandidates(parent, post.FullID)
+	if ce != nil {
+		return ce
+	}
+
+	scores := c.Search.similarity(parent, candidates)
+	minScore := c.Config.Similar.minScore()
+	similarIDs := make([]string, 0, len(scores))
+	for fullID, score := range scores {
+		if score >= minScore {
+			similarIDs = append(similarIDs, fullID)
+		}
+	}
+
+	sort.Slice(similarIDs, func(i, j int) bool {
+		a, b := scores[similarIDs[i]], scores[similarIDs[j]]
+		if a == b {
+			return candidates[similarIDs[i]].DateCreated > candidates[similarIDs[j]].DateCreated
+		}
+
+		return a > b
+	})
+
+	unremovedIDs, ce := c.Archive.getUnremoved(similarIDs)
+	if ce != nil {
+		return ce
+	}
+
+	if len(unremovedIDs) == 0 {
+		return c.replyTemplate(m, ReplyNoSimilar, ReplyData{})
+	}
+
+	if limit := c.Config.Similar.limit(); len(unremovedIDs) > limit {
+		unremovedIDs = unremovedIDs[:limit]
+	}
+
+	linkMap, ce := c.Archive.getLinks(unremovedIDs)
+	if ce != nil {
+		return ce
+	}
+
+	links := make([]string, 0, len(unremovedIDs))
+	for _, fullID := range unremovedIDs {
+		links = append(links, fmt.Sprintf("- %s (%d%% similar)", linkMap[fullID], int(math.Round(scores[fullID]*100))))
+	}
+
+	data := ReplyData{Results: len(links), Total: len(links)}
+	header, ce := c.renderReply(ReplySimilarResults, m, data)
+	if ce != nil {
+		return ce
+	}
+
+	footer, ce := c.footer(m)
+	if ce != nil {
+		return ce
+	}
+
+	return c.replyWith(m, Reply{
+		Header:    header + "\n\n",
+		Items:     links,
+		Separator: "\n\n",
+		Footer:    footer,
+	})
+}
+
+// getParentPost fetches the post a comment was made on, which for a reply to another comment is looked up through it.
+func (c *Client) getParentPost(m *reddit.Message) (*reddit.Post, *ContextError) {
+	postID := m.ParentID
+	if strings.HasPrefix(postID, "t1_") {
+		_, comments, _, _, err := c.Reddit.Listings.Get(ctx, postID)
+		if err != nil {
+			return nil, NewWrappedError("getting parent comment", err, []ContextParam{
+				{"Comment ID", m.FullID},
+				{"Parent ID", postID},
+			})
+		}
+
+		if len(comments) == 0 {
+			return nil, NewContextError(ErrNoParentPost, []ContextParam{
+				{"Comment ID", m.FullID},
+				{"Parent ID", postID},
+			})
+		}
+
+		postID = comments[0].PostID
+	}
+
+	posts, _, err := c.Reddit.Listings.GetPosts(ctx, postID)
+	if err != nil {
+		return nil, NewWrappedError("getting parent post", err, []ContextParam{
+			{"Comment ID", m.FullID},
+			{"Post ID", postID},
+		})
+	}
+
+	if len(posts) == 0 || posts[0].Created == nil {
+		return nil, NewContextError(ErrNoParentPost, []ContextParam{
+			{"Comment ID", m.FullID},
+			{"Post ID", postID},
+		})
+	}
+
+	return posts[0], nil
+}
+
+// similarCandidates returns the archived submissions created before the parent which share a search term or a title word with it.
+// When there are more than Similar.candidates the newest are kept.
+func (c *Client) similarCandidates(parent PushshiftSubmission, parentID string) (map[string]PushshiftSubmission, *ContextError) {
+	earlier := timeBefore(parent.DateCreated)
+	epochs := make(map[string]float64)
+	addCandidates := func(set []redis.Z) {
+		for _, z := range set {
+			if fullID := z.Member.(string); fullID != parentID {
+				epochs[fullID] = z.Score
+			}
+		}
+	}
+
+	for _, search := range c.Search.getSubmissionMatches(parent) {
+		set, ce := c.Archive.getSearch(search, earlier)
+		if ce != nil {
+			return nil, ce
+		}
+
+		addCandidates(set)
+	}
+
+	if textSearcher, ok := c.Archive.(TextSearcher); ok {
+		searched := make(map[string]bool)
+		for _, word := range similarWords(c.Search.normalize(parent.Title)) {
+			if searched[word] {
+				continue
+			} else if len(searched) == SimilarTextTerms {
+				break
+			}
+
+			searched[word] = true
+			set, ce := textSearcher.searchText(word, c.Config.Similar.candidates(), earlier)
+			if ce != nil {
+				return nil, ce
+			}
+
+			addCandidates(set)
+		}
+	}
+
+	fullIDs := make([]string, 0, len(epochs))
+	for fullID := range epochs {
+		fullIDs = append(fullIDs, fullID)
+	}
+
+	if max := c.Config.Similar.candidates(); len(fullIDs) > max {
+		sort.Slice(fullIDs, func(i, j int) bool {
+			return epochs[fullIDs[i]] > epochs[fullIDs[j]]
+		})
+		fullIDs = fullIDs[:max]
+	}
+
+	return c.Archive.getSubmissions(fullIDs)
+}
+
+// similarity scores how similar each candidate is to the parent, from 0 to 1.
+// Submissions are compared by the cosine similarity of the TF-IDF of their title and selftext words, title words counting double.
+func (s *Search) similarity(parent PushshiftSubmission, candidates map[string]PushshiftSubmission) map[string]float64 {
+	termCounts := make(map[string]map[string]float64, len(candidates))
+	documentFrequency := make(map[string]float64)
+	for fullID, candidate := range candidates {
+		counts := s.similarTermCounts(candidate)
+		termCounts[fullID] = counts
+		for term := range counts {
+			documentFrequency[term]++
+		}
+	}
+
+	parentCounts := s.similarTermCounts(parent)
+	for term := range parentCounts {
+		documentFrequency[term]++
+	}
+
+	documents := float64(len(candidates) + 1)
+	vector := func(counts map[string]float64) (map[string]float64, float64) {
+		weights := make(map[string]float64, len(counts))
+		var norm float64
+		for term, count := range counts {
+			weight := (1 + math.Log(count)) * (1 + math.Log(documents/documentFrequency[term]))
+			weights[term] = weight
+			norm += weight * weight
+		}
+
+		return weights, math.Sqrt(norm)
+	}
+
+	parentWeights, parentNorm := vector(parentCounts)
+	scores := make(map[string]float64, len(candidates))
+	if parentNorm == 0 {
+		return scores
+	}
+
+	for fullID, counts := range termCounts {
+		weights, norm := vector(counts)
+		if norm == 0 {
+			continue
+		}
+
+		var dot float64
+		for term, weight := range weights {
+			dot += weight * parentWeights[term]
+		}
+
+		scores[fullID] = dot / (norm * parentNorm)
+	}
+
+	return scores
+}
+
+// similarTermCounts counts the words of the submission's title and selftext, title words counting double.
+func (s *Search) similarTermCounts(submission PushshiftSubmission) map[string]float64 {
+	counts := make(map[string]float64)
+	for _, word := range similarWords(s.normalize(submission.Title)) {
+		counts[word] += 2
+	}
+
+	selftext, _ := submission.Raw["selftext"].(string)
+	for _, word := range similarWords(s.normalize(selftext)) {
+		counts[word]++
+	}
+
+	return counts
+}
+
+// similarWords splits normalized text into the words worth comparing, in order.
+func similarWords(text string) []string {
+	var words []string
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool { return !isWordRune(r) }) {
+		if len([]rune(word)) >= SimilarMinWordLength && !similarStopWords[word] {
+			words = append(words, word)
+		}
+	}
+
+	return words
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestSimilarWords(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"how do i sort a list in python?", []string{"sort", "list", "python"}},
+		{"what's the difference between c++ and c#", []string{"difference", "between"}},
+		{"django-rest-framework: post vs put", []string{"django", "rest", "framework", "post", "put"}},
+		{"", nil},
+	}
+
+	for _, test := range tests {
+		if got := similarWords(test.text); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("similarWords(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	c := newTestClient(t, StorageRedis, testConstants)
+	parent := testSubmission("p", "Sorting a list of dictionaries in Python", "", 1, 5000)
+	candidates := map[string]PushshiftSubmission{
+		"t3_same":    testSubmission("same", "Sorting a list of dictionaries in Python", "", 1, 1000),
+		"t3_close":   testSubmission("close", "Sorting dictionaries by value", "", 1, 1000),
+		"t3_far":     testSubmission("far", "Python web frameworks", "", 1, 1000),
+		"t3_none":    testSubmission("none", "Rust borrow checker errors", "", 1, 1000),
+		"t3_nothing": testSubmission("nothing", "Why?", "", 1, 1000),
+	}
+
+	scores := c.Search.similarity(parent, candidates)
+	if math.Abs(scores["t3_same"]-1) > 1e-9 {
+		t.Errorf("similarity of the same title = %g, want 1", scores["t3_same"])
+	}
+
+	if !(scores["t3_same"] > scores["t3_close"] && scores["t3_close"] > scores["t3_far"] && scores["t3_far"] > 0) {
+		t.Errorf("similarity = %v, want same > close > far > 0", scores)
+	}
+
+	if score, ok := scores["t3_none"]; !ok || score != 0 {
+		t.Errorf("similarity of an unrelated title = %g, %v, want 0", score, ok)
+	}
+
+	if _, ok := scores["t3_nothing"]; ok {
+		t.Errorf("a title without words to compare was scored %g", scores["t3_nothing"])
+	}
+
+	// Title words count double, so sharing the title is closer than sharing the selftext.
+	parent.Raw["selftext"] = "rust"
+	scores = c.Search.similarity(parent, candidates)
+	if scores["t3_close"] <= scores["t3_none"] {
+		t.Errorf("similarity = %v, want a shared title word to outweigh a shared selftext word", scores)
+	}
+}
+
+func TestSimilarCandidates(t *testing.T) {
+	forEachBackend(t, testConstants, func(t *testing.T, c *Client) {
+		if err := c.Archive.addSubmissions(testSubmissions); err != nil {
+			t.Fatal(err)
+		}
+
+		// Only SQLite searches the text, finding the unrelated post by its title word.
+		_, searchesText := c.Archive.(TextSearcher)
+		tests := []struct {
+			title   string
+			created float64
+			want    []string
+			text    []string
+		}{
+			{"Django unrelated question", 3500, []string{"t3_c"}, []string{"t3_b", "t3_c"}},
+			{"Django unrelated question", 2500, nil, []string{"t3_b"}},
+			{"Learning Python again", 3500, []string{"t3_a", "t3_c"}, []string{"t3_a", "t3_c"}},
+			{"Learning Python", 1000, nil, nil},
+		}
+
+		for _, test := range tests {
+			parent := testSubmission("p", test.title, "", 1, test.created)
+			candidates, ce := c.similarCandidates(parent, "t3_p")
+			if ce != nil {
+				t.Fatal(ce)
+			}
+
+			got := make([]string, 0, len(candidates))
+			for fullID := range candidates {
+				got = append(got, fullID)
+			}
+			sort.Strings(got)
+
+			want := test.want
+			if searchesText {
+				want = test.text
+			}
+
+			if len(got) != len(want) || len(got) != 0 && !reflect.DeepEqual(got, want) {
+				t.Errorf("similarCandidates(%q at %g) = %q, want %q", test.title, test.created, got, want)
+			}
+		}
+
+		// Only the newest candidates are compared.
+		c.Config.Similar.Candidates = 1
+		candidates, ce := c.similarCandidates(testSubmission("p", "Python", "", 1, 5000), "t3_p")
+		if ce != nil {
+			t.Fatal(ce)
+		}
+
+		if _, ok := candidates["t3_c"]; len(candidates) != 1 || !ok {
+			t.Errorf("similarCandidates limited to 1 = %v, want t3_c", candidates)
+		}
+	})
+}
@@ -0,0 +1,1086 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/vartanbeno/go-reddit/reddit"
+
+	// Registers the "sqlite" driver, which is built with FTS5.
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteMaxVariables is the most bound parameters used in a single statement.
+const SQLiteMaxVariables = 500
+
+// sqliteSchema creates every table used by the archive.
+// Submission IDs are full IDs, e.g. t3_abcdef, to match the Redis keys.
+var sqliteSchema = []string{
+	`CREATE TABLE IF NOT EXISTS flairs (
+		id INTEGER PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE
+	)`,
+	`CREATE TABLE IF NOT EXISTS submissions (
+		id TEXT PRIMARY KEY,
+		title TEXT NOT NULL,
+		permalink TEXT NOT NULL,
+		created_utc REAL NOT NULL,
+		flair_id INTEGER NOT NULL REFERENCES flairs(id),
+		ups INTEGER NOT NULL DEFAULT 0,
+		removed INTEGER,
+		raw BLOB NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS submissions_created_utc ON submissions(created_utc)`,
+	`CREATE INDEX IF NOT EXISTS submissions_flair_id ON submissions(flair_id, created_utc)`,
+	`CREATE TABLE IF NOT EXISTS search_terms (
+		term TEXT NOT NULL,
+		submission_id TEXT NOT NULL REFERENCES submissions(id),
+		PRIMARY KEY (term, submission_id)
+	) WITHOUT ROWID`,
+	`CREATE TABLE IF NOT EXISTS authors (
+		author TEXT NOT NULL,
+		submission_id TEXT NOT NULL REFERENCES submissions(id),
+		PRIMARY KEY (author, submission_id)
+	) WITHOUT ROWID`,
+	`CREATE TABLE IF NOT EXISTS domains (
+		domain TEXT NOT NULL,
+		submission_id TEXT NOT NULL REFERENCES submissions(id),
+		PRIMARY KEY (domain, submission_id)
+	) WITHOUT ROWID`,
+	`CREATE TABLE IF NOT EXISTS scores (
+		submission_id TEXT NOT NULL REFERENCES submissions(id),
+		observed_utc INTEGER NOT NULL,
+		ups INTEGER NOT NULL,
+		PRIMARY KEY (submission_id, observed_utc)
+	) WITHOUT ROWID`,
+	`CREATE TABLE IF NOT EXISTS processed (
+		full_id TEXT PRIMARY KEY,
+		processed_utc INTEGER NOT NULL
+	) WITHOUT ROWID`,
+	`CREATE TABLE IF NOT EXISTS replies (
+		full_id TEXT PRIMARY KEY,
+		reply_id TEXT NOT NULL,
+		updated_utc INTEGER NOT NULL
+	) WITHOUT ROWID`,
+	`CREATE TABLE IF NOT EXISTS reply_commands (
+		reply_id TEXT PRIMARY KEY,
+		command TEXT NOT NULL
+	) WITHOUT ROWID`,
+	`CREATE TABLE IF NOT EXISTS subscriptions (
+		user_key TEXT NOT NULL,
+		query TEXT NOT NULL,
+		subscription TEXT NOT NULL,
+		PRIMARY KEY (user_key, query)
+	) WITHOUT ROWID`,
+	`CREATE TABLE IF NOT EXISTS stats (
+		scope TEXT NOT NULL,
+		field TEXT NOT NULL,
+		value REAL NOT NULL,
+		PRIMARY KEY (scope, field)
+	) WITHOUT ROWID`,
+	`CREATE TABLE IF NOT EXISTS stats_counts (
+		full_id TEXT PRIMARY KEY,
+		count TEXT NOT NULL
+	) WITHOUT ROWID`,
+	`CREATE TABLE IF NOT EXISTS anchors (
+		key TEXT PRIMARY KEY,
+		full_id TEXT NOT NULL,
+		epoch INTEGER NOT NULL
+	) WITHOUT ROWID`,
+	`CREATE VIRTUAL TABLE IF NOT EXISTS submissions_fts USING fts5(
+		id UNINDEXED,
+		title,
+		selftext
+	)`,
+}
+
+// SQLite is an archive stored in a SQLite database.
+type SQLite struct {
+	*sql.DB
+	client *Client
+	config *Config
+}
+
+// NewSQLite opens the SQLite database, creating the schema if needed.
+func NewSQLite(client *Client, config *Config) (*SQLite, error) {
+	path := config.Storage.SQLitePath
+	if path == "" {
+		path = "archive.db"
+	}
+
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s: %w", path, err)
+	}
+
+	for _, statement := range sqliteSchema {
+		if _, err := db.Exec(statement); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("could not create SQLite schema: %w", err)
+		}
+	}
+
+	return &SQLite{db, client, config}, nil
+}
+
+func (s *SQLite) addSubmissions(submissions []PushshiftSubmission) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		stats := make(ArchiveStats)
+		for _, submission := range submissions {
+			fullID := "t3_" + submission.ID
+
+			// A submission archived before is uncounted from the statistics as what it was counted as, as it's counted again.
+			var raw []byte
+			var removed sql.NullBool
+			var previous *StatsCount
+			err := tx.QueryRow(`SELECT raw, removed FROM submissions WHERE id = ?`, fullID).Scan(&raw, &removed)
+			if err == nil {
+				count, ok, err := getStatsCount(tx, fullID)
+				if err != nil {
+					return err
+				}
+
+				// Submissions archived before counts were stored are uncounted as archived.
+				if !ok {
+					var archived PushshiftSubmission
+					if err := archived.UnmarshalBinary(raw); err != nil {
+						return fmt.Errorf("could not decode %s: %w", fullID, err)
+					}
+
+					count = s.client.statsCount(archived)
+				}
+				previous = &count
+			} else if err != sql.ErrNoRows {
+				return fmt.Errorf("could not read submission %s: %w", fullID, err)
+			}
+
+			count := s.client.statsCount(submission)
+			stats.recount(previous, count, removed.Bool)
+			if err := setStatsCount(tx, fullID, count); err != nil {
+				return err
+			}
+
+			if err := s.insertSubmission(tx, submission); err != nil {
+				return err
+			}
+
+			for _, match := range s.client.Search.getSubmissionMatches(submission) {
+				if _, err := tx.Exec(`INSERT OR IGNORE INTO search_terms (term, submission_id) VALUES (?, ?)`, match, fullID); err != nil {
+					return fmt.Errorf("could not add search term %s: %w", match, err)
+				}
+			}
+
+			if err := s.insertScore(tx, fullID, submission.Ups); err != nil {
+				return err
+			}
+		}
+
+		if err := addStats(tx, stats); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(`INSERT INTO stats (scope, field, value) VALUES (?, ?, ?)
+			ON CONFLICT (scope, field) DO UPDATE SET value = excluded.value`, StatsScopeAll, StatsUpdated, time.Now().Unix())
+		if err != nil {
+			return fmt.Errorf("could not set when submissions were last archived: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// getStatsCount returns what the submission is counted as in the statistics, or false if no count is stored.
+func getStatsCount(tx *sql.Tx, fullID string) (StatsCount, bool, error) {
+	var count StatsCount
+	var data []byte
+	err := tx.QueryRow(`SELECT count FROM stats_counts WHERE full_id = ?`, fullID).Scan(&data)
+	if err == sql.ErrNoRows {
+		return count, false, nil
+	} else if err != nil {
+		return count, false, fmt.Errorf("could not read the statistics count of %s: %w", fullID, err)
+	}
+
+	if err := count.UnmarshalBinary(data); err != nil {
+		return count, false, fmt.Errorf("could not decode the statistics count of %s: %w", fullID, err)
+	}
+
+	return count, true, nil
+}
+
+// setStatsCount stores what the submission is counted as in the statistics.
+func setStatsCount(tx *sql.Tx, fullID string, count StatsCount) error {
+	data, err := count.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO stats_counts (full_id, count) VALUES (?, ?)
+		ON CONFLICT (full_id) DO UPDATE SET count = excluded.count`, fullID, string(data))
+	if err != nil {
+		return fmt.Errorf("could not set the statistics count of %s: %w", fullID, err)
+	}
+
+	return nil
+}
+
+// addStats adds the changes to the statistics of each scope.
+func addStats(tx *sql.Tx, stats ArchiveStats) error {
+	for scope, fields := range stats {
+		for field, value := range fields {
+			_, err := tx.Exec(`INSERT INTO stats (scope, field, value) VALUES (?, ?, ?)
+				ON CONFLICT (scope, field) DO UPDATE SET value = value + excluded.value`, scope, field, value)
+			if err != nil {
+				return fmt.Errorf("could not update statistic %s of %s: %w", field, scope, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *SQLite) getStats(scope string) (map[string]float64, *ContextError) {
+	rows, err := s.Query(`SELECT field, value FROM stats WHERE scope = ?`, scope)
+	if err != nil {
+		return nil, NewWrappedError("could not read statistics", err, []ContextParam{
+			{"Scope", scope},
+		})
+	}
+	defer rows.Close()
+
+	stats := make(map[string]float64)
+	for rows.Next() {
+		var field string
+		var value float64
+		if err := rows.Scan(&field, &value); err != nil {
+			return nil, NewContextlessError(err)
+		}
+
+		stats[field] = value
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, NewContextlessError(err)
+	}
+
+	return stats, nil
+}
+
+func (s *SQLite) replaceStats(stats ArchiveStats, counts map[string]StatsCount) *ContextError {
+	err := s.transaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM stats`); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(`DELETE FROM stats_counts`); err != nil {
+			return err
+		}
+
+		for fullID, count := range counts {
+			if err := setStatsCount(tx, fullID, count); err != nil {
+				return err
+			}
+		}
+
+		return addStats(tx, stats)
+	})
+	if err != nil {
+		return NewWrappedError("could not replace statistics", err, nil)
+	}
+
+	return nil
+}
+
+// insertSubmission adds or updates the submission along with its flair and full text index, keeping its removal state.
+func (s *SQLite) insertSubmission(tx *sql.Tx, submission PushshiftSubmission) error {
+	fullID := "t3_" + submission.ID
+
+	flair := s.client.flairName(submission.LinkFlairText)
+	if _, err := tx.Exec(`INSERT OR IGNORE INTO flairs (name) VALUES (?)`, flair); err != nil {
+		return fmt.Errorf("could not add flair %s: %w", flair, err)
+	}
+
+	raw, err := submission.Compact(s.config.Storage.storedFields()).MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("could not encode %s: %w", fullID, err)
+	}
+
+	_, err = tx.Exec(`INSERT INTO submissions (id, title, permalink, created_utc, flair_id, ups, raw)
+		VALUES (?, ?, ?, ?, (SELECT id FROM flairs WHERE name = ?), ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			title = excluded.title,
+			permalink = excluded.permalink,
+			created_utc = excluded.created_utc,
+			flair_id = excluded.flair_id,
+			ups = excluded.ups,
+			raw = excluded.raw`,
+		fullID, submission.Title, submission.Permalink, submission.DateCreated, flair, submission.Ups, raw)
+	if err != nil {
+		return fmt.Errorf("could not set submission %s: %w", fullID, err)
+	}
+
+	if err := insertIndexes(tx, submission); err != nil {
+		return err
+	}
+
+	// The text is indexed normalized, like queries are, see searchText.
+	search := s.client.Search
+	selftext, _ := submission.Raw["selftext"].(string)
+	if _, err := tx.Exec(`DELETE FROM submissions_fts WHERE id = ?`, fullID); err != nil {
+		return fmt.Errorf("could not clear text index of %s: %w", fullID, err)
+	}
+
+	if _, err := tx.Exec(`INSERT INTO submissions_fts (id, title, selftext) VALUES (?, ?, ?)`, fullID, search.normalize(submission.Title), search.normalize(selftext)); err != nil {
+		return fmt.Errorf("could not index text of %s: %w", fullID, err)
+	}
+
+	return nil
+}
+
+// insertIndexes indexes the author and domains of the submission, if they're known.
+func insertIndexes(tx *sql.Tx, submission PushshiftSubmission) error {
+	fullID := "t3_" + submission.ID
+	if author := submission.author(); author != "" {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO authors (author, submission_id) VALUES (?, ?)`, author, fullID); err != nil {
+			return fmt.Errorf("could not add author %s: %w", author, err)
+		}
+	}
+
+	for _, domain := range submission.domains() {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO domains (domain, submission_id) VALUES (?, ?)`, domain, fullID); err != nil {
+			return fmt.Errorf("could not add domain %s: %w", domain, err)
+		}
+	}
+
+	return nil
+}
+
+// insertScore records the current upvotes of a submission in its score history.
+func (s *SQLite) insertScore(tx *sql.Tx, fullID string, ups int) error {
+	if _, err := tx.Exec(`UPDATE submissions SET ups = ? WHERE id = ?`, ups, fullID); err != nil {
+		return fmt.Errorf("could not update upvotes of %s: %w", fullID, err)
+	}
+
+	_, err := tx.Exec(`INSERT OR REPLACE INTO scores (submission_id, observed_utc, ups) VALUES (?, ?, ?)`, fullID, time.Now().Unix(), ups)
+	if err != nil {
+		return fmt.Errorf("could not add score history of %s: %w", fullID, err)
+	}
+
+	return nil
+}
+
+func (s *SQLite) updateVotes(submissions []PushshiftSubmission) *ContextError {
+	err := s.transaction(func(tx *sql.Tx) error {
+		for _, submission := range submissions {
+			if err := s.insertScore(tx, "t3_"+submission.ID, submission.Ups); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return NewWrappedError("could not update submission upvotes", err, nil)
+	}
+
+	return nil
+}
+
+func (s *SQLite) setRemoved(submissions []PushshiftSubmission, removedMap map[string]bool) *ContextError {
+	err := s.transaction(func(tx *sql.Tx) error {
+		stats := make(ArchiveStats)
+		for _, submission := range submissions {
+			fullID := "t3_" + submission.ID
+			isRemoved := removedMap[submission.ID]
+
+			// Submissions found removed or restored are counted in the statistics, unknown ones weren't counted as either.
+			var wasRemoved sql.NullBool
+			err := tx.QueryRow(`SELECT removed FROM submissions WHERE id = ?`, fullID).Scan(&wasRemoved)
+			if err == sql.ErrNoRows {
+				continue
+			} else if err != nil {
+				return fmt.Errorf("could not read removal of %s: %w", fullID, err)
+			}
+
+			if isRemoved != wasRemoved.Bool {
+				count, ok, err := getStatsCount(tx, fullID)
+				if err != nil {
+					return err
+				} else if !ok {
+					count = s.client.statsCount(submission)
+				}
+
+				sign := 1.0
+				if !isRemoved {
+					sign = -1
+				}
+				stats.addRemoval(count.FlairKey, count.Searches, sign)
+			}
+
+			if _, err := tx.Exec(`UPDATE submissions SET removed = ? WHERE id = ?`, isRemoved, fullID); err != nil {
+				return fmt.Errorf("could not set removal of %s: %w", fullID, err)
+			}
+		}
+
+		return addStats(tx, stats)
+	})
+	if err != nil {
+		return NewWrappedError("could not update removed submissions", err, nil)
+	}
+
+	return nil
+}
+
+func (s *SQLite) getSubmissionIDs() ([]string, *ContextError) {
+	rows, err := s.Query(`SELECT id FROM submissions`)
+	if err != nil {
+		return nil, NewWrappedError("error in reading submissions", err, nil)
+	}
+
+	return scanStrings(rows)
+}
+
+func (s *SQLite) getSearch(search string, window TimeRange) ([]redis.Z, *ContextError) {
+	after, before := window.bounds()
+	return s.getZSet(`SELECT s.id, s.created_utc FROM search_terms t
+		JOIN submissions s ON s.id = t.submission_id
+		WHERE t.term = ? AND s.created_utc >= ? AND s.created_utc < ?
+		ORDER BY s.created_utc`, search, after, before)
+}
+
+// getFlair reads the submissions of every flair with the key, as flairs are stored as shown.
+func (s *SQLite) getFlair(flairKey string, window TimeRange) ([]redis.Z, *ContextError) {
+	flairIDs, ce := s.getFlairIDs()
+	if ce != nil {
+		return nil, ce
+	}
+
+	after, before := window.bounds()
+	args := []interface{}{after, before}
+	for name, id := range flairIDs {
+		if s.client.flairKey(name) == flairKey {
+			args = append(args, id)
+		}
+	}
+
+	if len(args) == 2 {
+		return nil, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(args)-2), ", ")
+	zset, ce := s.getZSet(`SELECT id, created_utc FROM submissions
+		WHERE created_utc >= ? AND created_utc < ? AND flair_id IN (`+placeholders+`)
+		ORDER BY created_utc`, args...)
+	if ce != nil {
+		ce.AddContext("Flair Key", flairKey)
+	}
+
+	return zset, ce
+}
+
+// getTopUpvoted ranks the known unremoved submissions by their last known upvotes.
+func (s *SQLite) getTopUpvoted(filter TopFilter, window TimeRange, limit int) ([]redis.Z, *ContextError) {
+	after, before := window.bounds()
+	args := []interface{}{after, before}
+
+	var conditions []string
+	for _, search := range filter.Searches {
+		args = append(args, search)
+		conditions = append(conditions, `id IN (SELECT submission_id FROM search_terms WHERE term = ?)`)
+	}
+
+	if len(filter.FlairKeys) != 0 {
+		flairIDs, ce := s.getFlairIDs()
+		if ce != nil {
+			return nil, ce
+		}
+
+		var ids []interface{}
+		for name, id := range flairIDs {
+			flairKey := s.client.flairKey(name)
+			for _, key := range filter.FlairKeys {
+				if flairKey == key {
+					ids = append(ids, id)
+					break
+				}
+			}
+		}
+
+		if len(ids) != 0 {
+			args = append(args, ids...)
+			placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+			conditions = append(conditions, `flair_id IN (`+placeholders+`)`)
+		} else {
+			return nil, nil
+		}
+	}
+
+	query := `SELECT id, ups FROM submissions WHERE removed IS NOT 1 AND created_utc >= ? AND created_utc < ?`
+	if len(conditions) != 0 {
+		query += ` AND ` + strings.Join(conditions, ` AND `)
+	}
+
+	return s.getZSet(query+` ORDER BY ups DESC, created_utc DESC LIMIT ?`, append(args, limit)...)
+}
+
+// getFlairIDs returns a map of every flair name to its row ID.
+func (s *SQLite) getFlairIDs() (map[string]int64, *ContextError) {
+	rows, err := s.Query(`SELECT id, name FROM flairs`)
+	if err != nil {
+		return nil, NewWrappedError("error in reading flairs", err, nil)
+	}
+	defer rows.Close()
+
+	flairIDs := make(map[string]int64)
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, NewContextlessError(err)
+		}
+
+		flairIDs[name] = id
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, NewContextlessError(err)
+	}
+
+	return flairIDs, nil
+}
+
+// mergeFlairs moves the submissions of flairs with the same key to one of them, renaming it as shown after renames.
+func (s *SQLite) mergeFlairs() *ContextError {
+	flairIDs, ce := s.getFlairIDs()
+	if ce != nil {
+		return ce
+	}
+
+	// The flair kept of those with the same key is the one preferred to be shown.
+	names := make([]string, 0, len(flairIDs))
+	for name := range flairIDs {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return s.client.preferFlairName(names[i], names[j])
+	})
+
+	targets := make(map[string]string)
+	merged := make(map[string]string)
+	err := s.transaction(func(tx *sql.Tx) error {
+		for _, name := range names {
+			key := s.client.flairKey(name)
+			target, ok := targets[key]
+			if !ok {
+				targets[key] = name
+				continue
+			}
+
+			if _, err := tx.Exec(`UPDATE submissions SET flair_id = ? WHERE flair_id = ?`, flairIDs[target], flairIDs[name]); err != nil {
+				return fmt.Errorf("could not merge flair %s: %w", name, err)
+			}
+
+			if _, err := tx.Exec(`DELETE FROM flairs WHERE id = ?`, flairIDs[name]); err != nil {
+				return fmt.Errorf("could not remove flair %s: %w", name, err)
+			}
+
+			merged[name] = target
+		}
+
+		// Only one flair of each key is left, so renaming them can't collide.
+		for _, name := range targets {
+			if renamed := s.client.flairName(name); renamed != name {
+				if _, err := tx.Exec(`UPDATE flairs SET name = ? WHERE id = ?`, renamed, flairIDs[name]); err != nil {
+					return fmt.Errorf("could not rename flair %s: %w", name, err)
+				}
+
+				merged[name] = renamed
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return NewWrappedError("could not merge flairs", err, nil)
+	}
+
+	s.client.logMergedFlairs(merged)
+	return nil
+}
+
+func (s *SQLite) getFlairNames() ([]string, *ContextError) {
+	rows, err := s.Query(`SELECT name FROM flairs WHERE name != ''`)
+	if err != nil {
+		return nil, NewWrappedError("error in reading flairs", err, nil)
+	}
+
+	return scanStrings(rows)
+}
+
+// getFlairStats counts the submissions of each flair, combining flairs with the same key which weren't merged yet.
+func (s *SQLite) getFlairStats() ([]FlairStats, *ContextError) {
+	rows, err := s.Query(`SELECT f.name, COUNT(*), MIN(s.created_utc), MAX(s.created_utc) FROM submissions s
+		JOIN flairs f ON f.id = s.flair_id
+		GROUP BY f.id`)
+	if err != nil {
+		return nil, NewWrappedError("error in counting flairs", err, nil)
+	}
+	defer rows.Close()
+
+	var stats []FlairStats
+	for rows.Next() {
+		var flair FlairStats
+		if err := rows.Scan(&flair.Name, &flair.Count, &flair.First, &flair.Last); err != nil {
+			return nil, NewContextlessError(err)
+		}
+
+		flair.Key = s.client.flairKey(flair.Name)
+		stats = append(stats, flair)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, NewContextlessError(err)
+	}
+
+	return s.client.combineFlairStats(stats), nil
+}
+
+func (s *SQLite) getAuthor(author string, window TimeRange) ([]redis.Z, *ContextError) {
+	after, before := window.bounds()
+	return s.getZSet(`SELECT s.id, s.created_utc FROM authors a
+		JOIN submissions s ON s.id = a.submission_id
+		WHERE a.author = ? AND s.created_utc >= ? AND s.created_utc < ?
+		ORDER BY s.created_utc`, strings.ToLower(author), after, before)
+}
+
+func (s *SQLite) getDomain(domain string, window TimeRange) ([]redis.Z, *ContextError) {
+	domains := domainAndParents(domain)
+	if len(domains) == 0 {
+		return nil, nil
+	}
+
+	after, before := window.bounds()
+	return s.getZSet(`SELECT s.id, s.created_utc FROM domains d
+		JOIN submissions s ON s.id = d.submission_id
+		WHERE d.domain = ? AND s.created_utc >= ? AND s.created_utc < ?
+		ORDER BY s.created_utc`, domains[0], after, before)
+}
+
+func (s *SQLite) getUpvotes(fullIDs []string) (map[string]float64, *ContextError) {
+	upvotes := make(map[string]float64, len(fullIDs))
+	err := s.forEachBatch(fullIDs, `SELECT id, ups FROM submissions WHERE id IN (%s)`, func(rows *sql.Rows) error {
+		var id string
+		var ups float64
+		if err := rows.Scan(&id, &ups); err != nil {
+			return err
+		}
+
+		upvotes[id] = ups
+		return nil
+	})
+	if err != nil {
+		return nil, NewWrappedError("could not read upvotes", err, []ContextParam{
+			{"IDs", fmt.Sprint(fullIDs)},
+		})
+	}
+
+	return upvotes, nil
+}
+
+// getZSet reads rows of (full ID, score) like a Redis sorted set.
+// The first argument names the set in errors.
+func (s *SQLite) getZSet(query string, args ...interface{}) ([]redis.Z, *ContextError) {
+	rows, err := s.Query(query, args...)
+	if err != nil {
+		return nil, NewContextError(err, []ContextParam{
+			{"Set Name", fmt.Sprint(args[0])},
+		})
+	}
+	defer rows.Close()
+
+	var results []redis.Z
+	for rows.Next() {
+		var member string
+		var score float64
+		if err := rows.Scan(&member, &score); err != nil {
+			return nil, NewContextlessError(err)
+		}
+
+		results = append(results, redis.Z{Score: score, Member: member})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, NewContextlessError(err)
+	}
+
+	return results, nil
+}
+
+func (s *SQLite) getSubmissions(fullIDs []string) (map[string]PushshiftSubmission, *ContextError) {
+	submissions := make(map[string]PushshiftSubmission, len(fullIDs))
+	err := s.forEachBatch(fullIDs, `SELECT id, raw FROM submissions WHERE id IN (%s)`, func(rows *sql.Rows) error {
+		var id string
+		var raw []byte
+		if err := rows.Scan(&id, &raw); err != nil {
+			return err
+		}
+
+		var submission PushshiftSubmission
+		if err := submission.UnmarshalBinary(raw); err != nil {
+			return fmt.Errorf("could not decode %s: %w", id, err)
+		}
+
+		submissions[id] = submission
+		return nil
+	})
+	if err != nil {
+		return nil, NewWrappedError("could not read submissions", err, []ContextParam{
+			{"IDs", fmt.Sprint(fullIDs)},
+		})
+	}
+
+	return submissions, nil
+}
+
+func (s *SQLite) getLinks(fullIDs []string) (map[string]string, *ContextError) {
+	links := make(map[string]string, len(fullIDs))
+	err := s.forEachBatch(fullIDs, `SELECT id, title, permalink FROM submissions WHERE id IN (%s)`, func(rows *sql.Rows) error {
+		var id, title, permalink string
+		if err := rows.Scan(&id, &title, &permalink); err != nil {
+			return err
+		}
+
+		links[id] = markdownLink(title, permalink)
+		return nil
+	})
+	if err != nil {
+		return nil, NewWrappedError("could not read links", err, []ContextParam{
+			{"IDs", fmt.Sprint(fullIDs)},
+		})
+	}
+
+	return links, nil
+}
+
+func (s *SQLite) getUnremoved(fullIDs []string) ([]string, *ContextError) {
+	isUnremoved := make(map[string]struct{}, len(fullIDs))
+	err := s.forEachBatch(fullIDs, `SELECT id FROM submissions WHERE removed IS NOT 1 AND id IN (%s)`, func(rows *sql.Rows) error {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+
+		isUnremoved[id] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return nil, NewWrappedError("could not read removed submissions", err, []ContextParam{
+			{"IDs", fmt.Sprint(fullIDs)},
+		})
+	}
+
+	// The order of the given IDs is kept.
+	unremoved := make([]string, 0, len(isUnremoved))
+	for _, fullID := range fullIDs {
+		if _, ok := isUnremoved[fullID]; ok {
+			unremoved = append(unremoved, fullID)
+		}
+	}
+
+	return unremoved, nil
+}
+
+func (s *SQLite) addProcessed(fullIDs []string) *ContextError {
+	now := time.Now().Unix()
+	err := s.transaction(func(tx *sql.Tx) error {
+		for _, fullID := range fullIDs {
+			if _, err := tx.Exec(`INSERT OR IGNORE INTO processed (full_id, processed_utc) VALUES (?, ?)`, fullID, now); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return NewWrappedError("could not add processed submissions to SQLite", err, []ContextParam{
+			{"IDs", fmt.Sprint(fullIDs)},
+		})
+	}
+
+	return nil
+}
+
+func (s *SQLite) getProcessed(fullIDs []string) (map[string]bool, *ContextError) {
+	processed := make(map[string]bool, len(fullIDs))
+	err := s.forEachBatch(fullIDs, `SELECT full_id FROM processed WHERE full_id IN (%s)`, func(rows *sql.Rows) error {
+		var fullID string
+		if err := rows.Scan(&fullID); err != nil {
+			return err
+		}
+
+		processed[fullID] = true
+		return nil
+	})
+	if err != nil {
+		return nil, NewWrappedError("could not read processed messages", err, []ContextParam{
+			{"IDs", fmt.Sprint(fullIDs)},
+		})
+	}
+
+	return processed, nil
+}
+
+func (s *SQLite) getReplies(fullIDs []string) (map[string]string, *ContextError) {
+	replies := make(map[string]string, len(fullIDs))
+	err := s.forEachBatch(fullIDs, `SELECT full_id, reply_id FROM replies WHERE full_id IN (%s)`, func(rows *sql.Rows) error {
+		var fullID, replyID string
+		if err := rows.Scan(&fullID, &replyID); err != nil {
+			return err
+		}
+
+		replies[fullID] = replyID
+		return nil
+	})
+	if err != nil {
+		return nil, NewWrappedError("could not read replies", err, []ContextParam{
+			{"IDs", fmt.Sprint(fullIDs)},
+		})
+	}
+
+	return replies, nil
+}
+
+func (s *SQLite) setReply(fullID string, replyID string) *ContextError {
+	_, err := s.Exec(`INSERT INTO replies (full_id, reply_id, updated_utc) VALUES (?, ?, ?)
+		ON CONFLICT (full_id) DO UPDATE SET reply_id = excluded.reply_id, updated_utc = excluded.updated_utc`,
+		fullID, replyID, time.Now().Unix())
+	if err != nil {
+		return NewWrappedError("could not record reply", err, []ContextParam{
+			{"ID", fullID},
+			{"Reply ID", replyID},
+		})
+	}
+
+	return nil
+}
+
+func (s *SQLite) getReplyCommand(replyID string) (string, *ContextError) {
+	var commandLine string
+	err := s.QueryRow(`SELECT command FROM reply_commands WHERE reply_id = ?`, replyID).Scan(&commandLine)
+	if err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", NewWrappedError("could not read reply command", err, []ContextParam{
+			{"Reply ID", replyID},
+		})
+	}
+
+	return commandLine, nil
+}
+
+func (s *SQLite) setReplyCommand(replyID string, commandLine string) *ContextError {
+	_, err := s.Exec(`INSERT INTO reply_commands (reply_id, command) VALUES (?, ?)
+		ON CONFLICT (reply_id) DO UPDATE SET command = excluded.command`, replyID, commandLine)
+	if err != nil {
+		return NewWrappedError("could not record reply command", err, []ContextParam{
+			{"Reply ID", replyID},
+			{"Command", commandLine},
+		})
+	}
+
+	return nil
+}
+
+func (s *SQLite) getSubscriptions() ([]Subscription, *ContextError) {
+	rows, err := s.Query(`SELECT subscription FROM subscriptions`)
+	if err != nil {
+		return nil, NewWrappedError("could not read subscriptions", err, nil)
+	}
+	defer rows.Close()
+
+	var subscriptions []Subscription
+	for rows.Next() {
+		var value []byte
+		if err := rows.Scan(&value); err != nil {
+			return nil, NewWrappedError("could not read subscription", err, nil)
+		}
+
+		var subscription Subscription
+		if err := subscription.UnmarshalBinary(value); err != nil {
+			return nil, NewWrappedError("could not decode subscription", err, []ContextParam{
+				{"Subscription", string(value)},
+			})
+		}
+
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, NewWrappedError("could not read subscriptions", err, nil)
+	}
+
+	return subscriptions, nil
+}
+
+func (s *SQLite) setSubscription(subscription Subscription) *ContextError {
+	value, err := subscription.MarshalBinary()
+	if err != nil {
+		return NewWrappedError("could not encode subscription", err, []ContextParam{
+			{"User", subscription.User},
+			{"Query", subscription.Query},
+		})
+	}
+
+	_, err = s.Exec(`INSERT INTO subscriptions (user_key, query, subscription) VALUES (?, ?, ?)
+		ON CONFLICT (user_key, query) DO UPDATE SET subscription = excluded.subscription`, strings.ToLower(subscription.User), subscription.Query, string(value))
+	if err != nil {
+		return NewWrappedError("could not record subscription", err, []ContextParam{
+			{"User", subscription.User},
+			{"Query", subscription.Query},
+		})
+	}
+
+	return nil
+}
+
+func (s *SQLite) removeSubscription(user string, query string) (bool, *ContextError) {
+	result, err := s.Exec(`DELETE FROM subscriptions WHERE user_key = ? AND query = ?`, strings.ToLower(user), query)
+	if err != nil {
+		return false, NewWrappedError("could not remove subscription", err, []ContextParam{
+			{"User", user},
+			{"Query", query},
+		})
+	}
+
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return false, NewWrappedError("could not remove subscription", err, []ContextParam{
+			{"User", user},
+			{"Query", query},
+		})
+	}
+
+	return removed != 0, nil
+}
+
+func (s *SQLite) getAnchor(anchorKey string) (*Anchor, *ContextError) {
+	var fullID string
+	var epoch int64
+	err := s.QueryRow(`SELECT full_id, epoch FROM anchors WHERE key = ?`, anchorKey).Scan(&fullID, &epoch)
+	if err == sql.ErrNoRows {
+		// A missing anchor is reported the same way as in Redis.
+		return nil, NewContextlessError(redis.Nil)
+	} else if err != nil {
+		return nil, NewWrappedError(fmt.Sprintf("could not get %s", anchorKey), err, nil)
+	}
+
+	return &Anchor{fullID, reddit.Timestamp{Time: time.Unix(epoch, 0)}}, nil
+}
+
+func (s *SQLite) setAnchor(anchorKey string, fullID string, timestamp reddit.Timestamp) *ContextError {
+	s.client.Logger.Infof("Setting %s to %s:%d.", anchorKey, fullID, timestamp.Unix())
+
+	_, err := s.Exec(`INSERT OR REPLACE INTO anchors (key, full_id, epoch) VALUES (?, ?, ?)`, anchorKey, fullID, timestamp.Unix())
+	if err != nil {
+		return NewContextError(fmt.Errorf("could not set %s: %w", anchorKey, err), []ContextParam{
+			{"Full ID", fullID},
+		})
+	}
+
+	return nil
+}
+
+func (s *SQLite) searchText(query string, limit int, window TimeRange) ([]redis.Z, *ContextError) {
+	// Every word is quoted so that FTS5 operators in user input are matched literally.
+	words := strings.Fields(s.client.Search.normalize(query))
+	for i, word := range words {
+		words[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+	}
+
+	if len(words) == 0 {
+		return nil, nil
+	}
+
+	after, before := window.bounds()
+	return s.getZSet(`SELECT f.id, s.created_utc FROM submissions_fts f
+		JOIN submissions s ON s.id = f.id
+		WHERE submissions_fts MATCH ? AND s.created_utc >= ? AND s.created_utc < ?
+		ORDER BY f.rank
+		LIMIT `+fmt.Sprint(limit), strings.Join(words, " "), after, before)
+}
+
+// transaction runs f in a transaction, committing only if it succeeds.
+func (s *SQLite) transaction(f func(tx *sql.Tx) error) error {
+	tx, err := s.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// forEachBatch runs the query with its single %s replaced by placeholders for up to SQLiteMaxVariables IDs at once.
+func (s *SQLite) forEachBatch(fullIDs []string, query string, scan func(rows *sql.Rows) error) error {
+	for len(fullIDs) > 0 {
+		batch := fullIDs
+		if len(batch) > SQLiteMaxVariables {
+			batch = batch[:SQLiteMaxVariables]
+		}
+
+		args := make([]interface{}, len(batch))
+		for i, id := range batch {
+			args[i] = id
+		}
+
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(batch)), ", ")
+		rows, err := s.Query(fmt.Sprintf(query, placeholders), args...)
+		if err != nil {
+			return err
+		}
+
+		for rows.Next() {
+			if err := scan(rows); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		fullIDs = fullIDs[len(batch):]
+	}
+
+	return nil
+}
+
+// scanStrings reads and closes rows of a single string column.
+func scanStrings(rows *sql.Rows) ([]string, *ContextError) {
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, NewContextlessError(err)
+		}
+
+		values = append(values, value)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, NewContextlessError(err)
+	}
+
+	return values, nil
+}
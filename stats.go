@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vartanbeno/go-reddit/reddit"
+)
+
+// Statistics scopes, the submissions statistics are counted for.
+const (
+	StatsScopeAll          = "all"
+	StatsScopeSearchPrefix = "search:" // Followed by the canonical name of a search.
+	StatsScopeFlairPrefix  = "flair:"  // Followed by a flair key, see Client.flairKey.
+)
+
+// Statistics fields, counted in every scope.
+const (
+	StatsPosts       = "posts"
+	StatsRemoved     = "removed"
+	StatsScore       = "score"   // The sum of the upvotes the posts were archived with.
+	StatsMonthPrefix = "month:"  // Followed by a month formatted as StatsMonthLayout, counting the posts created in it.
+	StatsFlairPrefix = "flair:"  // Followed by a flair key, counting its posts. Left out of flair scopes.
+	StatsUpdated     = "updated" // The epoch submissions were last archived, only in StatsScopeAll.
+)
+
+// StatsMonthLayout formats the months posts are counted by.
+const StatsMonthLayout = "2006-01"
+
+// StatsMonths is the number of the latest months shown by the stats command.
+const StatsMonths = 12
+
+// StatsTopFlairs is the number of the most used flairs shown by the stats command.
+const StatsTopFlairs = 5
+
+// ArchiveStats are statistics by scope and then field, or the changes made to them.
+// They're counted as submissions are archived and their removals found, so they're never read from the whole archive.
+type ArchiveStats map[string]map[string]float64
+
+// add adds the value to a field of a scope.
+func (a ArchiveStats) add(scope string, field string, value float64) {
+	fields, ok := a[scope]
+	if !ok {
+		fields = make(map[string]float64)
+		a[scope] = fields
+	}
+
+	fields[field] += value
+}
+
+// statsScopes returns the scopes a submission with the flair key and searches counts towards.
+func statsScopes(flairKey string, searches []string) []string {
+	scopes := []string{StatsScopeAll, StatsScopeFlairPrefix + flairKey}
+	for _, search := range searches {
+		scopes = append(scopes, StatsScopeSearchPrefix+search)
+	}
+
+	return scopes
+}
+
+// addSubmission counts a submission in every scope it's in, or uncounts it with a sign of -1.
+func (a ArchiveStats) addSubmission(created float64, ups float64, flairKey string, searches []string, sign float64) {
+	month := StatsMonthPrefix + time.Unix(int64(created), 0).UTC().Format(StatsMonthLayout)
+	for _, scope := range statsScopes(flairKey, searches) {
+		a.add(scope, StatsPosts, sign)
+		a.add(scope, StatsScore, sign*ups)
+		a.add(scope, month, sign)
+		if !strings.HasPrefix(scope, StatsScopeFlairPrefix) {
+			a.add(scope, StatsFlairPrefix+flairKey, sign)
+		}
+	}
+}
+
+// addRemoval counts a submission being removed in every scope it's in, or being restored with a sign of -1.
+func (a ArchiveStats) addRemoval(flairKey string, searches []string, sign float64) {
+	for _, scope := range statsScopes(flairKey, searches) {
+		a.add(scope, StatsRemoved, sign)
+	}
+}
+
+// StatsCount is what a submission was counted as in the statistics.
+// It's stored with the archive so that the submission is uncounted from the scopes it was counted in,
+// even when its flair, the searches or how they match changed since.
+type StatsCount struct {
+	Created  float64  `json:"created"`
+	Ups      float64  `json:"ups"`
+	FlairKey string   `json:"flair_key"`
+	Searches []string `json:"searches"`
+}
+
+// MarshalBinary encodes the count to be stored.
+func (s StatsCount) MarshalBinary() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+// UnmarshalBinary decodes a count encoded by MarshalBinary.
+func (s *StatsCount) UnmarshalBinary(b []byte) error {
+	return json.Unmarshal(b, s)
+}
+
+// statsCount is what the submission counts as under the current config.
+func (c *Client) statsCount(submission PushshiftSubmission) StatsCount {
+	return StatsCount{
+		Created:  submission.DateCreated,
+		Ups:      float64(submission.Ups),
+		FlairKey: c.flairKey(submission.LinkFlairText),
+		Searches: c.Search.getSubmissionMatches(submission),
+	}
+}
+
+// recount counts a submission as archived, uncounting what it was counted as before if it was, and counting its removal when it's removed.
+// A removal is only counted for archived submissions, so one found before the submission was archived is counted here.
+func (a ArchiveStats) recount(previous *StatsCount, count StatsCount, removed bool) {
+	if previous != nil {
+		a.addSubmission(previous.Created, previous.Ups, previous.FlairKey, previous.Searches, -1)
+		if removed {
+			a.addRemoval(previous.FlairKey, previous.Searches, -1)
+		}
+	}
+
+	a.addSubmission(count.Created, count.Ups, count.FlairKey, count.Searches, 1)
+	if removed {
+		a.addRemoval(count.FlairKey, count.Searches, 1)
+	}
+}
+
+func init() {
+	registerInboxCommand(InboxCommand{
+		Name:      "stats",
+		Arguments: "[term|flair]",
+		MaxArgs:   -1,
+		Help:      "Shows how many posts are archived, how many were removed, posts per month, the average score and the most used flairs, for the whole archive or a search term or flair.",
+		Run:       (*Client).StatsCommand,
+		Parses: func(c *Client, arguments []string) (bool, *ContextError) {
+			_, _, ok, ce := c.topFilter(strings.Join(arguments, " "))
+			return ok, ce
+		},
+	})
+}
+
+// StatsCommand replies with the statistics of the archive or of a search term or flair.
+func (c *Client) StatsCommand(m *reddit.Message, arguments []string) *ContextError {
+	term := strings.Join(arguments, " ")
+	filter, _, ok, ce := c.topFilter(term)
+	if ce != nil {
+		return ce
+	} else if !ok {
+		return c.replyCouldNotParse(m, fmt.Sprintf("I don't know the search term or flair `%s`.", term))
+	}
+
+	names, ce := c.flairDisplayNames()
+	if ce != nil {
+		return ce
+	}
+
+	scope, title := StatsScopeAll, "the archive"
+	switch {
+	case len(filter.Searches) > 1:
+		return c.replyCouldNotParse(m, fmt.Sprintf("`%s` is several search terms: `%s`. Ask for one at a time.", term, strings.Join(filter.Searches, "`, `")))
+	case len(filter.Searches) == 1:
+		scope, title = StatsScopeSearchPrefix+filter.Searches[0], fmt.Sprintf("`%s`", filter.Searches[0])
+	case len(filter.FlairKeys) == 1:
+		name, ok := names[filter.FlairKeys[0]]
+		if !ok {
+			name = filter.FlairKeys[0]
+		}
+
+		scope, title = StatsScopeFlairPrefix+filter.FlairKeys[0], fmt.Sprintf("the flair `%s`", name)
+	}
+
+	stats, ce := c.Archive.getStats(scope)
+	if ce != nil {
+		return ce
+	}
+
+	all, ce := c.Archive.getStats(StatsScopeAll)
+	if ce != nil {
+		return ce
+	}
+
+	posts := stats[StatsPosts]
+	if posts <= 0 {
+		return c.replyWithFooter(m, fmt.Sprintf("There aren't any archived posts for %s yet.", title))
+	}
+
+	lines := []string{
+		fmt.Sprintf("Statistics for %s:", title),
+		"",
+		fmt.Sprintf("- **Posts:** %d", int64(posts)),
+		fmt.Sprintf("- **Removed:** %d (%.1f%%)", int64(stats[StatsRemoved]), 100*stats[StatsRemoved]/posts),
+		fmt.Sprintf("- **Average score:** %.1f", stats[StatsScore]/posts),
+	}
+
+	if updated := all[StatsUpdated]; updated != 0 {
+		lines = append(lines, fmt.Sprintf("- **Last updated:** %s", time.Unix(int64(updated), 0).UTC().Format("2006-01-02 15:04 MST")))
+	}
+
+	if months := statsCounts(stats, StatsMonthPrefix); len(months) != 0 {
+		sort.Slice(months, func(i, j int) bool {
+			return months[i].name > months[j].name
+		})
+
+		if len(months) > StatsMonths {
+			months = months[:StatsMonths]
+		}
+
+		lines = append(lines, "", "Month | Posts", ":--|--:")
+		for _, month := range months {
+			lines = append(lines, fmt.Sprintf("%s | %d", month.name, month.count))
+		}
+	}
+
+	if flairs := statsCounts(stats, StatsFlairPrefix); len(flairs) != 0 {
+		sort.Slice(flairs, func(i, j int) bool {
+			if flairs[i].count == flairs[j].count {
+				return flairs[i].name < flairs[j].name
+			}
+
+			return flairs[i].count > flairs[j].count
+		})
+
+		if len(flairs) > StatsTopFlairs {
+			flairs = flairs[:StatsTopFlairs]
+		}
+
+		lines = append(lines, "", "Top flairs:", "")
+		for _, flair := range flairs {
+			name, ok := names[flair.name]
+			if !ok {
+				name = flair.name
+			}
+
+			lines = append(lines, fmt.Sprintf("- %s: %d", name, flair.count))
+		}
+	}
+
+	return c.replyWithFooter(m, strings.Join(lines, "\n"))
+}
+
+// statsCount is a counted field of a scope with its prefix removed.
+type statsCount struct {
+	name  string
+	count int64
+}
+
+// statsCounts returns the non-zero fields with the prefix.
+func statsCounts(stats map[string]float64, prefix string) []statsCount {
+	var counts []statsCount
+	for field, value := range stats {
+		if count := int64(math.Round(value)); strings.HasPrefix(field, prefix) && count > 0 {
+			counts = append(counts, statsCount{strings.TrimPrefix(field, prefix), count})
+		}
+	}
+
+	return counts
+}
+
+// flairDisplayNames returns a map of flair keys to the name each is shown as, see preferFlairName.
+func (c *Client) flairDisplayNames() (map[string]string, *ContextError) {
+	flairNames, ce := c.Archive.getFlairNames()
+	if ce != nil {
+		return nil, ce
+	}
+
+	names := map[string]string{"": c.Config.Flairs.unflaired()}
+	for _, name := range flairNames {
+		key := c.flairKey(name)
+		if shown, ok := names[key]; key != "" && (!ok || c.preferFlairName(name, shown)) {
+			names[key] = name
+		}
+	}
+
+	return names, nil
+}
+
+// RebuildStatsCommand counts the statistics of the whole archive again, which archives from older versions need.
+func RebuildStatsCommand(c *Client, args []string) *ContextError {
+	if len(args) != 0 {
+		return NewContextlessError(errors.New("rebuild-stats doesn't take arguments"))
+	}
+
+	previous, ce := c.Archive.getStats(StatsScopeAll)
+	if ce != nil {
+		return ce
+	}
+
+	stats := make(ArchiveStats)
+	counts := make(map[string]StatsCount)
+	ce = c.Archive.scanExport(ExportFilter{}, func(record ExportRecord) error {
+		count := StatsCount{
+			Created:  record.DateCreated,
+			Ups:      float64(record.Upvotes),
+			FlairKey: c.flairKey(record.Flair),
+			Searches: record.SearchTerms,
+		}
+		counts[record.ID] = count
+
+		stats.addSubmission(count.Created, count.Ups, count.FlairKey, count.Searches, 1)
+		if record.Removal == RemovalRemoved {
+			stats.addRemoval(count.FlairKey, count.Searches, 1)
+		}
+
+		return nil
+	})
+	if ce != nil {
+		return ce
+	}
+
+	if updated := previous[StatsUpdated]; updated != 0 {
+		stats.add(StatsScopeAll, StatsUpdated, updated)
+	}
+
+	if ce := c.Archive.replaceStats(stats, counts); ce != nil {
+		return ce
+	}
+
+	c.Logger.Infof("Counted the statistics of %d posts.", int64(stats[StatsScopeAll][StatsPosts]))
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// withoutZeros returns the statistics without the fields which were added and then taken away.
+func withoutZeros(stats ArchiveStats) ArchiveStats {
+	cleaned := make(ArchiveStats)
+	for scope, fields := range stats {
+		for field, value := range fields {
+			if value != 0 {
+				cleaned.add(scope, field, value)
+			}
+		}
+	}
+
+	return cleaned
+}
+
+func TestArchiveStatsAddSubmission(t *testing.T) {
+	created := float64(time.Date(2021, time.March, 14, 0, 0, 0, 0, time.UTC).Unix())
+	stats := make(ArchiveStats)
+	stats.addSubmission(created, 12, "help", []string{"Python"}, 1)
+
+	want := ArchiveStats{
+		StatsScopeAll: {
+			StatsPosts: 1, StatsScore: 12, StatsMonthPrefix + "2021-03": 1, StatsFlairPrefix + "help": 1,
+		},
+		StatsScopeFlairPrefix + "help": {
+			StatsPosts: 1, StatsScore: 12, StatsMonthPrefix + "2021-03": 1,
+		},
+		StatsScopeSearchPrefix + "Python": {
+			StatsPosts: 1, StatsScore: 12, StatsMonthPrefix + "2021-03": 1, StatsFlairPrefix + "help": 1,
+		},
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("counted %v, want %v", stats, want)
+	}
+
+	stats.addSubmission(created, 12, "help", []string{"Python"}, -1)
+	if cleaned := withoutZeros(stats); len(cleaned) != 0 {
+		t.Errorf("uncounting left %v", cleaned)
+	}
+}
+
+func TestArchiveStatsRecount(t *testing.T) {
+	created := float64(time.Date(2021, time.March, 14, 0, 0, 0, 0, time.UTC).Unix())
+	count := StatsCount{Created: created, Ups: 10, FlairKey: "help", Searches: []string{"Python"}}
+	edited := StatsCount{Created: created, Ups: 25, FlairKey: "solved", Searches: []string{"Python", "Django"}}
+
+	tests := []struct {
+		name     string
+		previous *StatsCount
+		count    StatsCount
+		removed  bool
+		want     func(ArchiveStats)
+	}{
+		{"first archived", nil, count, false, func(want ArchiveStats) {
+			want.addSubmission(created, 10, "help", []string{"Python"}, 1)
+		}},
+		{"first archived removed", nil, count, true, func(want ArchiveStats) {
+			want.addSubmission(created, 10, "help", []string{"Python"}, 1)
+			want.addRemoval("help", []string{"Python"}, 1)
+		}},
+		{"archived again unchanged", &count, count, false, func(want ArchiveStats) {}},
+		{"archived again removed unchanged", &count, count, true, func(want ArchiveStats) {}},
+		{"archived again edited", &count, edited, false, func(want ArchiveStats) {
+			want.addSubmission(created, 10, "help", []string{"Python"}, -1)
+			want.addSubmission(created, 25, "solved", []string{"Python", "Django"}, 1)
+		}},
+		{"archived again edited and removed", &count, edited, true, func(want ArchiveStats) {
+			want.addSubmission(created, 10, "help", []string{"Python"}, -1)
+			want.addRemoval("help", []string{"Python"}, -1)
+			want.addSubmission(created, 25, "solved", []string{"Python", "Django"}, 1)
+			want.addRemoval("solved", []string{"Python", "Django"}, 1)
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := make(ArchiveStats)
+			got.recount(test.previous, test.count, test.removed)
+
+			want := make(ArchiveStats)
+			test.want(want)
+			if got, want := withoutZeros(got), withoutZeros(want); !reflect.DeepEqual(got, want) {
+				t.Errorf("recount() = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vartanbeno/go-reddit/reddit"
+)
+
+// SubscriptionsDefaultMaxPerUser is the most subscriptions a user can have when Subscriptions.max_per_user isn't set.
+const SubscriptionsDefaultMaxPerUser = 5
+
+// SubscriptionsDefaultExpireAfter is how long a subscription lasts when Subscriptions.expire_after isn't set.
+const SubscriptionsDefaultExpireAfter = 30 * 24 * time.Hour
+
+// SubscriptionsDefaultMaxPosts is the most posts listed in a message when Subscriptions.max_posts isn't set.
+const SubscriptionsDefaultMaxPosts = 10
+
+// SubscriptionsDefaultInterval is the least time between messages to a user when Subscriptions.interval isn't set.
+const SubscriptionsDefaultInterval = time.Hour
+
+// SubscriptionMaxPending is the most matches kept for a subscription until they're sent, the oldest are dropped first.
+const SubscriptionMaxPending = 100
+
+// SubscriptionsSubject is the subject of the messages listing new posts.
+const SubscriptionsSubject = "New posts matching your subscriptions"
+
+// SubscriptionsConfig configures the subscribe command and the messages sent for it.
+type SubscriptionsConfig struct {
+	MaxPerUser  int           `toml:"max_per_user"` // The most subscriptions a user can have.
+	ExpireAfter time.Duration `toml:"expire_after"` // How long a subscription lasts, subscribing again renews it.
+	MaxPosts    int           `toml:"max_posts"`    // The most posts listed in a message, the rest are counted.
+	Interval    time.Duration `toml:"interval"`     // The least time between messages to a user, matches found meanwhile are sent together.
+}
+
+func (s SubscriptionsConfig) maxPerUser() int {
+	if s.MaxPerUser <= 0 {
+		return SubscriptionsDefaultMaxPerUser
+	}
+
+	return s.MaxPerUser
+}
+
+func (s SubscriptionsConfig) expireAfter() time.Duration {
+	if s.ExpireAfter <= 0 {
+		return SubscriptionsDefaultExpireAfter
+	}
+
+	return s.ExpireAfter
+}
+
+func (s SubscriptionsConfig) maxPosts() int {
+	if s.MaxPosts <= 0 {
+		return SubscriptionsDefaultMaxPosts
+	}
+
+	return s.MaxPosts
+}
+
+func (s SubscriptionsConfig) interval() time.Duration {
+	if s.Interval <= 0 {
+		return SubscriptionsDefaultInterval
+	}
+
+	return s.Interval
+}
+
+// Subscription is a user's query, which new posts are matched against as they're archived.
+type Subscription struct {
+	User     string   `json:"user"`
+	Query    string   `json:"query"`
+	Created  float64  `json:"created"`  // The epoch of subscribing, only posts created since are matched.
+	Expires  float64  `json:"expires"`  // The epoch the subscription is removed at.
+	Notified float64  `json:"notified"` // The epoch the user was last sent the matches, 0 if never.
+	Pending  []string `json:"pending"`  // The full IDs of the matches not sent yet, oldest first.
+}
+
+// subscriptionKey identifies a subscription, users being case insensitive like Reddit usernames.
+func subscriptionKey(user string, query string) string {
+	return strings.ToLower(user) + " " + query
+}
+
+func (s Subscription) key() string {
+	return subscriptionKey(s.User, s.Query)
+}
+
+func (s Subscription) expired(now time.Time) bool {
+	return s.Expires <= float64(now.Unix())
+}
+
+// MarshalBinary encodes the subscription as JSON.
+func (s Subscription) MarshalBinary() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+// UnmarshalBinary decodes a subscription encoded by MarshalBinary.
+func (s *Subscription) UnmarshalBinary(b []byte) error {
+	return json.Unmarshal(b, s)
+}
+
+func init() {
+	registerInboxCommand(InboxCommand{
+		Name:      "subscribe",
+		Arguments: "<query>",
+		MinArgs:   1,
+		MaxArgs:   -1,
+		Help:      "Sends you a message when new posts match the query, written like a search, e.g. `subscribe python flair:Help`. Subscriptions expire, subscribing again renews them.",
+		Run:       (*Client).SubscribeCommand,
+		Parses:    parsesQueryArguments,
+	})
+	registerInboxCommand(InboxCommand{
+		Name:      "unsubscribe",
+		Arguments: "[query]",
+		MaxArgs:   -1,
+		Help:      "Stops messages for the query, or for every subscription without one.",
+		Run:       (*Client).UnsubscribeCommand,
+		Parses:    parsesQueryArguments,
+	})
+	registerInboxCommand(InboxCommand{
+		Name:    "subscriptions",
+		MaxArgs: 0,
+		Help:    "Lists your subscriptions and when they expire.",
+		Run:     (*Client).SubscriptionsCommand,
+	})
+}
+
+// SubscribeCommand subscribes the author to a query, or renews the subscription.
+func (c *Client) SubscribeCommand(m *reddit.Message, arguments []string) *ContextError {
+	text := strings.Join(arguments, " ")
+	query, qe := ParseQuery(text)
+	if qe != nil {
+		return c.replyCouldNotParse(m, qe.Markdown())
+	}
+
+	// Matching nothing yet checks every term of the query is known, correcting misspelt ones.
+	now := time.Now()
+	_, err := c.matchQuery(query, timeAfter(float64(now.Unix())))
+	var ce *ContextError
+	if errors.As(err, &qe) {
+		return c.replyCouldNotParse(m, qe.Markdown())
+	} else if errors.As(err, &ce) {
+		return ce
+	}
+
+	if query.Suggestion != "" {
+		text = query.Suggestion
+	}
+
+	c.subscriptionsLock.Lock()
+	defer c.subscriptionsLock.Unlock()
+
+	subscriptions, ce := c.userSubscriptions(m.Author, now)
+	if ce != nil {
+		return ce
+	}
+
+	subscription := Subscription{User: m.Author, Query: text, Created: float64(now.Unix())}
+	renewed := false
+	for _, existing := range subscriptions {
+		if strings.EqualFold(existing.Query, text) {
+			subscription, renewed, text = existing, true, existing.Query
+		}
+	}
+
+	if max := c.Config.Subscriptions.maxPerUser(); !renewed && len(subscriptions) >= max {
+		return c.replyCouldNotParse(m, fmt.Sprintf("You already have %d subscriptions, the most you can have. Unsubscribe from one first.", max))
+	}
+
+	subscription.Expires = float64(now.Add(c.Config.Subscriptions.expireAfter()).Unix())
+	if ce := c.Archive.setSubscription(subscription); ce != nil {
+		return ce
+	}
+
+	message := fmt.Sprintf("I'll send you a message when new posts match `%s`, until %s.", text, formatEpochDate(subscription.Expires))
+	if renewed {
+		message = fmt.Sprintf("Renewed your subscription to `%s` until %s.", text, formatEpochDate(subscription.Expires))
+	}
+
+	return c.replyWithFooter(m, message+fmt.Sprintf(" Stop them with `unsubscribe %s`.", text))
+}
+
+// UnsubscribeCommand removes the author's subscription to a query, or every subscription of theirs.
+func (c *Client) UnsubscribeCommand(m *reddit.Message, arguments []string) *ContextError {
+	text := strings.Join(arguments, " ")
+
+	c.subscriptionsLock.Lock()
+	defer c.subscriptionsLock.Unlock()
+
+	subscriptions, ce := c.userSubscriptions(m.Author, time.Now())
+	if ce != nil {
+		return ce
+	}
+
+	if len(subscriptions) == 0 {
+		return c.replyWithFooter(m, "You don't have any subscriptions.")
+	}
+
+	var removed []string
+	for _, subscription := range subscriptions {
+		if text != "" && !strings.EqualFold(subscription.Query, text) {
+			continue
+		}
+
+		if _, ce := c.Archive.removeSubscription(subscription.User, subscription.Query); ce != nil {
+			return ce
+		}
+
+		removed = append(removed, subscription.Query)
+	}
+
+	switch {
+	case len(removed) == 0:
+		return c.replyCouldNotParse(m, fmt.Sprintf("You aren't subscribed to `%s`. The `subscriptions` command lists your subscriptions.", text))
+	case len(removed) == 1:
+		return c.replyWithFooter(m, fmt.Sprintf("Unsubscribed from `%s`.", removed[0]))
+	default:
+		return c.replyWithFooter(m, fmt.Sprintf("Unsubscribed from your %d subscriptions.", len(removed)))
+	}
+}
+
+// SubscriptionsCommand lists the author's subscriptions.
+func (c *Client) SubscriptionsCommand(m *reddit.Message, arguments []string) *ContextError {
+	c.subscriptionsLock.Lock()
+	defer c.subscriptionsLock.Unlock()
+
+	subscriptions, ce := c.userSubscriptions(m.Author, time.Now())
+	if ce != nil {
+		return ce
+	}
+
+	if len(subscriptions) == 0 {
+		return c.replyWithFooter(m, "You don't have any subscriptions. Subscribe to new posts matching a search with `subscribe <query>`.")
+	}
+
+	lines := []string{"Your subscriptions:", ""}
+	for _, subscription := range subscriptions {
+		lines = append(lines, fmt.Sprintf("- `%s`, until %s", subscription.Query, formatEpochDate(subscription.Expires)))
+	}
+
+	return c.replyWithFooter(m, strings.Join(lines, "\n"))
+}
+
+// userSubscriptions returns the unexpired subscriptions of the user, oldest first. The caller holds subscriptionsLock.
+func (c *Client) userSubscriptions(user string, now time.Time) ([]Subscription, *ContextError) {
+	subscriptions, ce := c.Archive.getSubscriptions()
+	if ce != nil {
+		return nil, ce
+	}
+
+	var owned []Subscription
+	for _, subscription := range subscriptions {
+		if strings.EqualFold(subscription.User, user) && !subscription.expired(now) {
+			owned = append(owned, subscription)
+		}
+	}
+
+	sort.Slice(owned, func(i, j int) bool {
+		return owned[i].Created < owned[j].Created
+	})
+
+	return owned, nil
+}
+
+// newSubmissions returns the submissions which aren't archived yet, to match against subscriptions once they are.
+func (c *Client) newSubmissions(submissions []PushshiftSubmission) ([]PushshiftSubmission, *ContextError) {
+	fullIDs := make([]string, len(submissions))
+	for i, submission := range submissions {
+		fullIDs[i] = toFullID(submission.ID)
+	}
+
+	archived, ce := c.Archive.getSubmissions(fullIDs)
+	if ce != nil {
+		return nil, ce
+	}
+
+	var unarchived []PushshiftSubmission
+	for i, submission := range submissions {
+		if _, ok := archived[fullIDs[i]]; !ok {
+			unarchived = append(unarchived, submission)
+		}
+	}
+
+	return unarchived, nil
+}
+
+// matchSubscriptions queues the archived new submissions matching each subscription created before them, and removes expired subscriptions.
+// The queries are evaluated like searches, only reading the archive in the window of the submissions.
+func (c *Client) matchSubscriptions(submissions []PushshiftSubmission) *ContextError {
+	if len(submissions) == 0 {
+		return nil
+	}
+
+	c.subscriptionsLock.Lock()
+	defer c.subscriptionsLock.Unlock()
+
+	subscriptions, ce := c.Archive.getSubscriptions()
+	if ce != nil || len(subscriptions) == 0 {
+		return ce
+	}
+
+	sorted := append([]PushshiftSubmission(nil), submissions...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].DateCreated < sorted[j].DateCreated
+	})
+
+	window := timeBetween(sorted[0].DateCreated, sorted[len(sorted)-1].DateCreated+1)
+	now := time.Now()
+	for _, subscription := range subscriptions {
+		if subscription.expired(now) {
+			if _, ce := c.Archive.removeSubscription(subscription.User, subscription.Query); ce != nil {
+				return ce
+			}
+
+			c.Logger.Infof("Removed the expired subscription of %s to %q.", subscription.User, subscription.Query)
+			continue
+		}
+
+		// A query stops parsing or evaluating when a search it uses is removed from the config, and matches nothing until it's back.
+		query, qe := ParseQuery(subscription.Query)
+		if qe != nil {
+			c.Logger.Warnf("Skipping the subscription of %s: %v", subscription.User, qe)
+			continue
+		}
+
+		matches, err := c.matchQuery(query, window)
+		if errors.As(err, &qe) {
+			c.Logger.Warnf("Skipping the subscription of %s: %v", subscription.User, qe)
+			continue
+		} else if errors.As(err, &ce) {
+			return ce
+		}
+
+		pending := make(map[string]bool, len(subscription.Pending))
+		for _, fullID := range subscription.Pending {
+			pending[fullID] = true
+		}
+
+		matched := false
+		for _, submission := range sorted {
+			fullID := toFullID(submission.ID)
+			if _, ok := matches[fullID]; ok && !pending[fullID] && submission.DateCreated >= subscription.Created {
+				subscription.Pending = append(subscription.Pending, fullID)
+				pending[fullID], matched = true, true
+			}
+		}
+
+		if !matched {
+			continue
+		}
+
+		if n := len(subscription.Pending); n > SubscriptionMaxPending {
+			subscription.Pending = subscription.Pending[n-SubscriptionMaxPending:]
+		}
+
+		if ce := c.Archive.setSubscription(subscription); ce != nil {
+			return ce
+		}
+	}
+
+	return nil
+}
+
+// notifySubscribers sends every user with pending matches one message listing them, at most once per Subscriptions.interval.
+// Matches are dropped once a message is attempted, so a user who blocked the bot isn't retried until their subscriptions expire.
+func (c *Client) notifySubscribers() *ContextError {
+	c.subscriptionsLock.Lock()
+	defer c.subscriptionsLock.Unlock()
+
+	subscriptions, ce := c.Archive.getSubscriptions()
+	if ce != nil {
+		return ce
+	}
+
+	users := make(map[string][]Subscription)
+	for _, subscription := range subscriptions {
+		user := strings.ToLower(subscription.User)
+		users[user] = append(users[user], subscription)
+	}
+
+	now := time.Now()
+	due := float64(now.Add(-c.Config.Subscriptions.interval()).Unix())
+	for _, owned := range users {
+		pending := 0
+		for _, subscription := range owned {
+			if subscription.Notified > due {
+				pending = 0
+				break
+			}
+
+			pending += len(subscription.Pending)
+		}
+
+		if pending == 0 {
+			continue
+		}
+
+		message, ce := c.subscriptionMessage(owned)
+		if ce != nil {
+			return ce
+		}
+
+		if message != "" {
+			_, err := c.Reddit.Message.Send(ctx, &reddit.SendMessageRequest{
+				To:      owned[0].User,
+				Subject: SubscriptionsSubject,
+				Text:    message,
+			})
+			if err != nil {
+				NewWrappedError("sending subscription message", err, []ContextParam{
+					{"User", owned[0].User},
+				}).LogError(c.Logger)
+			}
+		}
+
+		for _, subscription := range owned {
+			subscription.Pending = nil
+			subscription.Notified = float64(now.Unix())
+			if ce := c.Archive.setSubscription(subscription); ce != nil {
+				return ce
+			}
+		}
+	}
+
+	return nil
+}
+
+// subscriptionMessage lists the pending matches of a user's subscriptions, or returns "" when none of them are archived.
+// New posts are only checked for removal after they're archived, so they're listed without knowing if they were removed.
+func (c *Client) subscriptionMessage(subscriptions []Subscription) (string, *ContextError) {
+	var fullIDs []string
+	queries := make(map[string]string)
+	for _, subscription := range subscriptions {
+		for _, fullID := range subscription.Pending {
+			if _, ok := queries[fullID]; !ok {
+				fullIDs = append(fullIDs, fullID)
+				queries[fullID] = subscription.Query
+			}
+		}
+	}
+
+	linkMap, ce := c.Archive.getLinks(fullIDs)
+	if ce != nil {
+		return "", ce
+	}
+
+	var links []string
+	for _, fullID := range fullIDs {
+		if link, ok := linkMap[fullID]; ok {
+			links = append(links, fmt.Sprintf("- %s (`%s`)", link, queries[fullID]))
+		}
+	}
+
+	if len(links) == 0 {
+		return "", nil
+	}
+
+	footer, ce := c.renderTemplate(ReplyFooter, ReplyData{User: subscriptions[0].User})
+	if ce != nil {
+		return "", ce
+	}
+
+	footer = "\n\nStop these messages with `unsubscribe`." + footer
+	if max := c.Config.Subscriptions.maxPosts(); len(links) > max {
+		footer = "\n\n" + fmt.Sprintf(DefaultReplyMore, len(links)-max) + footer
+		links = links[:max]
+	}
+
+	reply := Reply{
+		Header:    "New posts matching your subscriptions:\n\n",
+		Items:     links,
+		Separator: "\n\n",
+		Footer:    footer,
+	}
+
+	return reply.split(RedditMaxCommentLength, 1)[0], nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vartanbeno/go-reddit/reddit"
+)
+
+func TestArchiveSubscriptions(t *testing.T) {
+	forEachBackend(t, testConstants, func(t *testing.T, c *Client) {
+		subscriptions := []Subscription{
+			{User: "Alice", Query: "python", Created: 1000, Expires: 2000},
+			{User: "alice", Query: "django", Created: 1000, Expires: 2000},
+			{User: "bob", Query: "python", Created: 1000, Expires: 2000, Pending: []string{"t3_a"}},
+		}
+		for _, subscription := range subscriptions {
+			if ce := c.Archive.setSubscription(subscription); ce != nil {
+				t.Fatal(ce)
+			}
+		}
+
+		// Subscribing again replaces the subscription, users being case insensitive.
+		renewed := Subscription{User: "ALICE", Query: "python", Created: 1000, Expires: 3000}
+		if ce := c.Archive.setSubscription(renewed); ce != nil {
+			t.Fatal(ce)
+		}
+		subscriptions[0] = renewed
+
+		removed, ce := c.Archive.removeSubscription("Alice", "django")
+		if ce != nil || !removed {
+			t.Errorf("removeSubscription() = %v, %v, want true", removed, ce)
+		}
+		subscriptions = append(subscriptions[:1], subscriptions[2:]...)
+
+		if removed, ce := c.Archive.removeSubscription("alice", "django"); ce != nil || removed {
+			t.Errorf("removeSubscription() again = %v, %v, want false", removed, ce)
+		}
+
+		got, ce := c.Archive.getSubscriptions()
+		if ce != nil {
+			t.Fatal(ce)
+		}
+
+		sort := func(subscriptions []Subscription) map[string]Subscription {
+			byKey := make(map[string]Subscription, len(subscriptions))
+			for _, subscription := range subscriptions {
+				byKey[subscription.key()] = subscription
+			}
+			return byKey
+		}
+		if !reflect.DeepEqual(sort(got), sort(subscriptions)) {
+			t.Errorf("getSubscriptions() = %+v, want %+v", got, subscriptions)
+		}
+	})
+}
+
+// forEachSubscriber runs the test with a client of every backend which can reply and send messages.
+func forEachSubscriber(t *testing.T, test func(t *testing.T, c *Client, fake *fakeReddit)) {
+	forEachFakeReddit(t, func(t *testing.T, c *Client, fake *fakeReddit) {
+		constants := goldenConstants
+		constants.Searches = testConstants.Searches
+		c.Config.Constants = constants
+
+		replies, err := NewReplyTemplates(c.Config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Replies = replies
+
+		test(t, c, fake)
+	})
+}
+
+func TestSubscriptionCommands(t *testing.T) {
+	forEachSubscriber(t, func(t *testing.T, c *Client, fake *fakeReddit) {
+		c.Config.Subscriptions.MaxPerUser = 2
+		m := &reddit.Message{FullID: "t4_m", Author: "alice", Subject: "subscribe"}
+		command := func(run func(c *Client, m *reddit.Message, arguments []string) *ContextError, arguments ...string) string {
+			t.Helper()
+			if ce := run(c, m, arguments); ce != nil {
+				t.Fatal(ce)
+			}
+
+			// Every command answers the same message, so the claim is cleared for the next.
+			if ce := c.Archive.setReply(m.FullID, ""); ce != nil {
+				t.Fatal(ce)
+			}
+
+			return fake.sent[0]["body"].(string)
+		}
+
+		tests := []struct {
+			run       func(c *Client, m *reddit.Message, arguments []string) *ContextError
+			arguments []string
+			want      string
+		}{
+			{(*Client).SubscriptionsCommand, nil, "You don't have any subscriptions."},
+			{(*Client).SubscribeCommand, []string{"python"}, "I'll send you a message when new posts match `python`"},
+			{(*Client).SubscribeCommand, []string{"PYTHON"}, "Renewed your subscription to `python`"},
+			{(*Client).SubscribeCommand, []string{"djnago"}, "I'll send you a message when new posts match `Django`"},
+			{(*Client).SubscribeCommand, []string{"flair:Help"}, "You already have 2 subscriptions"},
+			{(*Client).SubscribeCommand, []string{"(python"}, "This parenthesis is never closed."},
+			{(*Client).SubscriptionsCommand, nil, "- `python`, until "},
+			{(*Client).UnsubscribeCommand, []string{"flair:Help"}, "You aren't subscribed to `flair:Help`."},
+			{(*Client).UnsubscribeCommand, []string{"Python"}, "Unsubscribed from `python`."},
+			{(*Client).SubscriptionsCommand, nil, "- `Django`, until "},
+			{(*Client).UnsubscribeCommand, nil, "Unsubscribed from `Django`."},
+			{(*Client).UnsubscribeCommand, nil, "You don't have any subscriptions."},
+		}
+
+		for _, test := range tests {
+			if body := command(test.run, test.arguments...); !strings.Contains(body, test.want) {
+				t.Errorf("%q replied %q, want it to contain %q", test.arguments, body, test.want)
+			}
+		}
+	})
+}
+
+func TestMatchAndNotifySubscriptions(t *testing.T) {
+	forEachSubscriber(t, func(t *testing.T, c *Client, fake *fakeReddit) {
+		now := float64(time.Now().Unix())
+		subscriptions := []Subscription{
+			{User: "alice", Query: "python", Created: 1500, Expires: now + 1000},
+			{User: "alice", Query: "django", Created: 1500, Expires: now + 1000},
+			{User: "bob", Query: "python", Created: 1000, Expires: now - 1},
+		}
+		for _, subscription := range subscriptions {
+			if ce := c.Archive.setSubscription(subscription); ce != nil {
+				t.Fatal(ce)
+			}
+		}
+
+		// New submissions are matched once they're archived, and only those created after subscribing.
+		unarchived, ce := c.newSubmissions(testSubmissions)
+		if ce != nil {
+			t.Fatal(ce)
+		}
+		if err := c.Archive.addSubmissions(unarchived); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 2; i++ {
+			if ce := c.matchSubscriptions(unarchived); ce != nil {
+				t.Fatal(ce)
+			}
+		}
+
+		if unarchived, ce := c.newSubmissions(testSubmissions); ce != nil || len(unarchived) != 0 {
+			t.Errorf("newSubmissions() of archived submissions = %v, %v", unarchived, ce)
+		}
+
+		got, ce := c.Archive.getSubscriptions()
+		if ce != nil {
+			t.Fatal(ce)
+		}
+
+		pending := make(map[string][]string)
+		for _, subscription := range got {
+			pending[subscription.User+" "+subscription.Query] = subscription.Pending
+		}
+		want := map[string][]string{"alice python": {"t3_c"}, "alice django": {"t3_c"}}
+		if !reflect.DeepEqual(pending, want) {
+			t.Errorf("pending after matching twice = %v, want %v without the expired subscription", pending, want)
+		}
+
+		// A user is sent one message listing each match once.
+		for i := 0; i < 2; i++ {
+			if ce := c.notifySubscribers(); ce != nil {
+				t.Fatal(ce)
+			}
+		}
+
+		if len(fake.sent) != 1 {
+			t.Fatalf("sent %d messages, want 1", len(fake.sent))
+		}
+
+		body := fake.sent[0]["body"].(string)
+		if fake.sent[0]["dest"] != "alice" || strings.Count(body, "[Python and Django]") != 1 || !strings.Contains(body, "Stop these messages with `unsubscribe`.") {
+			t.Errorf("sent %v", fake.sent[0])
+		}
+
+		got, ce = c.Archive.getSubscriptions()
+		if ce != nil {
+			t.Fatal(ce)
+		}
+
+		for _, subscription := range got {
+			if len(subscription.Pending) != 0 || subscription.Notified == 0 {
+				t.Errorf("subscription after notifying = %+v, want it notified without pending matches", subscription)
+			}
+		}
+	})
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/vartanbeno/go-reddit/reddit"
+)
+
+// Reply templates, see ReplyTemplateNames. Partials have no reply of their own and are used by others with {{template "name" .}}.
+const (
+	ReplyFooter         = "footer"          // Partial ending every reply.
+	ReplyHelpBody       = "help_body"       // Partial explaining the bot, after the list of commands and errors.
+	ReplyHelp           = "help"            // The reply to help, with the list of Commands.
+	ReplyCouldNotParse  = "could_not_parse" // The reply when a message can't be answered, explained by the Error.
+	ReplyDidYouMean     = "did_you_mean"    // Paragraph before search results, when the Query was corrected to a Suggestion.
+	ReplyFoundResults   = "found_results"   // Paragraph before search results.
+	ReplyResultsPage    = "results_page"    // Paragraph summarizing the Total results in Order and the Page.
+	ReplyNoResults      = "no_results"      // The reply when a search has no results.
+	ReplySimilarResults = "similar_results" // Paragraph before similar posts.
+	ReplyNoSimilar      = "no_similar"      // The reply when no similar posts are found.
+	ReplyTopResults     = "top_results"     // Paragraph before the top posts of the Scope.
+	ReplyNoTop          = "no_top"          // The reply when the top command finds no posts in the Scope.
+)
+
+// TemplateExtension is the extension of the template files in Templates.directory.
+const TemplateExtension = ".tmpl"
+
+// ErrInvalidTemplate is returned when a reply template can't be parsed or rendered, or formats a constant with the wrong arguments.
+var ErrInvalidTemplate = errors.New("invalid reply template")
+
+// DefaultReplyTemplates are the templates replies are rendered with unless overridden.
+// They format ConstantsConfig, so configs written before templates keep working.
+var DefaultReplyTemplates = map[string]string{
+	ReplyFooter:         `{{.Constants.Footer}}`,
+	ReplyHelpBody:       `{{.Constants.HelpBody}}`,
+	ReplyHelp:           `{{.Constants.HelpStart}}{{.Commands}}` + "\n\n" + `{{template "help_body" .}}`,
+	ReplyCouldNotParse:  `{{printf (print .Constants.CouldNotParse .Constants.HelpBody) .Error}}{{template "footer" .}}`,
+	ReplyDidYouMean:     `{{if .Suggestion}}{{printf .Constants.DidYouMean .Suggestion}}{{end}}`,
+	ReplyFoundResults:   `{{printf .Constants.FoundResults .Query}}`,
+	ReplyResultsPage:    `{{printf .Constants.ResultsPage .Total .Order .Page .Pages}}{{if lt .Page .Pages}} Add ` + "`page {{inc .Page}}`" + ` to the search for more.{{end}}`,
+	ReplyNoResults:      `{{printf .Constants.NoResults .Query}}`,
+	ReplySimilarResults: `{{.Constants.SimilarResults}}`,
+	ReplyNoSimilar:      `{{.Constants.NoSimilar}}{{template "footer" .}}`,
+	ReplyTopResults:     `{{printf .Constants.TopResults .Scope}}`,
+	ReplyNoTop:          `{{printf .Constants.NoTop .Scope}}{{template "footer" .}}`,
+}
+
+// replyTemplateFuncs are the functions templates can use besides text/template's.
+var replyTemplateFuncs = template.FuncMap{
+	"inc":    func(i int) int { return i + 1 },
+	"escape": escapeMarkdown,
+}
+
+// TemplatesConfig overrides the templates replies are rendered with, see DefaultReplyTemplates.
+type TemplatesConfig struct {
+	Directory string            `toml:"directory"` // Every name.tmpl file in it defines the template name.
+	Replies   map[string]string `toml:"replies"`   // Templates by name, overriding the directory.
+}
+
+// ReplyData is what reply templates are rendered with. Fields a reply has nothing for are left empty.
+type ReplyData struct {
+	User       string          // The author of the message replied to.
+	Query      string          // The query or term given to the command.
+	Suggestion string          // The Query with misspelt terms corrected.
+	Scope      string          // What a command ranked, e.g. "about `python` from the past week".
+	Order      string          // How the results are ordered, e.g. "relevance".
+	Error      string          // Why the message couldn't be answered.
+	Commands   string          // The generated list of commands.
+	Results    int             // The number of results shown.
+	Total      int             // The number of results of every page.
+	Page       int             // The page shown, from 1.
+	Pages      int             // The number of pages.
+	Constants  ConstantsConfig // Set when rendering, with unset constants defaulted, see ConstantsConfig.withDefaults.
+}
+
+// ReplyTemplates are the parsed templates and the constants they format, replaced together when the config is reloaded.
+type ReplyTemplates struct {
+	templates *template.Template
+	constants ConstantsConfig // With unset constants defaulted.
+	lock      sync.RWMutex
+}
+
+// NewReplyTemplates parses and checks the reply templates of the config.
+func NewReplyTemplates(config *Config) (*ReplyTemplates, error) {
+	templates, err := compileReplyTemplates(config.Templates, config.Constants)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReplyTemplates{templates: templates, constants: config.Constants.withDefaults()}, nil
+}
+
+// compileReplyTemplates parses the templates and checks every reply renders.
+func compileReplyTemplates(config TemplatesConfig, constants ConstantsConfig) (*template.Template, error) {
+	templates, err := parseReplyTemplates(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rendering every reply with every field set catches unknown fields and partials, which parsing doesn't.
+	sample := ReplyData{
+		User:       "user",
+		Query:      "query",
+		Suggestion: "suggestion",
+		Scope:      "scope",
+		Order:      "order",
+		Error:      "error",
+		Commands:   "- `command`",
+		Results:    1,
+		Total:      1,
+		Page:       1,
+		Pages:      2,
+		Constants:  constants.withDefaults(),
+	}
+	for _, name := range ReplyTemplateNames() {
+		var text strings.Builder
+		if err := templates.ExecuteTemplate(&text, name, sample); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidTemplate, name, err)
+		}
+
+		// printf doesn't fail on a verb without an argument or an argument without a verb, it writes %!.
+		if i := strings.Index(text.String(), "%!"); i != -1 {
+			return nil, fmt.Errorf("%w: %s: a constant is formatted with the wrong arguments: %q", ErrInvalidTemplate, name, text.String()[i:])
+		}
+	}
+
+	return templates, nil
+}
+
+// set replaces the templates with ones compiled by compileReplyTemplates, and the constants they were compiled with.
+func (r *ReplyTemplates) set(templates *template.Template, constants ConstantsConfig) {
+	r.lock.Lock()
+	r.templates = templates
+	r.constants = constants.withDefaults()
+	r.lock.Unlock()
+}
+
+// parseReplyTemplates parses the default templates, then those in Templates.directory and then Templates.replies, each overriding the last.
+// Templates that aren't replies are partials.
+func parseReplyTemplates(config TemplatesConfig) (*template.Template, error) {
+	sources := make(map[string]string, len(DefaultReplyTemplates))
+	for name, text := range DefaultReplyTemplates {
+		sources[name] = text
+	}
+
+	if config.Directory != "" {
+		if _, err := os.Stat(config.Directory); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+		}
+
+		paths, err := filepath.Glob(filepath.Join(config.Directory, "*"+TemplateExtension))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+		}
+
+		for _, path := range paths {
+			text, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+			}
+
+			sources[strings.TrimSuffix(filepath.Base(path), TemplateExtension)] = string(text)
+		}
+	}
+
+	for name, text := range config.Replies {
+		sources[name] = text
+	}
+
+	templates := template.New("").Funcs(replyTemplateFuncs)
+	for name, text := range sources {
+		if _, err := templates.New(name).Parse(text); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+		}
+	}
+
+	return templates, nil
+}
+
+// ReplyTemplateNames returns the names of the templates replies are rendered with, sorted.
+func ReplyTemplateNames() []string {
+	names := make([]string, 0, len(DefaultReplyTemplates))
+	for name := range DefaultReplyTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// render renders the template with the data and the current constants.
+func (r *ReplyTemplates) render(name string, data ReplyData) (string, error) {
+	r.lock.RLock()
+	templates := r.templates
+	data.Constants = r.constants
+	r.lock.RUnlock()
+
+	var text strings.Builder
+	if err := templates.ExecuteTemplate(&text, name, data); err != nil {
+		return "", err
+	}
+
+	return text.String(), nil
+}
+
+// renderReply renders a reply template for the message.
+func (c *Client) renderReply(name string, m *reddit.Message, data ReplyData) (string, *ContextError) {
+	data.User = m.Author
+	return c.renderTemplate(name, data)
+}
+
+// renderTemplate renders a reply template for data.User, also for messages the bot starts.
+func (c *Client) renderTemplate(name string, data ReplyData) (string, *ContextError) {
+	text, err := c.Replies.render(name, data)
+	if err != nil {
+		return "", NewWrappedError("could not render reply", err, []ContextParam{
+			{"Template", name},
+			{"User", data.User},
+		})
+	}
+
+	return text, nil
+}
+
+// renderParagraphs renders reply templates with the same data as paragraphs, leaving out those rendered empty.
+func (c *Client) renderParagraphs(m *reddit.Message, data ReplyData, names ...string) (string, *ContextError) {
+	paragraphs := make([]string, 0, len(names))
+	for _, name := range names {
+		text, ce := c.renderReply(name, m, data)
+		if ce != nil {
+			return "", ce
+		}
+
+		if strings.TrimSpace(text) != "" {
+			paragraphs = append(paragraphs, text)
+		}
+	}
+
+	return strings.Join(paragraphs, "\n\n"), nil
+}
+
+// footer renders the footer partial, for replies with a generated body.
+func (c *Client) footer(m *reddit.Message) (string, *ContextError) {
+	return c.renderReply(ReplyFooter, m, ReplyData{})
+}
+
+// replyTemplate replies with a reply template.
+func (c *Client) replyTemplate(m *reddit.Message, name string, data ReplyData) *ContextError {
+	text, ce := c.renderReply(name, m, data)
+	if ce != nil {
+		return ce
+	}
+
+	return c.reply(m, text)
+}
+
+// replyCouldNotParse replies that the message couldn't be answered, explaining why.
+func (c *Client) replyCouldNotParse(m *reddit.Message, message string) *ContextError {
+	return c.replyTemplate(m, ReplyCouldNotParse, ReplyData{Error: message})
+}
+
+// replyWithFooter replies with the generated message followed by the footer.
+func (c *Client) replyWithFooter(m *reddit.Message, message string) *ContextError {
+	footer, ce := c.footer(m)
+	if ce != nil {
+		return ce
+	}
+
+	return c.reply(m, message+footer)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+// goldenReplyData fills every field replies are rendered with.
+var goldenReplyData = ReplyData{
+	User:       "example_user",
+	Query:      "python flair:Help",
+	Suggestion: "python flair:\"Help Wanted\"",
+	Scope:      "about `python` from the past week",
+	Order:      "relevance",
+	Error:      "`sort:best` isn't an order like `sort:top` or `sort:new`.",
+	Commands:   "- `help [command]`: Explains a command, or lists every command.\n- `search <query> [page N]`: Finds archived posts.",
+	Results:    10,
+	Total:      42,
+	Page:       2,
+	Pages:      5,
+}
+
+// goldenConstants are the constants a config has to set. The rest are left to their defaults.
+var goldenConstants = ConstantsConfig{
+	CouldNotParse: "Sorry, I couldn't understand that. %s\n\n",
+	HelpStart:     "Here's what I can do:\n\n",
+	HelpBody:      "I archive the posts of this subreddit.",
+	NoResults:     "I couldn't find any posts for `%s`.",
+	FoundResults:  "Here's what I found for `%s`:",
+	Footer:        "\n\n---\n^(I'm a bot.)",
+}
+
+func TestReplyTemplatesGolden(t *testing.T) {
+	replies, err := NewReplyTemplates(&Config{Constants: goldenConstants})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range ReplyTemplateNames() {
+		t.Run(name, func(t *testing.T) {
+			got, err := replies.render(name, goldenReplyData)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			path := filepath.Join("testdata", name+".golden")
+			if *updateGolden {
+				if err := ioutil.WriteFile(path, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%v, run go test -run TestReplyTemplatesGolden -update to create it", err)
+			}
+
+			if got != string(want) {
+				t.Errorf("%s rendered:\n%s\nwant:\n%s", name, got, want)
+			}
+		})
+	}
+}
+
+func TestReplyTemplatesLastPage(t *testing.T) {
+	replies, err := NewReplyTemplates(&Config{Constants: goldenConstants})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := goldenReplyData
+	data.Page = data.Pages
+	last, err := replies.render(ReplyResultsPage, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	more, err := replies.render(ReplyResultsPage, goldenReplyData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(last) >= len(more) {
+		t.Errorf("the last page offers more pages: %q", last)
+	}
+}
+
+func TestReplyTemplatesOverride(t *testing.T) {
+	replies, err := NewReplyTemplates(&Config{Constants: goldenConstants, Templates: TemplatesConfig{Replies: map[string]string{
+		ReplyNoResults: `Nothing for {{.Query}}, {{.User}}.{{template "footer" .}}`,
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := replies.render(ReplyNoResults, ReplyData{User: "someone", Query: "rust"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	footer, err := replies.render(ReplyFooter, ReplyData{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "Nothing for rust, someone." + footer; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestReplyTemplatesInvalid(t *testing.T) {
+	for name, text := range map[string]string{
+		"syntax":           `{{.Query`,
+		"unknown field":    `{{.Missing}}`,
+		"unknown partial":  `{{template "missing" .}}`,
+		"missing argument": `{{printf .Constants.NoResults}}`,
+		"extra argument":   `{{printf .Constants.HelpBody .Query}}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewReplyTemplates(&Config{Constants: goldenConstants, Templates: TemplatesConfig{Replies: map[string]string{ReplyHelp: text}}})
+			if !errors.Is(err, ErrInvalidTemplate) {
+				t.Errorf("NewReplyTemplates() = %v, want %v", err, ErrInvalidTemplate)
+			}
+		})
+	}
+}
+
+func TestReplyTemplatesCouldNotParse(t *testing.T) {
+	// Configs written before templates may format the error in could_not_parse or in help_body, which followed it.
+	errorInHelpBody := goldenConstants
+	errorInHelpBody.CouldNotParse = "Sorry, I couldn't understand that.\n\n"
+	errorInHelpBody.HelpBody = "%s\n\nI archive the posts of this subreddit."
+
+	noError := goldenConstants
+	noError.CouldNotParse = "Sorry, I couldn't understand that.\n\n"
+
+	tests := []struct {
+		name      string
+		constants ConstantsConfig
+		want      string
+		wantErr   error
+	}{
+		{"error in could_not_parse", goldenConstants, "Sorry, I couldn't understand that. oops\n\nI archive the posts of this subreddit.\n\n---\n^(I'm a bot.)", nil},
+		{"error in help_body", errorInHelpBody, "Sorry, I couldn't understand that.\n\noops\n\nI archive the posts of this subreddit.\n\n---\n^(I'm a bot.)", nil},
+		{"no error", noError, "", ErrInvalidTemplate},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			replies, err := NewReplyTemplates(&Config{Constants: test.constants})
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("NewReplyTemplates() = %v, want %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			got, err := replies.render(ReplyCouldNotParse, ReplyData{Error: "oops"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
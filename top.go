@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vartanbeno/go-reddit/reddit"
+)
+
+// DefaultTopResults is used when ConstantsConfig.TopResults isn't set.
+const DefaultTopResults = "The highest scoring posts %s:"
+
+// DefaultNoTop is used when ConstantsConfig.NoTop isn't set.
+const DefaultNoTop = "There aren't any posts %s."
+
+// TopPeriods are the periods the top command ranks, by how they're written.
+var TopPeriods = map[string]string{
+	"week":  "from the past week",
+	"month": "from the past month",
+	"year":  "from the past year",
+	"all":   "of all time",
+}
+
+// TopDefaultPeriod is the period ranked when the top command isn't given one.
+const TopDefaultPeriod = "all"
+
+func init() {
+	registerInboxCommand(InboxCommand{
+		Name:      "top",
+		Arguments: "[term] [week|month|year|all]",
+		MaxArgs:   -1,
+		Help:      "Lists the highest scoring posts about a search term or flair, e.g. `top python month`, or of every post. Ranks posts of all time unless given a period.",
+		Run:       (*Client).TopCommand,
+		Parses: func(c *Client, arguments []string) (bool, *ContextError) {
+			_, term := splitTopPeriod(arguments)
+			_, _, ok, ce := c.topFilter(term)
+			return ok, ce
+		},
+	})
+}
+
+// splitTopPeriod returns the period the arguments of the top command end with, or TopDefaultPeriod, and the term before it.
+func splitTopPeriod(arguments []string) (string, string) {
+	if n := len(arguments); n != 0 {
+		if _, ok := TopPeriods[strings.ToLower(arguments[n-1])]; ok {
+			return strings.ToLower(arguments[n-1]), strings.Join(arguments[:n-1], " ")
+		}
+	}
+
+	return TopDefaultPeriod, strings.Join(arguments, " ")
+}
+
+// TopCommand replies with the unremoved posts with the most upvotes, optionally of a search term or flair and created in a period.
+func (c *Client) TopCommand(m *reddit.Message, arguments []string) *ContextError {
+	period, term := splitTopPeriod(arguments)
+	filter, suggestion, ok, ce := c.topFilter(term)
+	if ce != nil {
+		return ce
+	} else if !ok {
+		return c.replyCouldNotParse(m, fmt.Sprintf("I don't know the search term or flair `%s`.", term))
+	}
+
+	description := TopPeriods[period]
+	if suggestion != "" {
+		description = fmt.Sprintf("about `%s` %s", suggestion, description)
+	} else if term != "" {
+		description = fmt.Sprintf("about `%s` %s", term, description)
+	}
+
+	top, ce := c.Archive.getTopUpvoted(filter, topWindow(period, time.Now()), c.Config.Ranking.pageSize())
+	if ce != nil {
+		return ce
+	}
+
+	data := ReplyData{Query: term, Suggestion: suggestion, Scope: description, Results: len(top), Total: len(top)}
+	if len(top) == 0 {
+		noTop, ce := c.renderParagraphs(m, data, ReplyDidYouMean, ReplyNoTop)
+		if ce != nil {
+			return ce
+		}
+
+		return c.reply(m, noTop)
+	}
+
+	fullIDs := make([]string, len(top))
+	for i, z := range top {
+		fullIDs[i] = z.Member.(string)
+	}
+
+	linkMap, ce := c.Archive.getLinks(fullIDs)
+	if ce != nil {
+		return ce
+	}
+
+	links := make([]string, len(top))
+	for i, z := range top {
+		links[i] = fmt.Sprintf("- %s (%d upvotes)", linkMap[fullIDs[i]], int64(z.Score))
+	}
+
+	header, ce := c.renderParagraphs(m, data, ReplyDidYouMean, ReplyTopResults)
+	if ce != nil {
+		return ce
+	}
+
+	footer, ce := c.footer(m)
+	if ce != nil {
+		return ce
+	}
+
+	return c.replyWith(m, Reply{
+		Header:    header + "\n\n",
+		Items:     links,
+		Separator: "\n\n",
+		Footer:    footer,
+	})
+}
+
+// topFilter finds the search or flair the term of the top command means, like a term in a search, or every submission for no term.
+// A flair can also be given exactly with flair:. A term resolved to a search or flair it isn't written as is corrected like in a search,
+// returning the correction as the suggestion.
+func (c *Client) topFilter(term string) (TopFilter, string, bool, *ContextError) {
+	if term == "" {
+		return TopFilter{}, "", true, nil
+	}
+
+	if strings.HasPrefix(strings.ToLower(term), "flair:") {
+		flair := strings.Trim(term[len("flair:"):], `"`)
+		return TopFilter{FlairKeys: []string{c.searchFlairKey(flair)}}, "", true, nil
+	}
+
+	if matches := c.Search.getTitleMatches(term); len(matches) != 0 {
+		return TopFilter{Searches: matches}, "", true, nil
+	}
+
+	resolution, ok, err := (&queryEvaluator{client: c}).resolveTerm(term)
+	var ce *ContextError
+	if errors.As(err, &ce) {
+		return TopFilter{}, "", false, ce
+	} else if err != nil {
+		return TopFilter{}, "", false, NewContextlessError(err)
+	} else if !ok {
+		return TopFilter{}, "", false, nil
+	}
+
+	if resolution.Flair != "" {
+		suggestion := ""
+		if !resolution.Exact {
+			suggestion = "flair:" + quoteQueryValue(resolution.Flair)
+		}
+
+		return TopFilter{FlairKeys: []string{c.searchFlairKey(resolution.Flair)}}, suggestion, true, nil
+	}
+
+	return TopFilter{Searches: []string{resolution.Search}}, quoteQueryValue(resolution.Search), true, nil
+}
+
+// topWindow is the window of creation epochs of a period of the top command ending now.
+func topWindow(period string, now time.Time) TimeRange {
+	switch period {
+	case "week":
+		return timeAfter(float64(now.AddDate(0, 0, -7).Unix()))
+	case "month":
+		return timeAfter(float64(now.AddDate(0, -1, 0).Unix()))
+	case "year":
+		return timeAfter(float64(now.AddDate(-1, 0, 0).Unix()))
+	default:
+		return TimeRange{}
+	}
+}
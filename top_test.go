@@ -0,0 +1,113 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func TestTopFilter(t *testing.T) {
+	forEachBackend(t, testConstants, func(t *testing.T, c *Client) {
+		if err := c.Archive.addSubmissions(testSubmissions); err != nil {
+			t.Fatal(err)
+		}
+
+		solved := c.searchFlairKey("Solved")
+		tests := []struct {
+			term       string
+			filter     TopFilter
+			suggestion string
+			ok         bool
+		}{
+			{"", TopFilter{}, "", true},
+			{"Python", TopFilter{Searches: []string{"Python"}}, "", true},
+			{"py", TopFilter{Searches: []string{"Python"}}, "", true},
+			{"Pyhton", TopFilter{Searches: []string{"Python"}}, "Python", true},
+			{"Solved", TopFilter{FlairKeys: []string{solved}}, "", true},
+			{"solved", TopFilter{FlairKeys: []string{solved}}, "flair:Solved", true},
+			{"flair:Solved", TopFilter{FlairKeys: []string{solved}}, "", true},
+			{`flair:"Unflaired"`, TopFilter{FlairKeys: []string{""}}, "", true},
+			{"Kubernetes", TopFilter{}, "", false},
+		}
+
+		for _, test := range tests {
+			filter, suggestion, ok, ce := c.topFilter(test.term)
+			if ce != nil {
+				t.Errorf("topFilter(%q): %v", test.term, ce)
+				continue
+			}
+
+			if !reflect.DeepEqual(filter, test.filter) || suggestion != test.suggestion || ok != test.ok {
+				t.Errorf("topFilter(%q) = %+v, %q, %v, want %+v, %q, %v",
+					test.term, filter, suggestion, ok, test.filter, test.suggestion, test.ok)
+			}
+		}
+	})
+}
+
+func TestArchiveTopUpvoted(t *testing.T) {
+	forEachBackend(t, testConstants, func(t *testing.T, c *Client) {
+		// The submissions are ranked as soon as they're archived, before whether they're removed is known.
+		if err := c.Archive.addSubmissions(testSubmissions); err != nil {
+			t.Fatal(err)
+		}
+
+		top, ce := c.Archive.getTopUpvoted(TopFilter{}, TimeRange{}, 10)
+		if ce != nil {
+			t.Fatal(ce)
+		}
+
+		want := []redis.Z{{Score: 20, Member: "t3_c"}, {Score: 10, Member: "t3_a"}, {Score: 5, Member: "t3_b"}}
+		if !reflect.DeepEqual(top, want) {
+			t.Errorf("getTopUpvoted = %v, want %v", top, want)
+		}
+
+		top, ce = c.Archive.getTopUpvoted(TopFilter{}, TimeRange{}, 1)
+		checkMembers(t, "getTopUpvoted limited", top, ce, "t3_c")
+
+		top, ce = c.Archive.getTopUpvoted(TopFilter{Searches: []string{"Python"}}, TimeRange{}, 10)
+		checkMembers(t, "getTopUpvoted Python", top, ce, "t3_a", "t3_c")
+
+		top, ce = c.Archive.getTopUpvoted(TopFilter{Searches: []string{"Python", "Django"}}, TimeRange{}, 10)
+		checkMembers(t, "getTopUpvoted Python and Django", top, ce, "t3_c")
+
+		filter := TopFilter{FlairKeys: []string{c.searchFlairKey("Help"), ""}}
+		top, ce = c.Archive.getTopUpvoted(filter, TimeRange{}, 10)
+		checkMembers(t, "getTopUpvoted Help or unflaired", top, ce, "t3_a", "t3_b")
+
+		top, ce = c.Archive.getTopUpvoted(TopFilter{}, timeAfter(1500), 10)
+		checkMembers(t, "getTopUpvoted after 1500", top, ce, "t3_b", "t3_c")
+
+		removed := map[string]bool{"c": true}
+		if ce := c.Archive.setRemoved(testSubmissions, removed); ce != nil {
+			t.Fatal(ce)
+		}
+
+		top, ce = c.Archive.getTopUpvoted(TopFilter{Searches: []string{"Python"}}, TimeRange{}, 10)
+		checkMembers(t, "getTopUpvoted Python after removal", top, ce, "t3_a")
+	})
+}
+
+func TestTopWindow(t *testing.T) {
+	now := time.Date(2021, 3, 31, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		period string
+		after  time.Time
+	}{
+		{"week", time.Date(2021, 3, 24, 12, 0, 0, 0, time.UTC)},
+		{"month", time.Date(2021, 3, 3, 12, 0, 0, 0, time.UTC)}, // February 31st is normalized like time.AddDate does.
+		{"year", time.Date(2020, 3, 31, 12, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		if got, want := topWindow(test.period, now), timeAfter(float64(test.after.Unix())); got != want {
+			t.Errorf("topWindow(%q) = %+v, want %+v", test.period, got, want)
+		}
+	}
+
+	if got := topWindow("", now); got != (TimeRange{}) {
+		t.Errorf("topWindow(\"\") = %+v, want every time", got)
+	}
+}